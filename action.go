@@ -0,0 +1,406 @@
+package zaplogback
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+// An Action writes one part of a log line, such as %date or %message.
+type Action interface {
+	AppendEntry(w ActionWriter, ent *zapcore.Entry, fields []zapcore.Field)
+}
+
+// ActionFunc adapts an ordinary function to the Action interface.
+type ActionFunc func(w ActionWriter, ent *zapcore.Entry, fields []zapcore.Field)
+
+func (f ActionFunc) AppendEntry(w ActionWriter, ent *zapcore.Entry, fields []zapcore.Field) {
+	f(w, ent, fields)
+}
+
+// An ActionFactory builds an Action from the config of %name{config}. The
+// config is empty when the action has no braces.
+type ActionFactory func(config string) (Action, error)
+
+// A FieldsConsumer is an Action that writes some fields itself, like %x.
+// Those fields are left out of %fields.
+type FieldsConsumer interface {
+	UsedFields() []string
+}
+
+// encoderConfigurer is implemented by the built-in actions whose config
+// overrides the EncoderConfig, e.g. %date{...} sets EncodeTime.
+type encoderConfigurer interface {
+	configure(logback_config *LogbackConfig)
+}
+
+// ActionWriter gives an Action access to the pooled buffer of the line being
+// encoded.
+type ActionWriter struct {
+	enc *logbackEncoder
+}
+
+func (w ActionWriter) Write(p []byte) (int, error) {
+	return w.enc.buf.Write(p)
+}
+
+func (w ActionWriter) WriteString(s string) (int, error) {
+	return w.enc.buf.WriteString(s)
+}
+
+func (w ActionWriter) WriteByte(c byte) error {
+	return w.enc.buf.WriteByte(c)
+}
+
+// AppendEscaped JSON-escapes s, the same way field values are written.
+func (w ActionWriter) AppendEscaped(s string) {
+	w.enc.safeAddString(s)
+}
+
+// Buffer returns the underlying buffer.
+func (w ActionWriter) Buffer() *buffer.Buffer {
+	return w.enc.buf
+}
+
+// Config returns the EncoderConfig of the encoder.
+func (w ActionWriter) Config() *zapcore.EncoderConfig {
+	return w.enc.EncoderConfig
+}
+
+// Encoder returns the encoder itself, so fields can be written with
+// zapcore.Field.AddTo and zap's level, time and caller encoders can be used.
+func (w ActionWriter) Encoder() zapcore.Encoder {
+	return w.enc
+}
+
+func (op logActionOperation) AppendEntry(w ActionWriter, ent *zapcore.Entry, fields []zapcore.Field) {
+	op(w.enc, ent, fields)
+}
+
+// overrideAction is a built-in action whose config overrides the EncoderConfig.
+type overrideAction struct {
+	logActionOperation
+	override func(logback_config *LogbackConfig)
+}
+
+func (a overrideAction) configure(logback_config *LogbackConfig) {
+	a.override(logback_config)
+}
+
+// usedFieldAction is the %x action.
+type usedFieldAction struct {
+	logActionOperation
+	field string
+}
+
+func (a usedFieldAction) UsedFields() []string {
+	return []string{a.field}
+}
+
+var (
+	_action_name_regex_pattern = regexp.MustCompile(`^\w+$`)
+	_x_config_regex_pattern    = regexp.MustCompile(`^(\w+)(:(.*)(\$0)(.*))?`)
+
+	_actionMutex         sync.RWMutex
+	_actionNameToFactory = map[string]ActionFactory{
+		"date":        newDateAction,
+		"level":       newLevelAction,
+		"caller":      newCallerAction,
+		"message":     newMessageAction,
+		"multiline":   newMultilineAction,
+		"logger":      newLoggerAction,
+		"file":        newFileAction,
+		"line":        newLineAction,
+		"method":      newMethodAction,
+		"n":           newLineEndingAction,
+		"stacktrace":  newStacktraceAction,
+		"x":           newUsedFieldAction,
+		"fields":      newFieldsAction,
+		"relative":    newRelativeAction,
+		"delta":       newDeltaAction,
+		"seq":         newSeqAction,
+		"json":        newJSONAction,
+		"limit":       newLimitAction,
+		"error":       newErrorAction,
+		"chain":       newChainAction,
+		"fingerprint": newFingerprintAction,
+		"template":    newTemplateAction,
+	}
+
+	// logback 的关键字, 与 zaplogback 的 action 同义
+	_actionAliases = map[string]string{
+		"d":         "date",
+		"p":         "level",
+		"le":        "level",
+		"m":         "message",
+		"msg":       "message",
+		"c":         "logger",
+		"lo":        "logger",
+		"X":         "x",
+		"mdc":       "x",
+		"F":         "file",
+		"L":         "line",
+		"M":         "method",
+		"ex":        "stacktrace",
+		"exception": "stacktrace",
+		"throwable": "stacktrace",
+		"r":         "relative",
+		"lsn":       "seq",
+	}
+)
+
+// canonicalActionName resolves the logback aliases, e.g. %p is %level.
+// %X without a key is %fields, like logback's %X prints the whole MDC.
+func canonicalActionName(name string, config string) string {
+	canonical, ok := _actionAliases[name]
+	if !ok {
+		return name
+	}
+	if canonical == "x" && config == "" {
+		return "fields"
+	}
+	return canonical
+}
+
+// RegisterAction registers a factory for the conversion word %name, which
+// can then be used in log formats like the built-in actions. Name must be a
+// word (letters, digits and '_') and must not be registered yet.
+func RegisterAction(name string, factory func(config string) (Action, error)) error {
+	if !_action_name_regex_pattern.MatchString(name) {
+		return fmt.Errorf("invalid action name %q", name)
+	}
+	if factory == nil {
+		return fmt.Errorf("action factory of %q is nil", name)
+	}
+
+	_actionMutex.Lock()
+	defer _actionMutex.Unlock()
+	if _, alias := _actionAliases[name]; alias {
+		return fmt.Errorf("action %q already registered", name)
+	}
+	if _, dup := _actionNameToFactory[name]; dup {
+		return fmt.Errorf("action %q already registered", name)
+	}
+	_actionNameToFactory[name] = factory
+	return nil
+}
+
+func actionFactoryOf(name string) (ActionFactory, bool) {
+	_actionMutex.RLock()
+	defer _actionMutex.RUnlock()
+	factory, ok := _actionNameToFactory[name]
+	return factory, ok
+}
+
+func newDateAction(config string) (Action, error) {
+	if config == "" {
+		return logActionOperation(logAddTimeAction), nil
+	}
+	layout, err := StrftimeLayoutOf(config)
+	if err != nil {
+		return nil, err
+	}
+	// 自定义时间格式
+	encode_time := TimeEncoderOf(layout)
+	return overrideAction{logAddTimeAction, func(logback_config *LogbackConfig) {
+		logback_config.EncodeTime = encode_time
+	}}, nil
+}
+
+// StrftimeLayoutOf returns the strftime layout of a %date config. A config
+// without '%' is a logback SimpleDateFormat, e.g. %d{yyyy-MM-dd HH:mm:ss.SSS},
+// and ISO8601 is logback's default format.
+func StrftimeLayoutOf(config string) (string, error) {
+	if strings.Contains(config, "%") {
+		return config, nil
+	}
+	if config == "ISO8601" {
+		config = _logback_default_date_format
+	}
+	strftime, diagnostics := TranslateSimpleDateFormat(config)
+	if len(diagnostics) > 0 {
+		return "", errors.New(diagnostics[0].Message)
+	}
+	return strftime, nil
+}
+
+func newLevelAction(config string) (Action, error) {
+	if config == "" {
+		return logActionOperation(logAddLevelAction), nil
+	}
+	encode_level, err := ParseLevelEncoder(config)
+	if err != nil {
+		return nil, err
+	}
+	return overrideAction{logAddLevelAction, func(logback_config *LogbackConfig) {
+		logback_config.EncodeLevel = encode_level
+	}}, nil
+}
+
+func newCallerAction(config string) (Action, error) {
+	if config == "" {
+		return logActionOperation(logAddCallerAction), nil
+	}
+	encode_caller := CallerEncoderOf(config)
+	return overrideAction{logAddCallerAction, func(logback_config *LogbackConfig) {
+		logback_config.EncodeCaller = encode_caller
+	}}, nil
+}
+
+// %message, %message{escape=safe} or %message{template,escape=safe}
+func newMessageAction(config string) (Action, error) {
+	if config == "" {
+		return logActionOperation(logAddMsgAction), nil
+	}
+	escape, template, err := parseMessageConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return messageAction{escape: escape, template: template}, nil
+}
+
+// parseMessageConfig returns the escape and the template option of
+// %message{...}, "" when they are not set.
+func parseMessageConfig(config string) (string, string, error) {
+	var escape, template string
+	for _, option := range strings.Split(config, ",") {
+		option = strings.TrimSpace(option)
+		switch {
+		case strings.HasPrefix(option, "escape="):
+			escape = strings.TrimPrefix(option, "escape=")
+			if err := checkMessageEscape(escape); err != nil {
+				return "", "", err
+			}
+		case option == "template":
+			template = "remove"
+		case option == "template=keep" || option == "template=remove":
+			template = strings.TrimPrefix(option, "template=")
+		default:
+			return "", "", fmt.Errorf("unknown message config %q, want escape=none|json|quote|safe or template[=keep|remove]", option)
+		}
+	}
+	return escape, template, nil
+}
+
+// %logger or %logger{36}
+func newLoggerAction(config string) (Action, error) {
+	if config == "" {
+		return logActionOperation(logAddNameAction), nil
+	}
+	length, err := strconv.Atoi(config)
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("invalid logger length %q", config)
+	}
+	return logAddAbbreviatedNameAction(length), nil
+}
+
+// %file or %file{full}
+func newFileAction(config string) (Action, error) {
+	switch config {
+	case "":
+		return logAddFileAction(false), nil
+	case "full":
+		return logAddFileAction(true), nil
+	}
+	return nil, fmt.Errorf("unknown file config %q", config)
+}
+
+func newLineAction(config string) (Action, error) {
+	return logActionOperation(logAddLineAction), nil
+}
+
+func newMethodAction(config string) (Action, error) {
+	return logActionOperation(logAddMethodAction), nil
+}
+
+func newLineEndingAction(config string) (Action, error) {
+	return logActionOperation(logAddLineEndingAction), nil
+}
+
+func newStacktraceAction(config string) (Action, error) {
+	return overrideAction{logAddStacktraceAction, func(logback_config *LogbackConfig) {
+		logback_config.writes_stack = true
+	}}, nil
+}
+
+// %x{tid} or %x{tid:["tid":$0]}
+func newUsedFieldAction(config string) (Action, error) {
+	// 从fields 中取出自定义变量
+	adv_config := _x_config_regex_pattern.FindStringSubmatch(config)
+	if adv_config == nil {
+		return nil, fmt.Errorf("%%x needs a field name, got %q", config)
+	}
+	field := adv_config[1]
+	op := logAddUsedFieldAction(field, []byte(adv_config[3]), []byte(adv_config[5]))
+	return usedFieldAction{op, field}, nil
+}
+
+// fieldsAction is %fields{encrypt=customer_id,card_ref}, the values of the
+// listed keys are encrypted with the default keyring wherever they are
+// written, see SetDefaultKeyring. The value encoders of %fields{time=...,
+// duration=...} apply to %x as well, see valueFormat.
+type fieldsAction struct {
+	logActionOperation
+	encrypt []string
+	values  *valueFormat
+}
+
+func newFieldsAction(config string) (Action, error) {
+	if config == "" {
+		return logActionOperation(logAddRemindFieldAction), nil
+	}
+	keys, values, err := parseFieldsConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return fieldsAction{logActionOperation: logAddRemindFieldAction, encrypt: keys, values: values}, nil
+}
+
+func (a fieldsAction) configure(logback_config *LogbackConfig) {
+	if a.values != nil {
+		logback_config.value_format = a.values
+	}
+}
+
+// encryptedFieldsOf returns the keys of the %fields{encrypt=...} elements
+// of a compiled pattern.
+func encryptedFieldsOf(elements []PatternAction) []string {
+	var keys []string
+	for _, element := range elements {
+		if a, ok := element.Action.(fieldsAction); ok {
+			keys = append(keys, a.encrypt...)
+		}
+	}
+	return keys
+}
+
+func newRelativeAction(config string) (Action, error) {
+	if config != "" {
+		return nil, fmt.Errorf("%%relative has no config, got %q", config)
+	}
+	return logActionOperation(logAddRelativeAction), nil
+}
+
+func newDeltaAction(config string) (Action, error) {
+	if config != "" {
+		return nil, fmt.Errorf("%%delta has no config, got %q", config)
+	}
+	return logAddDeltaAction(), nil
+}
+
+func newSeqAction(config string) (Action, error) {
+	switch config {
+	case "":
+		return logAddSeqAction(new(atomic.Uint64)), nil
+	case "global":
+		return logAddSeqAction(&_global_seq), nil
+	}
+	return nil, fmt.Errorf("unknown %%seq option %q, want global", config)
+}
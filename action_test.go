@@ -0,0 +1,51 @@
+package zaplogback
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestRegisterAction(t *testing.T) {
+	err := RegisterAction("tenant", func(config string) (Action, error) {
+		return tenantAction{config}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := encodeForTest(t, `[%tenant{acme}] %message %fields`, zapcore.Entry{Message: "hi"},
+		zap.String("tenant", "t1"), zap.Int("n", 1))
+	if want := `[acme/t1] hi {"n":1}`; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if err := RegisterAction("tenant", nil); err == nil {
+		t.Error("expected an error for a nil factory")
+	}
+	if err := RegisterAction("date", func(string) (Action, error) { return nil, nil }); err == nil {
+		t.Error("expected an error when registering a built-in name")
+	}
+	if err := RegisterAction("bad name", func(string) (Action, error) { return nil, nil }); err == nil {
+		t.Error("expected an error for an invalid name")
+	}
+}
+
+type tenantAction struct {
+	prefix string
+}
+
+func (a tenantAction) AppendEntry(w ActionWriter, ent *zapcore.Entry, fields []zapcore.Field) {
+	w.WriteString(a.prefix)
+	for _, f := range fields {
+		if f.Key == "tenant" {
+			w.WriteByte('/')
+			w.AppendEscaped(f.String)
+		}
+	}
+}
+
+func (a tenantAction) UsedFields() []string {
+	return []string{"tenant"}
+}
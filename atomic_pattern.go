@@ -0,0 +1,224 @@
+package zaplogback
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// An AtomicPattern is an atomically changeable, dynamic log format, like
+// zap.AtomicLevel. Encoders built from it pick up a new pattern on their next
+// entry, so the format can be changed at runtime without rebuilding loggers.
+//
+// AtomicPattern must be created with NewAtomicPattern; copies share the
+// same pattern.
+type AtomicPattern struct {
+	p *atomic.Pointer[Pattern]
+}
+
+// NewAtomicPattern compiles log_format into a new AtomicPattern.
+func NewAtomicPattern(log_format string) (AtomicPattern, error) {
+	pattern, err := Compile(log_format)
+	if err == nil {
+		err = pattern.checkKeyring()
+	}
+	if err == nil {
+		err = pattern.checkChain()
+	}
+	if err != nil {
+		return AtomicPattern{}, err
+	}
+	return NewAtomicPatternAt(pattern), nil
+}
+
+// NewAtomicPatternAt returns a new AtomicPattern set to pattern.
+func NewAtomicPatternAt(pattern *Pattern) AtomicPattern {
+	ap := AtomicPattern{p: new(atomic.Pointer[Pattern])}
+	ap.p.Store(pattern)
+	return ap
+}
+
+// Pattern returns the current pattern.
+func (ap AtomicPattern) Pattern() *Pattern {
+	return ap.p.Load()
+}
+
+// SetPattern replaces the pattern.
+func (ap AtomicPattern) SetPattern(pattern *Pattern) {
+	ap.p.Store(pattern)
+}
+
+// SetLogFormat compiles log_format and replaces the pattern with it. The
+// pattern is left unchanged if log_format is invalid, has %chain, or has
+// %fields{encrypt=...} without a keyring.
+func (ap AtomicPattern) SetLogFormat(log_format string) error {
+	pattern, err := Compile(log_format)
+	if err == nil {
+		err = pattern.checkKeyring()
+	}
+	if err == nil {
+		err = pattern.checkChain()
+	}
+	if err != nil {
+		return err
+	}
+	ap.SetPattern(pattern)
+	return nil
+}
+
+// String returns the canonical form of the current pattern.
+func (ap AtomicPattern) String() string {
+	return ap.Pattern().String()
+}
+
+// MarshalText marshals the current pattern to its canonical form.
+func (ap AtomicPattern) MarshalText() ([]byte, error) {
+	return []byte(ap.String()), nil
+}
+
+// UnmarshalText compiles text and replaces the pattern with it. It creates
+// the underlying pointer when called on a zero AtomicPattern.
+func (ap *AtomicPattern) UnmarshalText(text []byte) error {
+	pattern, err := Compile(string(text))
+	if err != nil {
+		return err
+	}
+	if ap.p == nil {
+		ap.p = new(atomic.Pointer[Pattern])
+	}
+	ap.p.Store(pattern)
+	return nil
+}
+
+// NewEncoder builds an encoder that always writes with the current pattern.
+// Every EncodeEntry call loads the pattern once, so an entry is never
+// written with a mix of two patterns. %message without escape= uses
+// DefaultMessageEscape.
+func (ap AtomicPattern) NewEncoder(cfg zapcore.EncoderConfig) zapcore.Encoder {
+	encoder := newZaplogbackEncoder(cfg)
+	encoder.atomic_pattern = ap.p
+	encoder.pattern_state = new(atomic.Pointer[patternState])
+	// 格式可能随时改为带 %json{fields} 的格式, With 的上下文总是同时保留为 JSON
+	encoder.json_context = zapcore.NewJSONEncoder(jsonFieldsConfig(encoder.EncoderConfig))
+	return encoder.encoder()
+}
+
+// RegisterAtomicLogbackEncoder registers an encoding whose encoders follow ap.
+func RegisterAtomicLogbackEncoder(encoding string, ap AtomicPattern) error {
+	if encoding == "" {
+		encoding = _default_encoding_name
+	}
+
+	err := zap.RegisterEncoder(encoding, func(encoderConfig zapcore.EncoderConfig) (zapcore.Encoder, error) {
+		return ap.NewEncoder(encoderConfig), nil
+	})
+
+	if err != nil {
+		return fmt.Errorf("encoding %q already exists", encoding)
+	}
+	return nil
+}
+
+// patternState caches the EncoderConfig of an encoder with the overrides of
+// one pattern applied, e.g. %date{...}.
+type patternState struct {
+	pattern   *Pattern
+	cfg       *zapcore.EncoderConfig
+	encrypter *Masker
+}
+
+// ServeHTTP is a simple JSON endpoint that can report on or change the
+// current pattern, modeled on zap.AtomicLevel.ServeHTTP.
+//
+// # GET
+//
+// The GET request returns a JSON description of the current pattern:
+//
+//	{"pattern": "%date %level %message %fields"}
+//
+// # PUT
+//
+// The PUT request changes the pattern. It is perfectly safe to change the
+// pattern while a program is running. An invalid pattern is rejected with
+// 400 Bad Request and the current pattern is kept. Two content types are
+// supported:
+//
+//	Content-Type: application/x-www-form-urlencoded
+//
+// With this content type, the pattern can be provided through the request
+// body or a query parameter:
+//
+//	pattern=%date %level %caller %x{tid} %message %fields
+//
+//	Content-Type: application/json
+//
+// With this content type, the request body is expected to be a JSON object
+// like the GET response.
+func (ap AtomicPattern) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := ap.serveHTTP(w, r); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "internal error: %v", err)
+	}
+}
+
+type patternPayload struct {
+	Pattern string `json:"pattern"`
+}
+
+type patternErrorResponse struct {
+	Error string `json:"error"`
+}
+
+func (ap AtomicPattern) serveHTTP(w http.ResponseWriter, r *http.Request) error {
+	enc := json.NewEncoder(w)
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		return enc.Encode(patternPayload{Pattern: ap.String()})
+
+	case http.MethodPut:
+		log_format, err := decodePutPatternRequest(r)
+		if err == nil {
+			err = ap.SetLogFormat(log_format)
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return enc.Encode(patternErrorResponse{Error: err.Error()})
+		}
+		return enc.Encode(patternPayload{Pattern: ap.String()})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return enc.Encode(patternErrorResponse{
+			Error: "Only GET and PUT are supported.",
+		})
+	}
+}
+
+func decodePutPatternRequest(r *http.Request) (string, error) {
+	media_type, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if media_type == "application/x-www-form-urlencoded" {
+		log_format := r.FormValue("pattern")
+		if log_format == "" {
+			return "", errors.New("must specify pattern")
+		}
+		return log_format, nil
+	}
+
+	var payload patternPayload
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&payload); err != nil {
+		return "", fmt.Errorf("malformed request body: %v", err)
+	}
+	if payload.Pattern == "" {
+		return "", errors.New("must specify pattern")
+	}
+	return payload.Pattern, nil
+}
@@ -0,0 +1,125 @@
+package zaplogback
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestAtomicPatternSwap(t *testing.T) {
+	ap, err := NewAtomicPattern(`%level{upper} %message %fields`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := zap.NewProductionEncoderConfig()
+	cfg.SkipLineEnding = true
+	enc := ap.NewEncoder(cfg).Clone()
+
+	encode := func() string {
+		buf, err := enc.EncodeEntry(zapcore.Entry{Message: "hi"}, []zapcore.Field{zap.String("tid", "t1")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer buf.Free()
+		return buf.String()
+	}
+
+	if got, want := encode(), `INFO hi {"tid":t1}`; got != want {
+		t.Errorf("before swap: got %q, want %q", got, want)
+	}
+	if err := ap.SetLogFormat(`%level{lower} [%x{tid}] %message %fields`); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := encode(), `info [t1] hi {}`; got != want {
+		t.Errorf("after swap: got %q, want %q", got, want)
+	}
+	if err := ap.SetLogFormat(`%level{bogus}`); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+	if got, want := ap.String(), `%level{lower} [%x{tid}] %message %fields`; got != want {
+		t.Errorf("invalid pattern replaced the current one: %q", got)
+	}
+}
+
+func TestAtomicPatternServeHTTP(t *testing.T) {
+	ap, err := NewAtomicPattern(`%level %message`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(ap)
+	defer srv.Close()
+
+	tests := []struct {
+		method      string
+		contentType string
+		body        string
+		wantCode    int
+		wantBody    string
+	}{
+		{http.MethodGet, "", "", http.StatusOK, `{"pattern":"%level %message"}`},
+		{http.MethodPut, "application/json", `{"pattern":"%caller %message"}`, http.StatusOK, `{"pattern":"%caller %message"}`},
+		{http.MethodPut, "application/x-www-form-urlencoded", `pattern=%25level{upper}+%25message`, http.StatusOK, `{"pattern":"%level{upper} %message"}`},
+		{http.MethodPut, "application/json", `{"pattern":"%level{bogus}"}`, http.StatusBadRequest, `{"error":"%level: unknown level config \"bogus\""}`},
+		{http.MethodPut, "application/json", `{}`, http.StatusBadRequest, `{"error":"must specify pattern"}`},
+		{http.MethodPost, "", "", http.StatusMethodNotAllowed, `{"error":"Only GET and PUT are supported."}`},
+		{http.MethodGet, "", "", http.StatusOK, `{"pattern":"%level{upper} %message"}`},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, srv.URL, strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.contentType != "" {
+			req.Header.Set("Content-Type", tt.contentType)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := new(bytes.Buffer)
+		_, _ = body.ReadFrom(res.Body)
+		res.Body.Close()
+
+		if res.StatusCode != tt.wantCode || strings.TrimSpace(body.String()) != tt.wantBody {
+			t.Errorf("%s %q: got %d %s, want %d %s", tt.method, tt.body, res.StatusCode, body, tt.wantCode, tt.wantBody)
+		}
+	}
+}
+
+func TestAtomicPatternJSONContext(t *testing.T) {
+	ap, err := NewAtomicPattern(`%message %fields`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := zap.NewProductionEncoderConfig()
+	cfg.SkipLineEnding = true
+	enc := ap.NewEncoder(cfg)
+	zap.String("tid", "t1").AddTo(enc)
+
+	encode := func() string {
+		buf, err := enc.EncodeEntry(zapcore.Entry{Message: "hi"}, []zapcore.Field{zap.Int("n", 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer buf.Free()
+		return buf.String()
+	}
+
+	if got, want := encode(), `hi {"n":1} "tid":t1`; got != want {
+		t.Errorf("%%fields: got %q, want %q", got, want)
+	}
+	// With 之后才换成 %json{fields} 的格式, 上下文也在对象中
+	ap.SetLogFormat(`%message %json{fields}`)
+	if got, want := encode(), `hi {"tid":"t1","n":1}`; got != want {
+		t.Errorf("%%json{fields}: got %q, want %q", got, want)
+	}
+	ap.SetLogFormat(`%message %fields`)
+	if got, want := encode(), `hi {"n":1} "tid":t1`; got != want {
+		t.Errorf("back to %%fields: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,423 @@
+package zaplogback
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// _chain_mac_size is the size of the truncated HMAC-SHA256 of each line.
+const _chain_mac_size = 16
+
+var (
+	_chain_text_suffix_regex = regexp.MustCompile(` chain=([0-9a-f]{32})$`)
+	_chain_json_suffix_regex = regexp.MustCompile(`,?"chain":"([0-9a-f]{32})"\}$`)
+	_chain_text_event_regex  = regexp.MustCompile(`^#chain (start|checkpoint) seq=(\d+) time=\S+(?: prev=([0-9a-f]{32}))?$`)
+	_chain_json_event_regex  = regexp.MustCompile(`^\{"chain_event":"(start|checkpoint)","seq":(\d+),"time":"[^"]*"(?:,"prev":"([0-9a-f]{32})")?\}$`)
+)
+
+// chainAction is the %chain{keyfile=/etc/app/chain.key,checkpoint=1000}
+// marker, it writes nothing. The MACs are appended by the ChainWriter of
+// the output, see Pattern.WrapWriter.
+type chainAction struct {
+	logActionOperation
+	key        []byte
+	checkpoint int
+}
+
+func newChainAction(config string) (Action, error) {
+	a := &chainAction{logActionOperation: logAddNothingAction}
+	for _, option := range strings.Split(config, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(option), "=")
+		switch name {
+		case "keyfile":
+			key, err := LoadChainKey(value)
+			if err != nil {
+				return nil, err
+			}
+			a.key = key
+		case "checkpoint":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid checkpoint %q, want a number of lines", value)
+			}
+			a.checkpoint = n
+		default:
+			return nil, fmt.Errorf("unknown %%chain option %q, want keyfile=<path> or checkpoint=<lines>", option)
+		}
+	}
+	if a.key == nil {
+		return nil, fmt.Errorf("%%chain needs keyfile=<path>")
+	}
+	return a, nil
+}
+
+func (a *chainAction) configure(logback_config *LogbackConfig) {
+	logback_config.chain = a
+}
+
+// LoadChainKey reads the HMAC key of a hash chain, a base64 line of 16
+// bytes or more, e.g. from `openssl rand -base64 32`.
+func LoadChainKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(key) < 16 {
+		return nil, fmt.Errorf("%s: the key has %d bytes, want 16 or more", path, len(key))
+	}
+	return key, nil
+}
+
+// chainMAC is the hex HMAC-SHA256 of the previous MAC and a line, truncated
+// to 128 bits.
+func chainMAC(key []byte, prev string, line []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(prev))
+	mac.Write([]byte{'\n'})
+	mac.Write(line)
+	return hex.EncodeToString(mac.Sum(nil)[:_chain_mac_size])
+}
+
+// A ChainWriter appends to every entry written through it an HMAC-SHA256 of
+// the entry and of the MAC of the previous entry, ` chain=<mac>` at the end
+// of a text line and a "chain" member in a JSON object. Deleting, editing
+// or inserting lines breaks the chain, see ChainVerifier. It writes a start
+// line first, and a checkpoint line with the count of entries every
+// checkpoint entries when it is not 0. The start line of a writer resumed
+// on an existing file records the last MAC of the file, see Resume.
+//
+// It locks around each write, so the chain follows the order of the lines
+// in the file; zap writes each entry with one Write.
+type ChainWriter struct {
+	mu         sync.Mutex
+	out        zapcore.WriteSyncer
+	key        []byte
+	checkpoint int
+	prev       string
+	seq        int
+	started    bool
+	line       bytes.Buffer
+}
+
+// NewChainWriter returns a ChainWriter writing to out.
+func NewChainWriter(out zapcore.WriteSyncer, key []byte, checkpoint int) *ChainWriter {
+	return &ChainWriter{out: out, key: key, checkpoint: checkpoint}
+}
+
+// Resume continues the chain of an existing output whose last MAC is prev,
+// e.g. from LastChainMAC, so that deleting the lines before the start line
+// of a restart breaks the chain. It must be called before the first Write.
+func (w *ChainWriter) Resume(prev string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.started {
+		w.prev = prev
+	}
+}
+
+func (w *ChainWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	body, ending := splitLineEnding(p)
+	json := isJSONObject(body)
+	w.line.Reset()
+	if !w.started {
+		w.started = true
+		w.appendEvent("start", json, ending)
+	}
+	w.appendChained(body, json, ending)
+	w.seq++
+	if w.checkpoint > 0 && w.seq%w.checkpoint == 0 {
+		w.appendEvent("checkpoint", json, ending)
+	}
+	if _, err := w.out.Write(w.line.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *ChainWriter) Sync() error {
+	return w.out.Sync()
+}
+
+// appendEvent appends a start or checkpoint line.
+func (w *ChainWriter) appendEvent(event string, json bool, ending []byte) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	var line string
+	if json {
+		line = fmt.Sprintf(`{"chain_event":%q,"seq":%d,"time":%q`, event, w.seq, now)
+		if w.prev != "" {
+			line += `,"prev":"` + w.prev + `"`
+		}
+		line += "}"
+	} else {
+		line = fmt.Sprintf("#chain %s seq=%d time=%s", event, w.seq, now)
+		if w.prev != "" {
+			// 重启后的 start 行记录文件中上一条的 MAC
+			line += " prev=" + w.prev
+		}
+	}
+	if len(ending) == 0 {
+		ending = []byte{'\n'}
+	}
+	w.appendChained([]byte(line), json, ending)
+}
+
+func (w *ChainWriter) appendChained(body []byte, json bool, ending []byte) {
+	mac := chainMAC(w.key, w.prev, body)
+	w.prev = mac
+	if json {
+		w.line.Write(body[:len(body)-1])
+		if len(body) > 2 {
+			w.line.WriteByte(',')
+		}
+		w.line.WriteString(`"chain":"` + mac + `"}`)
+	} else {
+		w.line.Write(body)
+		w.line.WriteString(" chain=" + mac)
+	}
+	w.line.Write(ending)
+}
+
+// splitLineEnding splits the trailing "\n" or "\r\n" off an entry.
+func splitLineEnding(p []byte) ([]byte, []byte) {
+	n := 0
+	if bytes.HasSuffix(p, []byte("\r\n")) {
+		n = 2
+	} else if bytes.HasSuffix(p, []byte("\n")) {
+		n = 1
+	}
+	return p[:len(p)-n], p[len(p)-n:]
+}
+
+func isJSONObject(body []byte) bool {
+	return len(body) >= 2 && body[0] == '{' && body[len(body)-1] == '}'
+}
+
+// errChainWriter is the error of %chain where no ChainWriter is added.
+var errChainWriter = errors.New("%chain needs the ChainWriter of Pattern.WrapWriter or Config.Build, build the encoder with Pattern.NewEncoder and wrap the writer")
+
+// checkChain returns an error when the pattern has %chain, for the encoders
+// built without a writer, whose lines would have no MAC.
+func (p *Pattern) checkChain() error {
+	if p.logback_config.chain != nil {
+		return errChainWriter
+	}
+	return nil
+}
+
+// WrapWriter returns w wrapped in a ChainWriter when the pattern has
+// %chain, w otherwise.
+func (p *Pattern) WrapWriter(w zapcore.WriteSyncer) zapcore.WriteSyncer {
+	chain := p.logback_config.chain
+	if chain == nil {
+		return w
+	}
+	return NewChainWriter(w, chain.key, chain.checkpoint)
+}
+
+// ChainBreak is the error of ChainVerifier at the first line that breaks
+// the chain.
+type ChainBreak struct {
+	// Line is the line number in the input, starting at 1.
+	Line   int
+	Reason string
+}
+
+func (e *ChainBreak) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Reason)
+}
+
+// A ChainVerifier checks the chain of the lines written by a ChainWriter.
+// Files are verified in the order they were written, e.g. rotated files
+// from the oldest, with the chain carried from one to the next. The input
+// must begin with a start or checkpoint line, unless AllowPartial is set
+// for a chain that begins in a file not given: the entries before its first
+// checkpoint can not be verified, and are only counted.
+//
+// A start line without prev begins a new chain, as written by a ChainWriter
+// that was not resumed, e.g. on stdout; the lines deleted before it can not
+// be noticed.
+type ChainVerifier struct {
+	key  []byte
+	prev string
+	// known is false until the previous MAC is known.
+	known bool
+	line  int
+
+	// Entries is the number of verified entries, Checkpoints the number of
+	// start and checkpoint lines, Unverified the entries before the first.
+	Entries, Checkpoints, Unverified int
+	// AllowPartial accepts an input that begins in the middle of a chain.
+	AllowPartial bool
+}
+
+// NewChainVerifier returns a verifier for the key of the writer.
+func NewChainVerifier(key []byte) *ChainVerifier {
+	return &ChainVerifier{key: key}
+}
+
+// Verify reads r to the end, the error is a *ChainBreak when the chain is
+// broken.
+func (v *ChainVerifier) Verify(r io.Reader) error {
+	reader := bufio.NewReader(r)
+	v.line = 0
+	var entry []byte
+	first_line := 0
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			v.line++
+			if len(entry) == 0 {
+				first_line = v.line
+			}
+			line = bytes.TrimSuffix(line, []byte{'\n'})
+			entry = append(entry, line...)
+			body, mac, json, ok := splitChainMAC(bytes.TrimSuffix(entry, []byte{'\r'}))
+			if ok {
+				if err := v.check(body, mac, json, first_line); err != nil {
+					return err
+				}
+				entry = entry[:0]
+			} else {
+				// 多行的日志, 如 stacktrace, MAC 在最后一行
+				entry = append(entry, '\n')
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(bytes.TrimSpace(entry)) > 0 {
+		return &ChainBreak{Line: first_line, Reason: "no chain MAC, lines were appended or the last line was cut"}
+	}
+	return nil
+}
+
+func (v *ChainVerifier) check(body []byte, mac string, json bool, line int) error {
+	event, prev, is_event := parseChainEvent(body, json)
+	if is_event {
+		v.Checkpoints++
+		switch {
+		case event == "start" && prev == "":
+			// 新的链
+		case v.known && prev != v.prev && event == "start":
+			return &ChainBreak{Line: line, Reason: "the start line does not follow the line before it, lines were deleted before the restart"}
+		case v.known && prev != v.prev:
+			return &ChainBreak{Line: line, Reason: "the checkpoint does not follow the line before it, lines were deleted"}
+		}
+		if chainMAC(v.key, prev, body) != mac {
+			return &ChainBreak{Line: line, Reason: "MAC mismatch, the checkpoint was edited"}
+		}
+	} else if !v.known {
+		if !v.AllowPartial {
+			return &ChainBreak{Line: line, Reason: "no start or checkpoint line before the entry, lines were deleted from the beginning"}
+		}
+		v.Unverified++
+	} else {
+		if chainMAC(v.key, v.prev, body) != mac {
+			return &ChainBreak{Line: line, Reason: "MAC mismatch, the line was edited, or lines before it were deleted or inserted"}
+		}
+		v.Entries++
+	}
+	v.prev, v.known = mac, true
+	return nil
+}
+
+// splitChainMAC splits a chained entry into its body and MAC.
+func splitChainMAC(entry []byte) ([]byte, string, bool, bool) {
+	if m := _chain_text_suffix_regex.FindSubmatchIndex(entry); m != nil {
+		return entry[:m[0]], string(entry[m[2]:m[3]]), false, true
+	}
+	if len(entry) > 0 && entry[0] == '{' {
+		if m := _chain_json_suffix_regex.FindSubmatchIndex(entry); m != nil {
+			body := append(entry[:m[0]:m[0]], '}')
+			return body, string(entry[m[2]:m[3]]), true, true
+		}
+	}
+	return nil, "", false, false
+}
+
+// parseChainEvent reads a start or checkpoint line.
+func parseChainEvent(body []byte, json bool) (string, string, bool) {
+	regex := _chain_text_event_regex
+	if json {
+		regex = _chain_json_event_regex
+	}
+	m := regex.FindSubmatch(body)
+	if m == nil {
+		return "", "", false
+	}
+	return string(m[1]), string(m[3]), true
+}
+
+// _chain_tail_size is how much of the end of a file LastChainMAC reads.
+const _chain_tail_size = 64 << 10
+
+// LastChainMAC returns the MAC of the last chained line of the file at
+// path, "" when it has none or can not be read.
+func LastChainMAC(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return ""
+	}
+	offset := max(info.Size()-_chain_tail_size, 0)
+	tail := make([]byte, info.Size()-offset)
+	if _, err := f.ReadAt(tail, offset); err != nil && !errors.Is(err, io.EOF) {
+		return ""
+	}
+	lines := bytes.Split(bytes.TrimRight(tail, "\r\n"), []byte{'\n'})
+	for i := len(lines) - 1; i >= 0; i-- {
+		if _, mac, _, ok := splitChainMAC(bytes.TrimSuffix(lines[i], []byte{'\r'})); ok {
+			return mac
+		}
+	}
+	return ""
+}
+
+// lastChainMACOf returns the last MAC of the first file of paths, as zap.Open
+// takes them, that has one.
+func lastChainMACOf(paths []string) string {
+	for _, path := range paths {
+		if path == "stdout" || path == "stderr" {
+			continue
+		}
+		if file, ok := strings.CutPrefix(path, "file://"); ok {
+			path = file
+		} else if strings.Contains(path, "://") {
+			continue
+		}
+		if mac := LastChainMAC(path); mac != "" {
+			return mac
+		}
+	}
+	return ""
+}
@@ -0,0 +1,240 @@
+package zaplogback
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// chainKeyForTest writes a key file and returns its path and key.
+func chainKeyForTest(t *testing.T) (string, []byte) {
+	t.Helper()
+	key := bytes.Repeat([]byte{7}, 32)
+	path := filepath.Join(t.TempDir(), "chain.key")
+	if err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path, key
+}
+
+func verifyForTest(key []byte, texts ...string) (*ChainVerifier, error) {
+	v := NewChainVerifier(key)
+	for _, text := range texts {
+		if err := v.Verify(strings.NewReader(text)); err != nil {
+			return v, err
+		}
+	}
+	return v, nil
+}
+
+func breakLine(err error) int {
+	var chain_break *ChainBreak
+	if errors.As(err, &chain_break) {
+		return chain_break.Line
+	}
+	return -1
+}
+
+func TestChain(t *testing.T) {
+	key_file, key := chainKeyForTest(t)
+	path := filepath.Join(t.TempDir(), "audit.log")
+	config := `
+outputPaths: ["` + path + `"]
+pattern: "%level %message %fields%chain{keyfile=` + key_file + `,checkpoint=2}"
+`
+	cfg, err := ParseConfig([]byte(config), "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger, err := cfg.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("login", zap.String("user", "li"))
+	logger.Warn("denied", zap.String("user", "li"))
+	logger.Info("logout")
+	_ = logger.Sync()
+
+	data, _ := os.ReadFile(path)
+	text := string(data)
+	shape := regexp.MustCompile(`time=\S+|[0-9a-f]{32}`).ReplaceAllStringFunc(text, func(s string) string {
+		if strings.HasPrefix(s, "time=") {
+			return "time=T"
+		}
+		return "MAC"
+	})
+	want := "#chain start seq=0 time=T chain=MAC\n" +
+		"info login {\"user\":li} chain=MAC\n" +
+		"warn denied {\"user\":li} chain=MAC\n" +
+		"#chain checkpoint seq=2 time=T prev=MAC chain=MAC\n" +
+		"info logout {} chain=MAC\n"
+	if shape != want {
+		t.Fatalf("got\n%s\nwant\n%s", shape, want)
+	}
+
+	v, err := verifyForTest(key, text)
+	if err != nil || v.Entries != 3 || v.Checkpoints != 2 || v.Unverified != 0 {
+		t.Fatalf("Verify = %v, %+v", err, v)
+	}
+
+	lines := strings.SplitAfter(text, "\n")
+	join := func(lines ...string) string { return strings.Join(lines, "") }
+	tests := []struct {
+		name string
+		text string
+		line int
+	}{
+		{"edited", join(lines[0], strings.Replace(lines[1], "li", "wang", 1), lines[2]), 2},
+		{"deleted", join(lines[0], lines[2], lines[3]), 2},
+		{"inserted", join(lines[0], lines[1], "info forged {} chain=00000000000000000000000000000000\n", lines[2]), 3},
+		{"reordered", join(lines[0], lines[2], lines[1]), 2},
+		{"deleted before a checkpoint", join(lines[0], lines[1], lines[3]), 3},
+		{"appended", join(lines[0], lines[1], "info forged {}\n"), 3},
+	}
+	for _, tt := range tests {
+		if _, err := verifyForTest(key, tt.text); breakLine(err) != tt.line {
+			t.Errorf("%s: got %v, want a break at line %d", tt.name, err, tt.line)
+		}
+	}
+	if _, err := verifyForTest(bytes.Repeat([]byte{8}, 32), text); breakLine(err) != 1 {
+		t.Errorf("wrong key: got %v", err)
+	}
+
+	// 删除开头的 start 行
+	if _, err := verifyForTest(key, join(lines[1:]...)); breakLine(err) != 1 {
+		t.Errorf("deleted start line: got %v", err)
+	}
+	// 轮转后的文件从检查点开始验证
+	v = NewChainVerifier(key)
+	v.AllowPartial = true
+	if err := v.Verify(strings.NewReader(join(lines[2:]...))); err != nil || v.Entries != 1 || v.Unverified != 1 {
+		t.Errorf("rotated: %v, %+v", err, v)
+	}
+	v, err = verifyForTest(key, join(lines[:2]...), join(lines[2:]...))
+	if err != nil || v.Entries != 3 {
+		t.Errorf("across files: %v, %+v", err, v)
+	}
+
+	p, err := NewParser(cfg.Pattern, cfg.EncoderConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, ok := p.ParseRecord(strings.TrimSuffix(lines[1], "\n"))
+	if value, _ := r.Field("user"); !ok || r.Message != "login" || value != "li" {
+		t.Errorf("ParseRecord = %+v, %v", r, ok)
+	}
+}
+
+func TestChainRestart(t *testing.T) {
+	key_file, key := chainKeyForTest(t)
+	path := filepath.Join(t.TempDir(), "audit.log")
+	config := `
+outputPaths: ["` + path + `"]
+pattern: "%level %message%chain{keyfile=` + key_file + `}"
+`
+	cfg, err := ParseConfig([]byte(config), "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 两次启动写同一个文件
+	for _, msg := range []string{"first", "second"} {
+		logger, err := cfg.Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		logger.Info(msg)
+		logger.Info(msg + " again")
+		_ = logger.Sync()
+	}
+
+	data, _ := os.ReadFile(path)
+	lines := strings.SplitAfter(string(data), "\n")
+	if !strings.HasPrefix(lines[3], "#chain start seq=0") || !strings.Contains(lines[3], " prev="+chainMACOfLine(lines[2])) {
+		t.Fatalf("the start line of the restart does not record the last MAC:\n%s", data)
+	}
+	if v, err := verifyForTest(key, string(data)); err != nil || v.Entries != 4 || v.Checkpoints != 2 {
+		t.Fatalf("Verify = %v, %+v", err, v)
+	}
+	// 删除重启前的两行
+	if _, err := verifyForTest(key, lines[0]+strings.Join(lines[3:], "")); breakLine(err) != 2 {
+		t.Errorf("deleted before the restart: got %v", err)
+	}
+}
+
+// chainMACOfLine returns the MAC at the end of a line.
+func chainMACOfLine(line string) string {
+	_, mac, _, _ := splitChainMAC([]byte(strings.TrimSuffix(line, "\n")))
+	return mac
+}
+
+func TestChainJSONLayout(t *testing.T) {
+	key_file, key := chainKeyForTest(t)
+	pattern, err := Compile(`%message %fields %chain{keyfile=` + key_file + `}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	cfg := zap.NewProductionEncoderConfig()
+	// 同一个文件上两次启动
+	for _, msg := range []string{"first", "second"} {
+		logger := zap.New(zapcore.NewCore(pattern.NewJSONEncoder(cfg), pattern.WrapWriter(zapcore.AddSync(&out)), zapcore.DebugLevel))
+		logger.Info(msg, zap.Int("n", 1))
+		logger.Info(msg + " stack\n\tframe")
+	}
+
+	got := regexp.MustCompile(`"time":"[^"]*"|[0-9a-f]{32}`).ReplaceAllString(out.String(), "X")
+	want := `{"chain_event":"start","seq":0,X,"chain":"X"}` + "\n" +
+		`{"msg":"first","n":1,"chain":"X"}` + "\n" +
+		`{"msg":"first stack\n\tframe","chain":"X"}` + "\n"
+	if !strings.HasPrefix(got, want) {
+		t.Errorf("got\n%s\nwant prefix\n%s", got, want)
+	}
+	v, err := verifyForTest(key, out.String())
+	if err != nil || v.Entries != 4 || v.Checkpoints != 2 {
+		t.Errorf("Verify = %v, %+v", err, v)
+	}
+
+	for _, format := range []string{`%chain`, `%chain{checkpoint=10}`, `%chain{keyfile=/nonexistent}`, `%chain{keyfile=` + key_file + `,checkpoint=x}`, `%chain{keyfile=` + key_file + `,salt=1}`} {
+		if _, err := Compile(format); err == nil {
+			t.Errorf("%s: expected an error", format)
+		}
+	}
+
+	// 没有 ChainWriter 的 encoder 不能使用 %chain
+	log_format := `%message %chain{keyfile=` + key_file + `}`
+	if _, err := BuildZaplogbackEncoder(cfg, log_format); !errors.Is(err, errChainWriter) {
+		t.Errorf("NewZaplogbackEncoder: %v", err)
+	}
+	if _, err := NewJSONLayoutEncoder(cfg, log_format); !errors.Is(err, errChainWriter) {
+		t.Errorf("NewJSONLayoutEncoder: %v", err)
+	}
+	if _, err := NewAtomicPattern(log_format); !errors.Is(err, errChainWriter) {
+		t.Errorf("NewAtomicPattern: %v", err)
+	}
+	if err := RegisterLogbackEncoder("chain-test", log_format); !errors.Is(err, errChainWriter) {
+		t.Errorf("RegisterLogbackEncoder: %v", err)
+	}
+}
+
+func TestChainMultiline(t *testing.T) {
+	_, key := chainKeyForTest(t)
+	var out bytes.Buffer
+	w := NewChainWriter(zapcore.AddSync(&out), key, 0)
+	w.Write([]byte("error boom\ngoroutine 1 [running]:\n\tmain.go:3\n"))
+	w.Write([]byte("info ok\n"))
+	if v, err := verifyForTest(key, out.String()); err != nil || v.Entries != 2 {
+		t.Errorf("Verify = %v, %+v", err, v)
+	}
+	edited := strings.Replace(out.String(), "main.go:3", "main.go:4", 1)
+	if _, err := verifyForTest(key, edited); breakLine(err) != 2 {
+		t.Errorf("edited stack: got %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/SheldonXLD/zaplogback"
+)
+
+func runDecrypt(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("decrypt", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, "usage: zaplogback decrypt -k <key file> [file ...]\n\n"+
+			"Replaces the encrypted field values (enc:v1:...) with their plaintext.\n"+
+			"The values are escaped like JSON strings, so JSON lines stay valid.\n\n")
+		flags.PrintDefaults()
+	}
+	key_file := flags.String("k", "", "key `file`, one `<key id> <base64 key>` per line")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if *key_file == "" {
+		flags.Usage()
+		return 2
+	}
+	keyring, err := zaplogback.LoadKeyring(*key_file)
+	if err != nil {
+		fmt.Fprintf(stderr, "zaplogback decrypt: %v\n", err)
+		return 1
+	}
+
+	w := bufio.NewWriter(stdout)
+	defer w.Flush()
+	failed := false
+	err = forEachInput(flags.Args(), stdin, func(r io.Reader) error {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+		for line_number := 1; scanner.Scan(); line_number++ {
+			line, err := keyring.DecryptText(scanner.Text(), escapeJSONString)
+			if err != nil {
+				// 解不开的值原样保留, 继续处理其余的行
+				fmt.Fprintf(stderr, "zaplogback decrypt: line %d: %v\n", line_number, err)
+				failed = true
+			}
+			w.WriteString(line)
+			w.WriteByte('\n')
+		}
+		return scanner.Err()
+	})
+	if err != nil {
+		fmt.Fprintf(stderr, "zaplogback decrypt: %v\n", err)
+		return 1
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// escapeJSONString escapes s as the content of a JSON string.
+func escapeJSONString(s string) string {
+	quoted, _ := json.Marshal(s)
+	return string(quoted[1 : len(quoted)-1])
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SheldonXLD/zaplogback"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// jsonKeys are the keys of the entry in zap JSON lines. The defaults are
+// those of zap.NewProductionEncoderConfig.
+type jsonKeys struct {
+	time, level, name, caller, function, message, stacktrace string
+}
+
+func (keys *jsonKeys) register(flags *flag.FlagSet) {
+	flags.StringVar(&keys.time, "time-key", "ts", "`key` of the time")
+	flags.StringVar(&keys.level, "level-key", "level", "`key` of the level")
+	flags.StringVar(&keys.name, "name-key", "logger", "`key` of the logger name")
+	flags.StringVar(&keys.caller, "caller-key", "caller", "`key` of the caller")
+	flags.StringVar(&keys.function, "function-key", "", "`key` of the function, none by default")
+	flags.StringVar(&keys.message, "message-key", "msg", "`key` of the message")
+	flags.StringVar(&keys.stacktrace, "stacktrace-key", "stacktrace", "`key` of the stack trace")
+}
+
+func runFmt(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, "usage: zaplogback fmt [flags] [file ...]\n\n"+
+			"Reads zap JSON lines from the files or stdin and writes them with a pattern.\n"+
+			"Lines that are not JSON objects are copied as they are.\n\n")
+		flags.PrintDefaults()
+	}
+	log_format := flags.String("p", _default_pattern, "`pattern` of the output")
+	color := flags.String("color", "auto", _color_usage)
+	var keys jsonKeys
+	keys.register(flags)
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	colored, err := colorOf(*color, stdout)
+	if err != nil {
+		fmt.Fprintf(stderr, "zaplogback fmt: %v\n", err)
+		return 2
+	}
+
+	encoder, err := newEncoder(*log_format, colored)
+	if err != nil {
+		fmt.Fprintf(stderr, "zaplogback fmt: %v\n", err)
+		return 1
+	}
+
+	w := bufio.NewWriter(stdout)
+	defer w.Flush()
+	err = forEachInput(flags.Args(), stdin, func(r io.Reader) error {
+		return formatLines(r, w, encoder, keys)
+	})
+	if err != nil {
+		fmt.Fprintf(stderr, "zaplogback fmt: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// newEncoder builds the encoder of fmt and render. Entries decoded from JSON
+// have an absolute caller path, which the short caller encoder trims.
+func newEncoder(log_format string, colored bool) (zapcore.Encoder, error) {
+	cfg := zap.NewDevelopmentEncoderConfig()
+	cfg.EncodeTime = zapcore.ISO8601TimeEncoder
+	if colored {
+		cfg.EncodeLevel = zapcore.CapitalColorLevelEncoder
+		pattern, err := zaplogback.Compile(log_format)
+		if err != nil {
+			return nil, err
+		}
+		log_format = colorizeLevels(pattern)
+	}
+	return zaplogback.BuildZaplogbackEncoder(cfg, log_format)
+}
+
+// colorizeLevels returns the pattern with the plain %level configs replaced
+// by their colored version.
+func colorizeLevels(pattern *zaplogback.Pattern) string {
+	actions := pattern.Actions()
+	for i, action := range actions {
+		if action.Name != "level" {
+			continue
+		}
+		switch action.Config {
+		case "upper", "capital":
+			actions[i].Config = "capitalcolor"
+		case "lower":
+			actions[i].Config = "color"
+		}
+	}
+	return zaplogback.FormatActions(actions)
+}
+
+// forEachInput calls fn with each file, or with stdin when there is none or
+// the file is "-".
+func forEachInput(paths []string, stdin io.Reader, fn func(io.Reader) error) error {
+	if len(paths) == 0 {
+		return fn(stdin)
+	}
+	for _, path := range paths {
+		if path == "-" {
+			if err := fn(stdin); err != nil {
+				return err
+			}
+			continue
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		err = fn(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func formatLines(r io.Reader, w io.Writer, encoder zapcore.Encoder, keys jsonKeys) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		ent, fields, err := keys.decode(line)
+		if err != nil {
+			// 不是 JSON 的行原样输出
+			w.Write(line)
+			io.WriteString(w, "\n")
+			continue
+		}
+		buf, err := encoder.EncodeEntry(ent, fields)
+		if err != nil {
+			return err
+		}
+		w.Write(buf.Bytes())
+		buf.Free()
+	}
+	return scanner.Err()
+}
+
+// jsonValue is a member of a JSON object, kept in the order of the line.
+type jsonValue struct {
+	key   string
+	value json.RawMessage
+}
+
+// decodeObject decodes a JSON object, keeping the order of its members.
+func decodeObject(line []byte) ([]jsonValue, error) {
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.UseNumber()
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return nil, errors.New("not a JSON object")
+	}
+	var members []jsonValue
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+		members = append(members, jsonValue{token.(string), value})
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, errors.New("trailing data after the JSON object")
+	}
+	return members, nil
+}
+
+// decode maps a zap JSON line back to the entry and the fields it was
+// written from. Members that are not entry keys become fields.
+func (keys jsonKeys) decode(line []byte) (zapcore.Entry, []zapcore.Field, error) {
+	var ent zapcore.Entry
+	members, err := decodeObject(line)
+	if err != nil {
+		return ent, nil, err
+	}
+
+	fields := make([]zapcore.Field, 0, len(members))
+	for _, member := range members {
+		var text string
+		is_string := json.Unmarshal(member.value, &text) == nil
+		switch {
+		case member.key == "":
+		case member.key == keys.time:
+			if t, ok := parseTime(member.value); ok {
+				ent.Time = t
+				continue
+			}
+		case !is_string:
+		case member.key == keys.level:
+			if level, err := zapcore.ParseLevel(strings.ToLower(text)); err == nil {
+				ent.Level = level
+				continue
+			}
+		case member.key == keys.name:
+			ent.LoggerName = text
+			continue
+		case member.key == keys.caller:
+			ent.Caller = parseCaller(text)
+			continue
+		case member.key == keys.function:
+			ent.Caller.Function = text
+			continue
+		case member.key == keys.message:
+			ent.Message = text
+			continue
+		case member.key == keys.stacktrace:
+			ent.Stack = text
+			continue
+		}
+		fields = append(fields, fieldOf(member.key, member.value))
+	}
+	return ent, fields, nil
+}
+
+// parseTime reads the times of the zap time encoders: epoch seconds, millis
+// micros or nanos, and RFC3339 or ISO8601 strings.
+func parseTime(value json.RawMessage) (time.Time, bool) {
+	var text string
+	if json.Unmarshal(value, &text) == nil {
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.000Z0700", "2006-01-02 15:04:05.000"} {
+			if t, err := time.Parse(layout, text); err == nil {
+				return t, true
+			}
+		}
+		return time.Time{}, false
+	}
+
+	// 按十进制文本解析, 避免浮点误差丢失毫秒
+	number := string(value)
+	if strings.ContainsAny(number, "eE") {
+		return time.Time{}, false
+	}
+	whole, frac, _ := strings.Cut(number, ".")
+	epoch, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	switch abs := max(epoch, -epoch); {
+	case abs >= 1e17:
+		return time.Unix(0, epoch), true
+	case abs >= 1e14:
+		return time.UnixMicro(epoch), true
+	case abs >= 1e11:
+		return time.UnixMilli(epoch), true
+	}
+	nsec := int64(0)
+	if frac != "" {
+		frac = (frac + "000000000")[:9]
+		if nsec, err = strconv.ParseInt(frac, 10, 64); err != nil {
+			return time.Time{}, false
+		}
+		if strings.HasPrefix(whole, "-") {
+			nsec = -nsec
+		}
+	}
+	return time.Unix(epoch, nsec), true
+}
+
+// parseCaller reads "path/file.go:line".
+func parseCaller(text string) zapcore.EntryCaller {
+	caller := zapcore.EntryCaller{Defined: text != "", File: text}
+	if i := strings.LastIndexByte(text, ':'); i >= 0 {
+		if line, err := strconv.Atoi(text[i+1:]); err == nil {
+			caller.File, caller.Line = text[:i], line
+		}
+	}
+	return caller
+}
+
+// fieldOf converts a JSON value to the field zap would have written it from.
+// Objects and arrays are kept as raw JSON.
+func fieldOf(key string, value json.RawMessage) zapcore.Field {
+	switch value[0] {
+	case '"':
+		var text string
+		json.Unmarshal(value, &text)
+		return zap.String(key, text)
+	case 't', 'f':
+		return zap.Bool(key, value[0] == 't')
+	case 'n':
+		return zap.Reflect(key, nil)
+	case '{', '[':
+		return zap.Reflect(key, value)
+	}
+	if i, err := strconv.ParseInt(string(value), 10, 64); err == nil {
+		return zap.Int64(key, i)
+	}
+	if f, err := strconv.ParseFloat(string(value), 64); err == nil {
+		return zap.Float64(key, f)
+	}
+	return zap.Reflect(key, value)
+}
@@ -0,0 +1,486 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SheldonXLD/zaplogback"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// _follow_interval is how often -f polls the files. A record is written
+// once the next one starts, or after the file has been idle for two polls.
+const _follow_interval = 250 * time.Millisecond
+
+func runGrep(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("grep", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, "usage: zaplogback grep [flags] [file ...]\n\n"+
+			"Prints the records of pattern-formatted log files that match all the filters.\n"+
+			"Stack traces and other continuation lines belong to the record above them.\n\n")
+		flags.PrintDefaults()
+	}
+	log_format := flags.String("p", _default_pattern, "`pattern` the files were written with")
+	level := flags.String("level", "", "level filter, e.g. `>=warn`, error or !=debug")
+	since := flags.String("since", "", "records at or after `time`, a duration like 10m or a time like 2006-01-02 15:04:05")
+	until := flags.String("until", "", "records before `time`, like -since")
+	message := flags.String("e", "", "`regexp` the message must match")
+	var field_filters fieldFilters
+	flags.Var(&field_filters, "field", "field filter `key=value` or key~regexp, repeatable")
+	follow := flags.Bool("f", false, "follow the files, also across rotation and truncation")
+	as_json := flags.Bool("json", false, "write the records as zap JSON lines")
+	key_file := flags.String("k", "", "key `file` to decrypt the encrypted field values with, see zaplogback decrypt")
+	var keys jsonKeys
+	keys.register(flags)
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	filter := recordFilter{fields: field_filters}
+	var err error
+	now := time.Now()
+	if filter.level, err = parseLevelFilter(*level); err == nil {
+		if filter.since, err = parseTimeFlag(*since, now); err == nil {
+			filter.until, err = parseTimeFlag(*until, now)
+		}
+	}
+	if err == nil && *message != "" {
+		filter.message, err = regexp.Compile(*message)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "zaplogback grep: %v\n", err)
+		return 2
+	}
+
+	parser, err := zaplogback.NewParser(*log_format, zap.NewProductionEncoderConfig())
+	if err != nil {
+		fmt.Fprintf(stderr, "zaplogback grep: %v\n", err)
+		return 1
+	}
+	var keyring *zaplogback.Keyring
+	if *key_file != "" {
+		if keyring, err = zaplogback.LoadKeyring(*key_file); err != nil {
+			fmt.Fprintf(stderr, "zaplogback grep: %v\n", err)
+			return 1
+		}
+	}
+
+	w := bufio.NewWriter(stdout)
+	defer w.Flush()
+	emit := func(records []zaplogback.Record) {
+		for i := range records {
+			if keyring != nil {
+				// 先解密, -field 按明文过滤; 解不开的值保持原样
+				records[i].Decrypt(keyring)
+				records[i].Raw, _ = keyring.DecryptText(records[i].Raw, escapeJSONString)
+			}
+			if !filter.match(&records[i]) {
+				continue
+			}
+			if *as_json {
+				w.Write(keys.encode(&records[i]))
+			} else {
+				w.WriteString(records[i].Raw)
+			}
+			w.WriteByte('\n')
+		}
+	}
+
+	if *follow {
+		if flags.NArg() == 0 {
+			fmt.Fprintln(stderr, "zaplogback grep: -f needs files")
+			return 2
+		}
+		// 没有 -since 时同 tail -f 一样从文件末尾开始
+		err = followFiles(flags.Args(), parser, filter.since.IsZero(), func(records []zaplogback.Record) {
+			emit(records)
+			w.Flush()
+		})
+	} else {
+		err = forEachInput(flags.Args(), stdin, func(r io.Reader) error {
+			reader := newRecordReader(parser)
+			lines := bufio.NewReader(r)
+			for {
+				line, err := lines.ReadString('\n')
+				if line != "" {
+					emit(reader.push(line))
+				}
+				if errors.Is(err, io.EOF) {
+					emit(reader.flush())
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+			}
+		})
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "zaplogback grep: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// levelFilter compares the level of a record with a level.
+type levelFilter struct {
+	op    string
+	level zapcore.Level
+}
+
+var _level_filter_regex_pattern = regexp.MustCompile(`^\s*(>=|<=|!=|==|>|<|=)?\s*(\w+)\s*$`)
+
+func parseLevelFilter(text string) (*levelFilter, error) {
+	if text == "" {
+		return nil, nil
+	}
+	m := _level_filter_regex_pattern.FindStringSubmatch(text)
+	if m == nil {
+		return nil, fmt.Errorf("invalid -level %q", text)
+	}
+	level, err := zapcore.ParseLevel(strings.ToLower(m[2]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid -level %q: %w", text, err)
+	}
+	op := m[1]
+	if op == "" || op == "==" {
+		op = "="
+	}
+	return &levelFilter{op, level}, nil
+}
+
+func (f *levelFilter) match(level zapcore.Level) bool {
+	if level == zapcore.InvalidLevel {
+		return false
+	}
+	switch f.op {
+	case ">=":
+		return level >= f.level
+	case "<=":
+		return level <= f.level
+	case ">":
+		return level > f.level
+	case "<":
+		return level < f.level
+	case "!=":
+		return level != f.level
+	}
+	return level == f.level
+}
+
+// parseTimeFlag reads a duration before now or a time in the local zone.
+func parseTimeFlag(text string, now time.Time) (time.Time, error) {
+	if text == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(text); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, text); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, text, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, want a duration like 10m or a time like 2006-01-02 15:04:05", text)
+}
+
+// fieldFilter is one -field flag.
+type fieldFilter struct {
+	key   string
+	value string
+	re    *regexp.Regexp
+}
+
+type fieldFilters []fieldFilter
+
+func (fs *fieldFilters) String() string {
+	return ""
+}
+
+func (fs *fieldFilters) Set(text string) error {
+	i := strings.IndexAny(text, "=~")
+	if i <= 0 {
+		return fmt.Errorf("want key=value or key~regexp, got %q", text)
+	}
+	f := fieldFilter{key: text[:i], value: text[i+1:]}
+	if text[i] == '~' {
+		re, err := regexp.Compile(f.value)
+		if err != nil {
+			return err
+		}
+		f.re = re
+	}
+	*fs = append(*fs, f)
+	return nil
+}
+
+func (f fieldFilter) match(r *zaplogback.Record) bool {
+	for _, field := range r.Fields {
+		if field.Key != f.key {
+			continue
+		}
+		// 带引号的值也按其内容比较
+		value := field.Value
+		if unquoted, err := strconv.Unquote(value); err == nil && strings.HasPrefix(value, `"`) {
+			value = unquoted
+		}
+		if f.re != nil && f.re.MatchString(value) || f.re == nil && value == f.value {
+			return true
+		}
+	}
+	return false
+}
+
+type recordFilter struct {
+	level        *levelFilter
+	since, until time.Time
+	message      *regexp.Regexp
+	fields       fieldFilters
+}
+
+func (f *recordFilter) match(r *zaplogback.Record) bool {
+	if f.level != nil && !f.level.match(r.Level) {
+		return false
+	}
+	if !f.since.IsZero() && (r.Time.IsZero() || r.Time.Before(f.since)) {
+		return false
+	}
+	if !f.until.IsZero() && (r.Time.IsZero() || !r.Time.Before(f.until)) {
+		return false
+	}
+	if f.message != nil && !f.message.MatchString(r.Message) {
+		return false
+	}
+	for _, field := range f.fields {
+		if !field.match(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// encode writes a record as a zap JSON line with the keys. Field values that
+// are valid JSON, like numbers and objects, are kept as they are.
+func (keys jsonKeys) encode(r *zaplogback.Record) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	add := func(key string, value json.RawMessage) {
+		if key == "" {
+			return
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(key)
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	addString := func(key, value string) {
+		if value != "" {
+			quoted, _ := json.Marshal(value)
+			add(key, quoted)
+		}
+	}
+
+	if !r.Time.IsZero() {
+		addString(keys.time, r.Time.Format(time.RFC3339Nano))
+	}
+	if r.Level != zapcore.InvalidLevel {
+		addString(keys.level, r.Level.String())
+	}
+	addString(keys.name, r.LoggerName)
+	caller := r.Caller
+	if caller == "" && r.File != "" {
+		caller = fmt.Sprintf("%s:%d", r.File, r.Line)
+	}
+	addString(keys.caller, caller)
+	addString(keys.function, r.Function)
+	addString(keys.message, r.Message)
+	for _, field := range r.Fields {
+		if json.Valid([]byte(field.Value)) {
+			add(field.Key, json.RawMessage(field.Value))
+		} else {
+			quoted, _ := json.Marshal(field.Value)
+			add(field.Key, quoted)
+		}
+	}
+	addString(keys.stacktrace, r.Stack)
+	buf.WriteByte('}')
+	return buf.Bytes()
+}
+
+// recordReader groups lines into records like zaplogback.RecordScanner, but
+// is fed line by line so that -f can write the last record of an idle file.
+type recordReader struct {
+	parser  *zaplogback.Parser
+	lines   []string
+	current *zaplogback.Record
+}
+
+func newRecordReader(parser *zaplogback.Parser) *recordReader {
+	return &recordReader{parser: parser}
+}
+
+// push adds a line and returns the records it completed.
+func (r *recordReader) push(line string) []zaplogback.Record {
+	line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+	r.lines = append(r.lines, line)
+	var records []zaplogback.Record
+	for len(r.lines) >= r.parser.HeaderLines() {
+		records = append(records, r.consume(r.parser.HeaderLines())...)
+	}
+	return records
+}
+
+// flush returns the pending record, the input has ended or is idle.
+func (r *recordReader) flush() []zaplogback.Record {
+	var records []zaplogback.Record
+	for len(r.lines) > 0 {
+		records = append(records, r.consume(len(r.lines))...)
+	}
+	if r.current != nil {
+		records = append(records, *r.current)
+		r.current = nil
+	}
+	return records
+}
+
+// consume reads a record header of n lines, or the first line as a
+// continuation of the current record.
+func (r *recordReader) consume(n int) []zaplogback.Record {
+	var records []zaplogback.Record
+	if record, ok := r.parser.ParseRecord(strings.Join(r.lines[:n], "\n")); ok {
+		if r.current != nil {
+			records = append(records, *r.current)
+		}
+		r.current = &record
+		r.lines = r.lines[n:]
+		return records
+	}
+
+	line := r.lines[0]
+	r.lines = r.lines[1:]
+	if r.current != nil && zaplogback.IsContinuationLine(line) {
+		if r.current.Stack != "" {
+			r.current.Stack += "\n"
+		}
+		r.current.Stack += line
+		r.current.Raw += "\n" + line
+	}
+	return records
+}
+
+// follower reads the lines appended to a file. When the file is replaced,
+// e.g. rotated, the rest of the old file is read and the new one is opened;
+// when it is truncated, it is read again from the start.
+type follower struct {
+	path    string
+	f       *os.File
+	offset  int64
+	partial string
+	reader  *recordReader
+	idle    int
+}
+
+func (fl *follower) open(at_end bool) error {
+	f, err := os.Open(fl.path)
+	if err != nil {
+		return err
+	}
+	fl.f, fl.offset = f, 0
+	if at_end {
+		if fl.offset, err = f.Seek(0, io.SeekEnd); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return nil
+}
+
+// poll returns the complete lines appended since the last poll.
+func (fl *follower) poll() ([]string, error) {
+	if fl.f == nil {
+		// 文件轮转后尚未重新创建
+		if err := fl.open(false); err != nil {
+			return nil, nil
+		}
+	}
+
+	data, err := io.ReadAll(fl.f)
+	if err != nil {
+		return nil, err
+	}
+	fl.offset += int64(len(data))
+
+	if info, err := os.Stat(fl.path); err != nil {
+		fl.f.Close()
+		fl.f = nil
+	} else if current, err := fl.f.Stat(); err == nil && !os.SameFile(info, current) {
+		fl.f.Close()
+		fl.f = nil
+		fl.open(false)
+	} else if info.Size() < fl.offset {
+		fl.offset, _ = fl.f.Seek(0, io.SeekStart)
+		fl.partial = ""
+	}
+
+	text := fl.partial + string(data)
+	end := strings.LastIndexByte(text, '\n') + 1
+	fl.partial = text[end:]
+	if end == 0 {
+		return nil, nil
+	}
+	lines := strings.SplitAfter(text[:end], "\n")
+	return lines[:len(lines)-1], nil
+}
+
+// followFiles polls the files until the process is stopped.
+func followFiles(paths []string, parser *zaplogback.Parser, at_end bool, emit func([]zaplogback.Record)) error {
+	followers := make([]*follower, len(paths))
+	for i, path := range paths {
+		followers[i] = &follower{path: path, reader: newRecordReader(parser)}
+		if err := followers[i].open(at_end); err != nil {
+			return err
+		}
+	}
+
+	for {
+		for _, fl := range followers {
+			lines, err := fl.poll()
+			if err != nil {
+				return err
+			}
+			if len(lines) == 0 {
+				fl.idle++
+				if fl.idle == 2 {
+					emit(fl.reader.flush())
+				}
+				continue
+			}
+			fl.idle = 0
+			for _, line := range lines {
+				emit(fl.reader.push(line))
+			}
+		}
+		time.Sleep(_follow_interval)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/SheldonXLD/zaplogback"
+)
+
+func runLint(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("lint", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, "usage: zaplogback lint '<pattern>'\n\n"+
+			"Shows the actions of a pattern and reports its mistakes.\n"+
+			"The exit code is 1 when the pattern does not compile.\n")
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	log_format := flags.Arg(0)
+
+	pattern, err := zaplogback.Compile(log_format)
+	if err != nil {
+		d := zaplogback.Diagnostic{Offset: -1, Message: err.Error()}
+		var pattern_err *zaplogback.PatternError
+		if errors.As(err, &pattern_err) {
+			d.Offset = pattern_err.Offset
+		}
+		printDiagnostic(stdout, "error", log_format, d)
+		return 1
+	}
+
+	printActions(stdout, pattern)
+	for _, d := range lintPattern(pattern) {
+		printDiagnostic(stdout, "warning", log_format, d)
+	}
+	return 0
+}
+
+// printActions writes the elements of the pattern as a tree, with what each
+// action will write.
+func printActions(w io.Writer, pattern *zaplogback.Pattern) {
+	fmt.Fprintf(w, "pattern %q\n", pattern.Source())
+	elements := pattern.Actions()
+	for i, element := range elements {
+		branch := "├─"
+		if i == len(elements)-1 {
+			branch = "└─"
+		}
+		if element.IsLiteral() {
+			fmt.Fprintf(w, "%s %-4d literal %q\n", branch, element.Offset, element.Literal)
+			continue
+		}
+		text := "%" + element.Name
+		if element.Config != "" {
+			text += "{" + element.Config + "}"
+		}
+		fmt.Fprintf(w, "%s %-4d %s\n", branch, element.Offset, text)
+
+		indent := "│"
+		if i == len(elements)-1 {
+			indent = " "
+		}
+		for _, detail := range actionDetails(element) {
+			fmt.Fprintf(w, "%s         %s\n", indent, detail)
+		}
+	}
+	if fields := pattern.UsedFields(); len(fields) > 0 {
+		fmt.Fprintf(w, "fields left out of %%fields: %s\n", strings.Join(fields, ", "))
+	}
+}
+
+func actionDetails(element zaplogback.PatternAction) []string {
+	switch element.Name {
+	case "date":
+		if element.Config == "" {
+			return []string{"time: EncoderConfig.EncodeTime"}
+		}
+		layout, err := zaplogback.StrftimeLayoutOf(element.Config)
+		if err != nil {
+			return []string{"date: " + err.Error()}
+		}
+		return []string{
+			"strftime: " + strconv.Quote(layout),
+			"go layout: " + strconv.Quote(zaplogback.StrftimeFormatLayout(layout)),
+		}
+	case "level":
+		if element.Config == "" {
+			return []string{"level: EncoderConfig.EncodeLevel"}
+		}
+	case "caller":
+		if element.Config == "" {
+			return []string{"caller: EncoderConfig.EncodeCaller"}
+		}
+	case "x":
+		field, prefix, suffix, _ := splitXConfig(element.Config)
+		details := []string{"field: " + strconv.Quote(field)}
+		if prefix != "" || suffix != "" {
+			details = append(details, fmt.Sprintf("prefix: %q, suffix: %q", prefix, suffix))
+		}
+		return details
+	case "fields":
+		return []string{"fields: all but the ones of %x"}
+	}
+	return nil
+}
+
+var _field_name_regex_pattern = regexp.MustCompile(`^\w+`)
+
+// splitXConfig splits `field:prefix$0suffix` like %x does. ok is false when
+// text after the field name is ignored by %x.
+func splitXConfig(config string) (field, prefix, suffix string, ok bool) {
+	field = _field_name_regex_pattern.FindString(config)
+	rest := config[len(field):]
+	if rest == "" {
+		return field, "", "", true
+	}
+	i := strings.LastIndex(rest, "$0")
+	if rest[0] != ':' || i < 0 {
+		return field, "", "", false
+	}
+	return field, rest[1:i], rest[i+2:], true
+}
+
+var _unknown_word_regex_pattern = regexp.MustCompile(`%\w+`)
+
+// lintPattern reports the mistakes of a pattern that compiles.
+func lintPattern(pattern *zaplogback.Pattern) []zaplogback.Diagnostic {
+	var diagnostics []zaplogback.Diagnostic
+	report := func(offset int, format string, args ...interface{}) {
+		diagnostics = append(diagnostics, zaplogback.Diagnostic{Offset: offset, Message: fmt.Sprintf(format, args...)})
+	}
+
+	source := pattern.Source()
+	has_message := false
+	x_fields := make(map[string]int)
+	for _, element := range pattern.Actions() {
+		// config 在 source 中的位置
+		config_offset := element.Offset
+		if i := strings.IndexByte(source[element.Offset:], '{'); i >= 0 {
+			config_offset += i + 1
+		}
+
+		switch element.Name {
+		case "":
+			for _, m := range _unknown_word_regex_pattern.FindAllStringIndex(element.Literal, -1) {
+				report(element.Offset+m[0], "unknown conversion word %s is written literally", element.Literal[m[0]:m[1]])
+			}
+		case "message":
+			has_message = true
+		case "x":
+			field, _, _, ok := splitXConfig(element.Config)
+			if !ok {
+				report(config_offset+len(field), "%%x{%s}: the text after the field name is ignored, write %%x{%s:prefix$0suffix}", element.Config, field)
+			}
+			if first, dup := x_fields[field]; dup {
+				report(element.Offset, "field %q is already written by the %%x at offset %d", field, first)
+			} else {
+				x_fields[field] = element.Offset
+			}
+		case "date":
+			if !strings.Contains(element.Config, "%") {
+				continue
+			}
+			for _, d := range zaplogback.CheckStrftime(element.Config) {
+				report(config_offset+d.Offset, "%s, it is written literally", d.Message)
+			}
+		}
+	}
+	if !has_message {
+		report(-1, "there is no %%message, the messages of the entries are not written")
+	}
+	return diagnostics
+}
+
+// printDiagnostic writes a diagnostic, and the line of the pattern with a
+// caret under the offset.
+func printDiagnostic(w io.Writer, kind, source string, d zaplogback.Diagnostic) {
+	fmt.Fprintf(w, "%s: %s\n", kind, d.Message)
+	if d.Offset < 0 || d.Offset > len(source) {
+		return
+	}
+	start := strings.LastIndexByte(source[:d.Offset], '\n') + 1
+	end := strings.IndexByte(source[d.Offset:], '\n')
+	if end < 0 {
+		end = len(source)
+	} else {
+		end += d.Offset
+	}
+	fmt.Fprintf(w, "    %s\n    %s^\n", source[start:end], caretPadding(source[start:d.Offset]))
+}
+
+// caretPadding keeps the tabs of the line, so the caret lines up.
+func caretPadding(before string) string {
+	var sb strings.Builder
+	for _, r := range before {
+		if r == '\t' {
+			sb.WriteRune('\t')
+		} else {
+			sb.WriteByte(' ')
+		}
+	}
+	return sb.String()
+}
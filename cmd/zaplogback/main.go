@@ -0,0 +1,84 @@
+// Command zaplogback works with logs written by zap and zaplogback.
+//
+//	zaplogback fmt -p '%date %level %message %fields' < app.json
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+const usage = `usage: zaplogback <command> [flags]
+
+commands:
+  decrypt decrypt the encrypted field values with a key file
+  fmt     re-render zap JSON logs through a pattern
+  grep    filter pattern-formatted log files, or follow them
+  lint    check a pattern and show its actions
+  render  print sample entries with a pattern
+  verify  check the hash chain of chained logs and report the first break
+
+Run "zaplogback <command> -h" for the flags of a command.
+`
+
+// _default_pattern is used when -p is not given.
+const _default_pattern = `%date{%Y-%m-%d %H:%M:%S.%3f} %level %caller %message %fields`
+
+type command func(args []string, stdin io.Reader, stdout, stderr io.Writer) int
+
+var commands = map[string]command{
+	"decrypt": runDecrypt,
+	"fmt":     runFmt,
+	"grep":    runGrep,
+	"lint":    runLint,
+	"render":  runRender,
+	"verify":  runVerify,
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		if args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+			fmt.Fprint(stdout, usage)
+			return 0
+		}
+		fmt.Fprintf(stderr, "zaplogback: unknown command %q\n\n%s", args[0], usage)
+		return 2
+	}
+	return cmd(args[1:], stdin, stdout, stderr)
+}
+
+const _color_usage = "colored levels: auto, always or never"
+
+// colorOf reads the -color flag. auto colors the levels when stdout is a
+// terminal.
+func colorOf(color string, stdout io.Writer) (bool, error) {
+	switch color {
+	case "auto":
+		return isTerminal(stdout), nil
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	}
+	return false, fmt.Errorf("unknown -color %q", color)
+}
+
+// isTerminal reports whether w is a character device, like a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
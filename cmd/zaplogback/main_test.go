@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SheldonXLD/zaplogback"
+	"go.uber.org/zap/zapcore"
+)
+
+func runForTest(t *testing.T, stdin string, args ...string) string {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	if code := run(args, strings.NewReader(stdin), &stdout, &stderr); code != 0 {
+		t.Fatalf("run %v: exit code %d, stderr %q", args, code, stderr.String())
+	}
+	return stdout.String()
+}
+
+func TestFmt(t *testing.T) {
+	input := `{"level":"warn","ts":1700000000.123,"logger":"app.db","caller":"db/conn.go:42","msg":"slow query","tid":"abc","rows":3,"args":[1,2]}
+not json
+{"severity":"ERROR","time":"2023-11-14T22:13:20.5Z","message":"boom","trace":"main.main\n\tmain.go:3"}
+`
+	got := runForTest(t, input, "fmt", "-p", "%level{upper} [%logger] %caller %message %x{tid} %fields")
+	want := "WARN [app.db] db/conn.go:42 slow query abc {\"rows\":3,\"args\":[1,2]}\n" +
+		"not json\n"
+	if !strings.HasPrefix(got, want) {
+		t.Errorf("got %q, want prefix %q", got, want)
+	}
+
+	got = runForTest(t, input[strings.Index(input, "not json"):], "fmt",
+		"-level-key", "severity", "-time-key", "time", "-message-key", "message", "-stacktrace-key", "trace",
+		"-p", "%date{%H:%M:%S.%3f} %level %message%n%stacktrace")
+	want = "not json\n22:13:20.500 ERROR boom\nmain.main\n\tmain.go:3\n"
+	got = strings.Replace(got, time.Date(2023, 11, 14, 22, 13, 20, 5e8, time.UTC).Local().Format("15:04:05.000"), "22:13:20.500", 1)
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFmtColor(t *testing.T) {
+	got := runForTest(t, `{"level":"error","msg":"x"}`+"\n", "fmt", "-color", "always", "-p", "%level{lower} %message")
+	if want := "\x1b[31merror\x1b[0m x\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	got = runForTest(t, `{"level":"error","msg":"x"}`+"\n", "fmt", "-p", "%level{lower} %message")
+	if want := "error x\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	pattern, err := zaplogback.Compile(`%level{upper}x %message{}x %x{tid,as=trace}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := colorizeLevels(pattern), `%level{capitalcolor}x %message{}x %x{tid,as=trace}`; got != want {
+		t.Errorf("colorizeLevels = %q, want %q", got, want)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	want := time.Date(2023, 11, 14, 22, 13, 20, 123e6, time.UTC)
+	for _, value := range []string{`1700000000.123`, `1700000000123`, `1700000000123000`, `1700000000123000000`, `"2023-11-14T22:13:20.123Z"`} {
+		got, ok := parseTime([]byte(value))
+		if !ok || !got.Equal(want) {
+			t.Errorf("parseTime(%s) = %v, %v, want %v", value, got, ok, want)
+		}
+	}
+	if _, ok := parseTime([]byte(`"yesterday"`)); ok {
+		t.Error("parseTime accepted an invalid time")
+	}
+}
+
+func TestParseCaller(t *testing.T) {
+	caller := parseCaller("/src/app/main.go:12")
+	if !caller.Defined || caller.File != "/src/app/main.go" || caller.Line != 12 {
+		t.Errorf("got %+v", caller)
+	}
+	if caller := parseCaller(""); caller != (zapcore.EntryCaller{}) {
+		t.Errorf("got %+v for an empty caller", caller)
+	}
+}
+
+func TestLint(t *testing.T) {
+	got := runForTest(t, "", "lint", `%date{%Y-%m-%d %Q} %x{tid} %x{tid} %x{uid:x} %level`)
+	for _, want := range []string{
+		"├─ 0    %date{%Y-%m-%d %Q}\n│         strftime: \"%Y-%m-%d %Q\"\n",
+		"warning: strftime code %Q is not supported, it is written literally\n" +
+			"    %date{%Y-%m-%d %Q} %x{tid} %x{tid} %x{uid:x} %level\n" +
+			"                   ^\n",
+		"warning: field \"tid\" is already written by the %x at offset 19\n",
+		"warning: %x{uid:x}: the text after the field name is ignored",
+		"warning: there is no %message",
+		"fields left out of %fields: tid, uid\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("lint output %q does not contain %q", got, want)
+		}
+	}
+
+	got = runForTest(t, "", "lint", `%d{ISO8601} %message`)
+	if want := "strftime: \"%Y-%m-%d %H:%M:%S,%3f\"\n│         go layout: \"2006-01-02 15:04:05,000\"\n"; !strings.Contains(got, want) {
+		t.Errorf("lint output %q does not contain %q", got, want)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"lint", "%message %level{nope}"}, nil, &stdout, &stderr); code != 1 {
+		t.Errorf("exit code %d for an invalid pattern", code)
+	}
+	want := "error: %level: unknown level config \"nope\"\n" +
+		"    %message %level{nope}\n" +
+		"             ^\n"
+	if stdout.String() != want {
+		t.Errorf("got %q, want %q", stdout.String(), want)
+	}
+}
+
+func TestRender(t *testing.T) {
+	got := runForTest(t, "", "render", "-color", "never", "%level{upper} %caller %message %fields")
+	for _, want := range []string{
+		"# debug, plain\nDEBUG  order created {}\n",
+		"# fatal, caller\nFATAL order/handler.go:42 order created {}\n",
+		"# error, fields, caller and stack\nERROR order/handler.go:42 order created {\"tid\":7f3a9c2e,",
+		"\nshop/order.(*Handler).Create\n\t/src/shop/order/handler.go:42\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("render output does not contain %q", want)
+		}
+	}
+	if n := strings.Count(got, "\n# "); n != 7*len(_samples)-1 {
+		t.Errorf("got %d samples, want %d", n+1, 7*len(_samples))
+	}
+}
+
+const _grep_pattern = `%date{%Y-%m-%d %H:%M:%S.%3f} %level{upper} %caller %message %fields`
+
+const _grep_input = `2024-05-01 10:00:00.000 INFO app/main.go:10 started {"tid":abc,"port":8080}
+2024-05-01 10:00:01.000 WARN app/db.go:20 slow query {"tid":abc,"ms":1500}
+2024-05-01 10:00:02.000 ERROR app/db.go:30 query failed {"tid":def,"err":timeout}
+main.query
+	/src/app/db.go:30
+2024-05-01 10:00:03.000 DEBUG app/db.go:40 retry {"tid":"abc"}
+`
+
+func TestGrep(t *testing.T) {
+	lines := strings.Split(_grep_input, "\n")
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-level", ">=warn"}, strings.Join(lines[1:5], "\n") + "\n"},
+		{[]string{"-level", "!=warn", "-field", "tid=abc"}, lines[0] + "\n" + lines[5] + "\n"},
+		{[]string{"-field", "tid~^d", "-e", "fail"}, strings.Join(lines[2:5], "\n") + "\n"},
+		{[]string{"-since", "2024-05-01 10:00:02", "-until", "2024-05-01 10:00:03"}, strings.Join(lines[2:5], "\n") + "\n"},
+		{[]string{"-level", "error", "-json"}, `{"ts":"` + time.Date(2024, 5, 1, 10, 0, 2, 0, time.Local).Format(time.RFC3339Nano) +
+			`","level":"error","caller":"app/db.go:30","msg":"query failed","tid":"def","err":"timeout","stacktrace":"main.query\n\t/src/app/db.go:30"}` + "\n"},
+	}
+	for _, tt := range tests {
+		args := append([]string{"grep", "-p", _grep_pattern}, tt.args...)
+		if got := runForTest(t, _grep_input, args...); got != tt.want {
+			t.Errorf("grep %v:\ngot  %q\nwant %q", tt.args, got, tt.want)
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"grep", "-level", ">=loud"}, nil, &stdout, &stderr); code != 2 {
+		t.Errorf("exit code %d for an invalid level", code)
+	}
+}
+
+func TestDecrypt(t *testing.T) {
+	key := make([]byte, 32)
+	keyring, err := zaplogback.NewKeyring("k1", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key_file := filepath.Join(t.TempDir(), "log.keys")
+	os.WriteFile(key_file, []byte("k1 "+base64.StdEncoding.EncodeToString(key)+"\n"), 0o600)
+	customer, _ := keyring.Encrypt(`C-"1"`)
+	card, _ := keyring.Encrypt("42")
+
+	input := `{"msg":"paid","customer_id":"` + customer + `"}` + "\n" +
+		"2024-05-01 10:00:00.000 INFO app/pay.go:9 paid {\"card_ref\":" + card + "}\n"
+	got := runForTest(t, input, "decrypt", "-k", key_file)
+	want := `{"msg":"paid","customer_id":"C-\"1\""}` + "\n" +
+		"2024-05-01 10:00:00.000 INFO app/pay.go:9 paid {\"card_ref\":42}\n"
+	if got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+
+	got = runForTest(t, input[strings.Index(input, "2024"):], "grep", "-k", key_file, "-p", _grep_pattern, "-field", "card_ref=42")
+	if want := input[strings.Index(input, "2024"):]; got != strings.Replace(want, card, "42", 1) {
+		t.Errorf("grep -k: got %q", got)
+	}
+
+	var stdout, stderr bytes.Buffer
+	other, _ := zaplogback.NewKeyring("k2", key)
+	unknown, _ := other.Encrypt("x")
+	if code := run([]string{"decrypt", "-k", key_file}, strings.NewReader(unknown+"\n"), &stdout, &stderr); code != 1 || stdout.String() != unknown+"\n" {
+		t.Errorf("unknown key: exit code %d, stdout %q", code, stdout.String())
+	}
+}
+
+func TestVerify(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	key_file := filepath.Join(t.TempDir(), "chain.key")
+	os.WriteFile(key_file, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0o600)
+	var log bytes.Buffer
+	w := zaplogback.NewChainWriter(zapcore.AddSync(&log), key, 0)
+	w.Write([]byte("info login\n"))
+	w.Write([]byte("info logout\n"))
+
+	if got, want := runForTest(t, log.String(), "verify", "-k", key_file), "ok: 2 entries verified, 1 start and checkpoint lines\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	var stdout, stderr bytes.Buffer
+	edited := strings.Replace(log.String(), "logout", "logoff", 1)
+	code := run([]string{"verify", "-k", key_file}, strings.NewReader(edited), &stdout, &stderr)
+	if want := "-:3: chain broken: MAC mismatch"; code != 1 || !strings.HasPrefix(stdout.String(), want) {
+		t.Errorf("exit code %d, stdout %q, want prefix %q", code, stdout.String(), want)
+	}
+
+	// 没有 start 行
+	partial := strings.SplitN(log.String(), "\n", 2)[1]
+	stdout.Reset()
+	code = run([]string{"verify", "-k", key_file}, strings.NewReader(partial), &stdout, &stderr)
+	if want := "-:1: chain broken: no start or checkpoint line"; code != 1 || !strings.HasPrefix(stdout.String(), want) {
+		t.Errorf("exit code %d, stdout %q, want prefix %q", code, stdout.String(), want)
+	}
+	if got, want := runForTest(t, partial, "verify", "-k", key_file, "-allow-partial"), "ok: 1 entries verified, 0 start and checkpoint lines, 1 entries before the first checkpoint not verified\n"; got != want {
+		t.Errorf("-allow-partial: got %q, want %q", got, want)
+	}
+}
+
+func TestFollowerRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	write := func(flag int, text string) {
+		f, err := os.OpenFile(path, flag|os.O_WRONLY|os.O_CREATE, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(text)
+		f.Close()
+	}
+	poll := func(fl *follower, want ...string) {
+		t.Helper()
+		lines, err := fl.poll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(lines, "") != strings.Join(want, "") {
+			t.Errorf("got %q, want %q", lines, want)
+		}
+	}
+
+	write(os.O_TRUNC, "old\n")
+	fl := &follower{path: path}
+	if err := fl.open(true); err != nil {
+		t.Fatal(err)
+	}
+	poll(fl)
+	write(os.O_APPEND, "one\ntw")
+	poll(fl, "one\n")
+	write(os.O_APPEND, "o\n")
+	poll(fl, "two\n")
+
+	// rotation: the rest of the old file, then the new file
+	write(os.O_APPEND, "three\n")
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	write(os.O_TRUNC, "four\n")
+	poll(fl, "three\n")
+	poll(fl, "four\n")
+
+	// truncation, noticed when the file is smaller than what was read
+	write(os.O_TRUNC, "5\n")
+	poll(fl)
+	poll(fl, "5\n")
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// _sample_caller and _sample_stack are those of a typical service.
+var (
+	_sample_caller = zapcore.EntryCaller{
+		Defined:  true,
+		File:     "/src/shop/order/handler.go",
+		Line:     42,
+		Function: "shop/order.(*Handler).Create",
+	}
+	_sample_stack = "shop/order.(*Handler).Create\n" +
+		"\t/src/shop/order/handler.go:42\n" +
+		"net/http.HandlerFunc.ServeHTTP\n" +
+		"\t/usr/local/go/src/net/http/server.go:2166"
+)
+
+func sampleFields() []zapcore.Field {
+	return []zapcore.Field{
+		zap.String("tid", "7f3a9c2e"),
+		zap.Int("user", 1024),
+		zap.Duration("elapsed", 1530*time.Millisecond),
+		zap.Bool("retry", false),
+		zap.Ints("items", []int{3, 5}),
+		zap.Error(errors.New("connection reset by peer")),
+	}
+}
+
+type sample struct {
+	name   string
+	fields bool
+	caller bool
+	stack  bool
+}
+
+var _samples = []sample{
+	{name: "plain"},
+	{name: "fields", fields: true},
+	{name: "caller", caller: true},
+	{name: "fields, caller and stack", fields: true, caller: true, stack: true},
+}
+
+func runRender(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("render", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, "usage: zaplogback render [flags] '<pattern>'\n\n"+
+			"Prints sample entries at every level, with and without fields, caller and stack.\n\n")
+		flags.PrintDefaults()
+	}
+	color := flags.String("color", "auto", _color_usage)
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	colored, err := colorOf(*color, stdout)
+	if err != nil {
+		fmt.Fprintf(stderr, "zaplogback render: %v\n", err)
+		return 2
+	}
+	encoder, err := newEncoder(flags.Arg(0), colored)
+	if err != nil {
+		fmt.Fprintf(stderr, "zaplogback render: %v\n", err)
+		return 1
+	}
+
+	now := time.Now()
+	for level := zapcore.DebugLevel; level <= zapcore.FatalLevel; level++ {
+		for _, s := range _samples {
+			fmt.Fprintf(stdout, "# %s, %s\n", level, s.name)
+			ent := zapcore.Entry{
+				Level:      level,
+				Time:       now,
+				LoggerName: "shop.order",
+				Message:    "order created",
+			}
+			var fields []zapcore.Field
+			if s.fields {
+				fields = sampleFields()
+			}
+			if s.caller {
+				ent.Caller = _sample_caller
+			}
+			if s.stack {
+				ent.Stack = _sample_stack
+			}
+			buf, err := encoder.EncodeEntry(ent, fields)
+			if err != nil {
+				fmt.Fprintf(stderr, "zaplogback render: %v\n", err)
+				return 1
+			}
+			stdout.Write(buf.Bytes())
+			buf.Free()
+		}
+	}
+	return 0
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/SheldonXLD/zaplogback"
+)
+
+func runVerify(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, "usage: zaplogback verify -k <key file> [-allow-partial] [file ...]\n\n"+
+			"Checks the hash chain of the lines written with the chain action and reports the first break.\n"+
+			"Give rotated files from the oldest, the chain continues across them.\n"+
+			"The first file must begin with a start or checkpoint line unless -allow-partial is given.\n"+
+			"The exit code is 1 when the chain is broken.\n\n")
+		flags.PrintDefaults()
+	}
+	key_file := flags.String("k", "", "`file` of the base64 HMAC key of the chain")
+	allow_partial := flags.Bool("allow-partial", false, "accept input that begins in the middle of a chain, e.g. without the older rotated files")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if *key_file == "" {
+		flags.Usage()
+		return 2
+	}
+	key, err := zaplogback.LoadChainKey(*key_file)
+	if err != nil {
+		fmt.Fprintf(stderr, "zaplogback verify: %v\n", err)
+		return 1
+	}
+
+	v := zaplogback.NewChainVerifier(key)
+	v.AllowPartial = *allow_partial
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+	for _, path := range paths {
+		err := verifyFile(v, path, stdin)
+		var chain_break *zaplogback.ChainBreak
+		if errors.As(err, &chain_break) {
+			fmt.Fprintf(stdout, "%s:%d: chain broken: %s\n", path, chain_break.Line, chain_break.Reason)
+			return 1
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "zaplogback verify: %v\n", err)
+			return 1
+		}
+	}
+	fmt.Fprintf(stdout, "ok: %d entries verified, %d start and checkpoint lines", v.Entries, v.Checkpoints)
+	if v.Unverified > 0 {
+		fmt.Fprintf(stdout, ", %d entries before the first checkpoint not verified", v.Unverified)
+	}
+	fmt.Fprintln(stdout)
+	return 0
+}
+
+func verifyFile(v *zaplogback.ChainVerifier, path string, stdin io.Reader) error {
+	if path == "-" {
+		return v.Verify(stdin)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return v.Verify(f)
+}
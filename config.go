@@ -0,0 +1,402 @@
+package zaplogback
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v3"
+)
+
+// PatternEnv overrides Config.Pattern when set.
+const PatternEnv = "ZAPLOGBACK_PATTERN"
+
+// Config extends zap.Config with patterns, so a logger can be described
+// entirely in a YAML or JSON file:
+//
+//	level: info
+//	encoderConfig:
+//	  timeKey: ts
+//	patterns:
+//	  plain: "%date{%Y-%m-%d %H:%M:%S.%3f} %level{upper} %message %fields"
+//	  debug: "%date %level{upper} %caller %x{tid} %message %fields"
+//	pattern: plain
+//	outputs:
+//	  - paths: ["stdout"]
+//	  - paths: ["/var/log/app/debug.log"]
+//	    level: "${DEBUG_LEVEL:-debug}"
+//	    pattern: debug
+//	  - paths: ["/var/log/app/app.json"]
+//	    encoding: json
+//
+// Without outputs, the logger writes to OutputPaths with Encoding, like
+// zap.Config. The encodings "" and "zaplogback" use a pattern,
+// "zaplogback-json" the JSON layout of a pattern, "json" and "console" use
+// zap's encoders.
+type Config struct {
+	zap.Config `yaml:",inline"`
+
+	// Pattern is the default pattern of the pattern encodings, either the
+	// name of one of Patterns or a log format. A value without '%' that is
+	// not a name of Patterns is an error.
+	Pattern string `json:"pattern" yaml:"pattern"`
+	// Patterns are named log formats.
+	Patterns map[string]string `json:"patterns" yaml:"patterns"`
+	// Outputs write the log to several destinations, each with its own
+	// encoding and level. They replace OutputPaths and Encoding.
+	Outputs []OutputConfig `json:"outputs" yaml:"outputs"`
+	// MessageEscape is the escape of %message without escape=, "safe" by
+	// default and "none" when Development is set, see MessageEscapeSafe.
+	// It applies to the encoders built by Build only, the other encoders use
+	// DefaultMessageEscape.
+	MessageEscape string `json:"messageEscape" yaml:"messageEscape"`
+	// Masking are the rules of a Masker that wraps the encoder of every
+	// output, including the json and console encodings.
+	Masking []MaskRule `json:"masking" yaml:"masking"`
+
+	compiled map[string]*Pattern
+	masker   *Masker
+}
+
+// OutputConfig is one destination of Config.Outputs.
+type OutputConfig struct {
+	// Paths are zap sink URLs or file paths, like zap.Config.OutputPaths.
+	Paths []string `json:"paths" yaml:"paths"`
+	// Level is the minimum level of the output, Config.Level if empty.
+	Level string `json:"level" yaml:"level"`
+	// Encoding is "", "zaplogback", "zaplogback-json", "json" or "console".
+	Encoding string `json:"encoding" yaml:"encoding"`
+	// Pattern is the name of one of Config.Patterns or a log format,
+	// Config.Pattern if empty.
+	Pattern string `json:"pattern" yaml:"pattern"`
+}
+
+// NewConfig returns a Config with the defaults of zap.NewProductionConfig,
+// except that Encoding is "zaplogback".
+func NewConfig() Config {
+	cfg := Config{Config: zap.NewProductionConfig()}
+	cfg.Encoding = _default_encoding_name
+	return cfg
+}
+
+// LoadConfig reads a YAML or JSON config file, chosen by its extension
+// (.json for JSON, YAML otherwise). See ParseConfig.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return ParseConfig(data, "json")
+	}
+	return ParseConfig(data, "yaml")
+}
+
+// ParseConfig decodes a "yaml" or "json" config over the defaults of
+// NewConfig. ${VAR} and ${VAR:-default} are replaced with environment
+// variables before decoding, and ZAPLOGBACK_PATTERN overrides Pattern. All
+// patterns and levels are validated.
+func ParseConfig(data []byte, format string) (*Config, error) {
+	data = expandEnv(data)
+
+	cfg := NewConfig()
+	switch format {
+	case "json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&cfg); err != nil {
+			return nil, fmt.Errorf("decode config: %w", err)
+		}
+	case "yaml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		// an empty file keeps the defaults
+		if err := decoder.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("decode config: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown config format %q", format)
+	}
+
+	if log_format, ok := os.LookupEnv(PatternEnv); ok && log_format != "" {
+		cfg.Pattern = log_format
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+var _env_regex_pattern = regexp.MustCompile(`\$\{(\w+)(:-([^}]*))?\}`)
+
+// expandEnv replaces ${VAR} and ${VAR:-default}. The default is used when
+// VAR is unset or empty, like the shell.
+func expandEnv(data []byte) []byte {
+	return _env_regex_pattern.ReplaceAllFunc(data, func(m []byte) []byte {
+		sub := _env_regex_pattern.FindSubmatch(m)
+		if value := os.Getenv(string(sub[1])); value != "" {
+			return []byte(value)
+		}
+		return sub[3]
+	})
+}
+
+// Validate compiles every pattern and checks the levels and encodings of
+// the outputs. Build calls it too.
+func (cfg *Config) Validate() error {
+	cfg.compiled = make(map[string]*Pattern)
+	var errs error
+
+	names := make([]string, 0, len(cfg.Patterns))
+	for name := range cfg.Patterns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		pattern, err := Compile(cfg.Patterns[name])
+		if err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("pattern %q: %w", name, err))
+			continue
+		}
+		cfg.compiled[name] = pattern
+	}
+	if _, err := cfg.patternOf(OutputConfig{}); err != nil {
+		errs = multierr.Append(errs, fmt.Errorf("pattern: %w", err))
+	}
+	if cfg.MessageEscape != "" {
+		if err := checkMessageEscape(cfg.MessageEscape); err != nil {
+			errs = multierr.Append(errs, err)
+		}
+	}
+	cfg.masker = nil
+	if len(cfg.Masking) > 0 {
+		masker, err := NewMasker(cfg.Masking...)
+		if err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("masking: %w", err))
+		}
+		cfg.masker = masker
+	}
+
+	for i, output := range cfg.outputs() {
+		if len(output.Paths) == 0 {
+			errs = multierr.Append(errs, fmt.Errorf("output %d: no paths", i))
+		}
+		if output.Level != "" {
+			if _, err := parseLevel(output.Level); err != nil {
+				errs = multierr.Append(errs, fmt.Errorf("output %d: %w", i, err))
+			}
+		}
+		switch output.Encoding {
+		case "", _default_encoding_name, _json_layout_encoding_name:
+			pattern, err := cfg.patternOf(output)
+			if err == nil && output.Encoding == _json_layout_encoding_name {
+				err = pattern.checkJSONLayout()
+			}
+			if err != nil {
+				errs = multierr.Append(errs, fmt.Errorf("output %d: %w", i, err))
+			}
+		case "json", "console":
+		default:
+			errs = multierr.Append(errs, fmt.Errorf("output %d: unknown encoding %q", i, output.Encoding))
+		}
+	}
+	return errs
+}
+
+// outputs returns Outputs, or one output made of OutputPaths and Encoding.
+func (cfg *Config) outputs() []OutputConfig {
+	if len(cfg.Outputs) > 0 {
+		return cfg.Outputs
+	}
+	return []OutputConfig{{
+		Paths:    cfg.OutputPaths,
+		Encoding: cfg.Encoding,
+	}}
+}
+
+func (cfg *Config) patternOf(output OutputConfig) (*Pattern, error) {
+	log_format := output.Pattern
+	if log_format == "" {
+		log_format = cfg.Pattern
+	}
+	if log_format == "" {
+		log_format = _default_log_format
+	}
+	if pattern, ok := cfg.compiled[log_format]; ok {
+		return pattern, nil
+	}
+	// 没有 % 的值多半是写错的 pattern 名字, 而不是只有文字的格式
+	if !strings.Contains(log_format, "%") {
+		return nil, fmt.Errorf("unknown pattern %q, neither one of Patterns nor a log format", log_format)
+	}
+	pattern, err := Compile(log_format)
+	if err != nil {
+		return nil, err
+	}
+	cfg.compiled[log_format] = pattern
+	return pattern, nil
+}
+
+// Build validates the config and builds a logger writing to every output.
+func (cfg *Config) Build(opts ...zap.Option) (*zap.Logger, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	var (
+		cores   []zapcore.Core
+		closers []func()
+	)
+	closeAll := func() {
+		for _, closeSink := range closers {
+			closeSink()
+		}
+	}
+
+	for i, output := range cfg.outputs() {
+		encoder, err := cfg.encoderOf(output)
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("output %d: %w", i, err)
+		}
+		if cfg.masker != nil {
+			encoder = cfg.masker.Wrap(encoder)
+		}
+		sink, closeSink, err := zap.Open(output.Paths...)
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("output %d: %w", i, err)
+		}
+		closers = append(closers, closeSink)
+		if output.Encoding != "json" && output.Encoding != "console" {
+			// %chain 在写入时追加 MAC
+			pattern, _ := cfg.patternOf(output)
+			sink = pattern.WrapWriter(sink)
+			if chain, ok := sink.(*ChainWriter); ok {
+				// 接着文件中已有的链
+				chain.Resume(lastChainMACOf(output.Paths))
+			}
+		}
+
+		var enabler zapcore.LevelEnabler = cfg.Level
+		if output.Level != "" {
+			level, _ := parseLevel(output.Level)
+			enabler = level
+		}
+		cores = append(cores, zapcore.NewCore(encoder, sink, enabler))
+	}
+
+	errSink, _, err := zap.Open(cfg.ErrorOutputPaths...)
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+
+	log := zap.New(zapcore.NewTee(cores...), cfg.buildOptions(errSink)...)
+	return log.WithOptions(opts...), nil
+}
+
+func (cfg *Config) encoderOf(output OutputConfig) (zapcore.Encoder, error) {
+	switch output.Encoding {
+	case "json":
+		return zapcore.NewJSONEncoder(cfg.EncoderConfig), nil
+	case "console":
+		return zapcore.NewConsoleEncoder(cfg.EncoderConfig), nil
+	}
+	pattern, err := cfg.patternOf(output)
+	if err != nil {
+		return nil, err
+	}
+	if err := pattern.checkKeyring(); err != nil {
+		return nil, err
+	}
+	if output.Encoding == _json_layout_encoding_name {
+		if err := pattern.checkJSONLayout(); err != nil {
+			return nil, err
+		}
+		return pattern.NewJSONEncoder(cfg.EncoderConfig), nil
+	}
+	encoder := newZaplogbackEncoder(cfg.EncoderConfig)
+	encoder.message_escape = cfg.messageEscape()
+	encoder.usePattern(pattern)
+	return encoder.encoder(), nil
+}
+
+// messageEscape returns MessageEscape, or the default of a production or a
+// development config.
+func (cfg *Config) messageEscape() string {
+	switch {
+	case cfg.MessageEscape != "":
+		return cfg.MessageEscape
+	case cfg.Development:
+		return MessageEscapeNone
+	}
+	return MessageEscapeSafe
+}
+
+// buildOptions mirrors zap.Config.buildOptions, which is unexported.
+func (cfg *Config) buildOptions(errSink zapcore.WriteSyncer) []zap.Option {
+	opts := []zap.Option{zap.ErrorOutput(errSink)}
+
+	if cfg.Development {
+		opts = append(opts, zap.Development())
+	}
+
+	if !cfg.DisableCaller {
+		opts = append(opts, zap.AddCaller())
+	}
+
+	stackLevel := zap.ErrorLevel
+	if cfg.Development {
+		stackLevel = zap.WarnLevel
+	}
+	if !cfg.DisableStacktrace {
+		opts = append(opts, zap.AddStacktrace(stackLevel))
+	}
+
+	if scfg := cfg.Sampling; scfg != nil {
+		opts = append(opts, zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+			var samplerOpts []zapcore.SamplerOption
+			if scfg.Hook != nil {
+				samplerOpts = append(samplerOpts, zapcore.SamplerHook(scfg.Hook))
+			}
+			return zapcore.NewSamplerWithOptions(core, time.Second, scfg.Initial, scfg.Thereafter, samplerOpts...)
+		}))
+	}
+
+	if len(cfg.InitialFields) > 0 {
+		fs := make([]zap.Field, 0, len(cfg.InitialFields))
+		keys := make([]string, 0, len(cfg.InitialFields))
+		for k := range cfg.InitialFields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fs = append(fs, zap.Any(k, cfg.InitialFields[k]))
+		}
+		opts = append(opts, zap.Fields(fs...))
+	}
+
+	return opts
+}
+
+// NewLoggerFromFile loads a config file and builds its logger.
+func NewLoggerFromFile(path string, opts ...zap.Option) (*zap.Logger, error) {
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.Build(opts...)
+}
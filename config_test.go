@@ -0,0 +1,95 @@
+package zaplogback
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestParseConfig(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("LOG_DIR", dir)
+	t.Setenv("DEBUG_LEVEL", "")
+
+	yamlConfig := `
+level: info
+disableCaller: true
+sampling: null
+patterns:
+  plain: "%level{upper} %message %fields"
+  debug: "%level{letter} %x{tid} %message"
+pattern: plain
+outputs:
+  - paths: ["${LOG_DIR}/info.log"]
+  - paths: ["${LOG_DIR}/debug.log"]
+    level: "${DEBUG_LEVEL:-debug}"
+    pattern: debug
+  - paths: ["${LOG_DIR}/app.json"]
+    encoding: json
+  - paths: ["${LOG_DIR}/layout.json"]
+    encoding: zaplogback-json
+    pattern: "%message %level %x{tid,as=trace}"
+`
+	cfg, err := ParseConfig([]byte(yamlConfig), "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger, err := cfg.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Debug("hidden", zap.String("tid", "t0"))
+	logger.Info("hi", zap.String("tid", "t1"))
+	_ = logger.Sync()
+
+	want := map[string]string{
+		"info.log":    "INFO hi {\"tid\":t1}\n",
+		"debug.log":   "D t0 hidden\nI t1 hi\n",
+		"layout.json": `{"msg":"hi","level":"info","trace":"t1"}` + "\n",
+	}
+	for name, content := range want {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != content {
+			t.Errorf("%s = %q, want %q", name, got, content)
+		}
+	}
+	got, _ := os.ReadFile(filepath.Join(dir, "app.json"))
+	if !strings.Contains(string(got), `"msg":"hi","tid":"t1"`) {
+		t.Errorf("app.json = %q", got)
+	}
+}
+
+func TestParseConfigPatternEnv(t *testing.T) {
+	t.Setenv(PatternEnv, "%level %message")
+	cfg, err := ParseConfig([]byte(`{"level": "warn", "pattern": "%message"}`), "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Pattern != "%level %message" {
+		t.Errorf("Pattern = %q, want the %s override", cfg.Pattern, PatternEnv)
+	}
+}
+
+func TestParseConfigValidation(t *testing.T) {
+	tests := []string{
+		`{"pattern": "%level{bogus}"}`,
+		`{"patterns": {"a": "%x{}"}}`,
+		`{"patterns": {"access": "%message"}, "pattern": "acess"}`,
+		`{"patterns": {"access": "%message"}, "outputs": [{"paths": ["stdout"], "pattern": "patern"}]}`,
+		`{"outputs": [{"paths": ["stdout"], "level": "loud"}]}`,
+		`{"outputs": [{"paths": ["stdout"], "encoding": "xml"}]}`,
+		`{"outputs": [{"encoding": "json"}]}`,
+		`{"unknown": 1}`,
+	}
+	for _, config := range tests {
+		if _, err := ParseConfig([]byte(config), "json"); err == nil {
+			t.Errorf("ParseConfig(%s): expected an error", config)
+		}
+	}
+}
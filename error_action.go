@@ -0,0 +1,242 @@
+package zaplogback
+
+import (
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+// _max_error_depth stops the walk of cause chains that loop.
+const _max_error_depth = 32
+
+// errorAction is %error{lines,verbose,key=err}: the error fields of the
+// entry with their causes, read with errors.Unwrap, errors.Join and the
+// Errors() of multierr (zap's errorCauses).
+//
+//	chain   read config → open app.yaml → no such file or directory (default)
+//	lines   read config
+//	        	caused by: open app.yaml
+//	        	caused by: no such file or directory
+//	message read config: open app.yaml: no such file or directory
+//
+// verbose appends the rest of the %+v form of errors that have one, e.g.
+// the stack of pkg/errors (zap's errorVerbose). Without key=, every error field is
+// written; the fields written are left out of %fields.
+type errorAction struct {
+	mode    string
+	verbose bool
+	key     string
+}
+
+func newErrorAction(config string) (Action, error) {
+	a := errorAction{mode: "chain"}
+	if config == "" {
+		return a, nil
+	}
+	for _, option := range strings.Split(config, ",") {
+		option = strings.TrimSpace(option)
+		switch {
+		case option == "chain" || option == "lines" || option == "message":
+			a.mode = option
+		case option == "verbose":
+			a.verbose = true
+		case strings.HasPrefix(option, "key="):
+			a.key = strings.TrimPrefix(option, "key=")
+			if a.key == "" {
+				return nil, fmt.Errorf("empty key in %%error{%s}", config)
+			}
+		default:
+			return nil, fmt.Errorf("unknown %%error option %q, want chain, lines, message, verbose or key=<field>", option)
+		}
+	}
+	return a, nil
+}
+
+func (a errorAction) configure(logback_config *LogbackConfig) {
+	if a.key == "" {
+		logback_config.writes_errors = true
+	}
+}
+
+func (a errorAction) UsedFields() []string {
+	if a.key == "" {
+		return nil
+	}
+	return []string{a.key}
+}
+
+func (a errorAction) AppendEntry(w ActionWriter, ent *zapcore.Entry, fields []zapcore.Field) {
+	final := w.enc
+	written := 0
+	for _, f := range fields {
+		err, ok := f.Interface.(error)
+		if f.Type != zapcore.ErrorType || !ok || a.key != "" && f.Key != a.key {
+			continue
+		}
+		if written > 0 {
+			if a.mode == "lines" {
+				final.buf.AppendByte('\n')
+			} else {
+				final.buf.AppendString("; ")
+			}
+		}
+		a.appendError(final.buf, final.defaultMessageEscape(), err)
+		written++
+	}
+}
+
+func (a errorAction) appendError(buf *buffer.Buffer, escape string, err error) {
+	text := func(s string) {
+		// 只转义错误文本, lines 模式的换行保留
+		if escape == MessageEscapeSafe || escape == _message_escape_safe_lines {
+			appendSafeString(buf, s, escape == _message_escape_safe_lines)
+		} else {
+			buf.AppendString(s)
+		}
+	}
+
+	switch a.mode {
+	case "message":
+		text(errorMessage(err))
+	case "lines":
+		own, causes := errorCausesOf(err)
+		for depth := 0; own == "" && len(causes) == 1 && depth < _max_error_depth; depth++ {
+			own, causes = errorCausesOf(causes[0])
+		}
+		if own == "" && len(causes) > 1 {
+			own = fmt.Sprintf("%d errors", len(causes))
+		}
+		text(own)
+		appendErrorLines(buf, text, causes, "\t", 0)
+	default:
+		appendErrorChain(buf, text, err, 0)
+	}
+
+	if a.verbose {
+		if formatter, ok := err.(fmt.Formatter); ok {
+			// %+v 通常以错误信息开头, 已经写过的部分不再重复
+			verbose := fmt.Sprintf("%+v", formatter)
+			verbose = strings.TrimLeft(strings.TrimPrefix(verbose, errorMessage(err)), "\n")
+			if verbose != "" {
+				buf.AppendByte('\n')
+				text(verbose)
+			}
+		}
+	}
+}
+
+// appendErrorChain writes own → cause → ..., the causes of a joined error
+// as [a; b → c].
+func appendErrorChain(buf *buffer.Buffer, text func(string), err error, depth int) {
+	own, causes := errorCausesOf(err)
+	if depth >= _max_error_depth {
+		causes = nil
+	}
+	text(own)
+	switch {
+	case len(causes) == 1:
+		if own != "" {
+			buf.AppendString(" → ")
+		}
+		appendErrorChain(buf, text, causes[0], depth+1)
+	case len(causes) > 1:
+		if own != "" {
+			buf.AppendString(" → ")
+		}
+		buf.AppendByte('[')
+		for i, cause := range causes {
+			if i > 0 {
+				buf.AppendString("; ")
+			}
+			appendErrorChain(buf, text, cause, depth+1)
+		}
+		buf.AppendByte(']')
+	}
+}
+
+// appendErrorLines writes a "caused by:" line per cause. A chain stays at
+// the same indent, the branches of a joined error are indented once more.
+func appendErrorLines(buf *buffer.Buffer, text func(string), causes []error, indent string, depth int) {
+	if depth >= _max_error_depth {
+		return
+	}
+	next := indent
+	if len(causes) > 1 {
+		next = indent + "\t"
+	}
+	for _, cause := range causes {
+		own, sub := errorCausesOf(cause)
+		if own != "" {
+			buf.AppendByte('\n')
+			buf.AppendString(indent)
+			buf.AppendString("caused by: ")
+			text(own)
+		}
+		appendErrorLines(buf, text, sub, next, depth+1)
+	}
+}
+
+// errorCausesOf splits err into its own message and its causes. The text of
+// a single cause is cut from the end of the message, "read config: open
+// app.yaml" wrapping "open app.yaml" is "read config"; a joined error that
+// only lists its causes has no own message.
+func errorCausesOf(err error) (string, []error) {
+	message := errorMessage(err)
+	var causes []error
+	switch e := err.(type) {
+	case interface{ Unwrap() []error }:
+		causes = e.Unwrap()
+	case interface{ Errors() []error }:
+		causes = e.Errors()
+	case interface{ Unwrap() error }:
+		if cause := e.Unwrap(); cause != nil {
+			causes = []error{cause}
+		}
+	}
+	causes = nonNilErrors(causes)
+
+	switch len(causes) {
+	case 0:
+		return message, nil
+	case 1:
+		cause := errorMessage(causes[0])
+		if own, ok := strings.CutSuffix(message, cause); ok {
+			return strings.TrimRight(strings.TrimSpace(own), ":"), causes
+		}
+		return message, causes
+	}
+	texts := make([]string, len(causes))
+	for i, cause := range causes {
+		texts[i] = errorMessage(cause)
+	}
+	for _, sep := range []string{"\n", "; "} {
+		if message == strings.Join(texts, sep) {
+			return "", causes
+		}
+	}
+	return message, causes
+}
+
+// nonNilErrors returns a copy of errs without nil, the slice of Unwrap
+// belongs to the error.
+func nonNilErrors(errs []error) []error {
+	var causes []error
+	for _, err := range errs {
+		if err != nil {
+			causes = append(causes, err)
+		}
+	}
+	return causes
+}
+
+// errorMessage returns err.Error(), or the panic it raised like zap does.
+func errorMessage(err error) (message string) {
+	defer func() {
+		if r := recover(); r != nil {
+			message = fmt.Sprintf("<PANIC=%v>", r)
+		}
+	}()
+	return err.Error()
+}
@@ -0,0 +1,131 @@
+package zaplogback
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// stackError formats with %+v like the errors of pkg/errors.
+type stackError struct {
+	msg string
+}
+
+func (e stackError) Error() string {
+	return e.msg
+}
+
+func (e stackError) Format(s fmt.State, verb rune) {
+	if verb == 'v' && s.Flag('+') {
+		io.WriteString(s, e.msg+"\nmain.load\n\t/src/main.go:12")
+		return
+	}
+	io.WriteString(s, e.msg)
+}
+
+// selfError unwraps to itself.
+type selfError struct{}
+
+func (e *selfError) Error() string { return "" }
+func (e *selfError) Unwrap() error { return e }
+
+// groupError is a multierr-style group, zap writes its errorCauses.
+type groupError []error
+
+func (g groupError) Error() string {
+	texts := make([]string, len(g))
+	for i, err := range g {
+		texts[i] = err.Error()
+	}
+	return strings.Join(texts, "; ")
+}
+
+func (g groupError) Errors() []error {
+	return g
+}
+
+func TestErrorAction(t *testing.T) {
+	open_err := &fs.PathError{Op: "open", Path: "app.yaml", Err: fs.ErrNotExist}
+	config_err := fmt.Errorf("read config: %w", open_err)
+	joined := errors.Join(config_err, fmt.Errorf("dial db: %w", errors.New("timeout")))
+	ent := zapcore.Entry{Message: "start failed"}
+	// 不转义, 见 TestMessageEscape
+	SetDevelopment(true)
+	defer SetDevelopment(false)
+
+	tests := []struct {
+		format string
+		fields []zapcore.Field
+		want   string
+	}{
+		{`%message: %error %fields`, []zapcore.Field{zap.Error(config_err), zap.Int("n", 1)},
+			`start failed: read config → open app.yaml → file does not exist {"n":1}`},
+		{`%message: %error{message}`, []zapcore.Field{zap.Error(config_err)},
+			`start failed: read config: open app.yaml: file does not exist`},
+		{`%message%n%error{lines}`, []zapcore.Field{zap.Error(config_err)},
+			"start failed\nread config\n\tcaused by: open app.yaml\n\tcaused by: file does not exist"},
+		{`%error`, []zapcore.Field{zap.Error(joined)},
+			`[read config → open app.yaml → file does not exist; dial db → timeout]`},
+		{`%error{lines}`, []zapcore.Field{zap.Error(fmt.Errorf("boot: %w", joined))},
+			"boot\n\tcaused by: read config\n\t\tcaused by: open app.yaml\n\t\tcaused by: file does not exist\n\tcaused by: dial db\n\t\tcaused by: timeout"},
+		{`%error %fields`, []zapcore.Field{zap.Error(groupError{errors.New("a"), errors.New("b")})},
+			`[a; b] {}`},
+		{`%error{verbose} %fields`, []zapcore.Field{zap.Error(stackError{"load"})},
+			"load\nmain.load\n\t/src/main.go:12 {}"},
+		{`%error{key=cause} %fields`, []zapcore.Field{zap.Error(errors.New("x")), zap.NamedError("cause", errors.New("y"))},
+			`y {"error":x}`},
+		{`%error; %fields`, []zapcore.Field{zap.Error(errors.New("x")), zap.NamedError("cause", errors.New("y"))},
+			`x; y; {}`},
+		{`%message %error`, nil, `start failed `},
+		{`%error{lines}`, []zapcore.Field{zap.Error(&selfError{})}, ``},
+		{`%error`, []zapcore.Field{zap.Error(&selfError{})}, ``},
+	}
+	for _, tt := range tests {
+		if got := encodeForTest(t, tt.format, ent, tt.fields...); got != tt.want {
+			t.Errorf("%s:\ngot  %q\nwant %q", tt.format, got, tt.want)
+		}
+	}
+
+	for _, format := range []string{`%error{tree}`, `%error{key=}`} {
+		if _, err := Compile(format); err == nil {
+			t.Errorf("%s: expected an error", format)
+		}
+	}
+}
+
+func TestErrorActionJSONLayout(t *testing.T) {
+	cfg := zap.NewProductionEncoderConfig()
+	cfg.TimeKey = ""
+	cfg.SkipLineEnding = true
+	enc, err := NewJSONLayoutEncoder(cfg, `%message %error %fields`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := enc.EncodeEntry(zapcore.Entry{Message: "failed"}, []zapcore.Field{zap.Error(fmt.Errorf("a: %w", errors.New("b"))), zap.Int("n", 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), `{"msg":"failed","error":"a → b","n":1}`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestParserError(t *testing.T) {
+	p, err := NewParser(`%level %message | %error{key=cause} %fields`, zap.NewProductionEncoderConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, ok := p.ParseRecord(`info failed | a → b {"n":1}`)
+	if !ok {
+		t.Fatal("ParseRecord failed")
+	}
+	if got, _ := r.Field("cause"); got != "a → b" {
+		t.Errorf("cause = %q", got)
+	}
+}
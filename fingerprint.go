@@ -0,0 +1,187 @@
+package zaplogback
+
+import (
+	"crypto/sha256"
+	"encoding/base32"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// _default_fingerprint_len is the number of base32 characters of a
+// fingerprint, 50 bits.
+const _default_fingerprint_len = 10
+
+var _fingerprint_encoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// fingerprintRule replaces the matches of regex with placeholder, the
+// matches rejected by validate are kept.
+type fingerprintRule struct {
+	regex       *regexp.Regexp
+	placeholder string
+	validate    func(string) bool
+}
+
+// _default_fingerprint_rules are the rules of %fingerprint without rules in
+// its config, in this order; "ip" is also built in.
+var _default_fingerprint_rules = []string{"quoted", "uuid", "hex", "digits"}
+
+var (
+	_fingerprint_mutex sync.RWMutex
+	_fingerprint_rules = map[string]fingerprintRule{
+		"quoted": {regexp.MustCompile(`"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'`), "<q>", nil},
+		"uuid":   {regexp.MustCompile(`\b[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}\b`), "<uuid>", nil},
+		"hex":    {regexp.MustCompile(`\b(?:0[xX][0-9a-fA-F]+|[0-9a-fA-F]{8,})\b`), "<hex>", hasDigit},
+		"ip":     {regexp.MustCompile(`\b\d{1,3}(?:\.\d{1,3}){3}(?::\d+)?\b`), "<ip>", nil},
+		"digits": {regexp.MustCompile(`\d+(?:\.\d+)?`), "<n>", nil},
+	}
+)
+
+// hasDigit keeps words such as "deadline" out of the hex rule.
+func hasDigit(s string) bool {
+	return strings.ContainsAny(s, "0123456789")
+}
+
+// RegisterFingerprintRule registers a normalization rule of %fingerprint:
+// the matches of expr are replaced with placeholder before hashing, e.g.
+// RegisterFingerprintRule("order", `ORD-\w+`, "<order>") for
+// %fingerprint{order,digits}.
+func RegisterFingerprintRule(name string, expr string, placeholder string) error {
+	if !_action_name_regex_pattern.MatchString(name) {
+		return fmt.Errorf("invalid fingerprint rule name %q", name)
+	}
+	regex, err := regexp.Compile(expr)
+	if err != nil {
+		return err
+	}
+	_fingerprint_mutex.Lock()
+	defer _fingerprint_mutex.Unlock()
+	if _, dup := _fingerprint_rules[name]; dup || name == "none" {
+		return fmt.Errorf("fingerprint rule %q already registered", name)
+	}
+	_fingerprint_rules[name] = fingerprintRule{regex: regex, placeholder: placeholder}
+	return nil
+}
+
+// fingerprintAction is %fingerprint{...}: a short base32 ID of the kind of
+// an entry, the SHA-256 of its caller (file and function, not the line),
+// logger name and normalized message. The config lists the normalization
+// rules, "none" for none, and the options:
+//
+//	len=N      length of the ID, 4 to 52, 10 by default
+//	nocaller   leaves the caller out
+//	nologger   leaves the logger name out
+type fingerprintAction struct {
+	rules     []fingerprintRule
+	length    int
+	no_caller bool
+	no_logger bool
+}
+
+// _default_fingerprint is the fingerprint of %json{fingerprint} in a pattern
+// without %fingerprint.
+var _default_fingerprint = mustFingerprintAction("")
+
+func mustFingerprintAction(config string) *fingerprintAction {
+	a, err := newFingerprintAction(config)
+	if err != nil {
+		panic(err)
+	}
+	return a.(*fingerprintAction)
+}
+
+func newFingerprintAction(config string) (Action, error) {
+	a := &fingerprintAction{length: _default_fingerprint_len}
+	var names []string
+	if config != "" {
+		for _, option := range strings.Split(config, ",") {
+			option = strings.TrimSpace(option)
+			switch {
+			case strings.HasPrefix(option, "len="):
+				n, err := strconv.Atoi(strings.TrimPrefix(option, "len="))
+				if err != nil || n < 4 || n > 52 {
+					return nil, fmt.Errorf("invalid fingerprint %s, want 4 to 52", option)
+				}
+				a.length = n
+			case option == "nocaller":
+				a.no_caller = true
+			case option == "nologger":
+				a.no_logger = true
+			default:
+				names = append(names, option)
+			}
+		}
+	}
+	if names == nil {
+		names = _default_fingerprint_rules
+	}
+
+	_fingerprint_mutex.RLock()
+	defer _fingerprint_mutex.RUnlock()
+	for _, name := range names {
+		if name == "none" {
+			if len(names) > 1 {
+				return nil, fmt.Errorf("fingerprint rule none can not be used with other rules")
+			}
+			break
+		}
+		rule, ok := _fingerprint_rules[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown fingerprint rule or option %q", name)
+		}
+		a.rules = append(a.rules, rule)
+	}
+	return a, nil
+}
+
+func (a *fingerprintAction) AppendEntry(w ActionWriter, ent *zapcore.Entry, fields []zapcore.Field) {
+	w.enc.buf.AppendString(a.fingerprint(ent, w.enc.messageTemplate(ent)))
+}
+
+// fingerprint hashes the caller, logger name and normalized message, the
+// template of %message{template} rather than the rendered message.
+func (a *fingerprintAction) fingerprint(ent *zapcore.Entry, msg string) string {
+	h := sha256.New()
+	if !a.no_caller && ent.Caller.Defined {
+		h.Write([]byte(fingerprintCaller(ent.Caller)))
+	}
+	h.Write([]byte{0})
+	if !a.no_logger {
+		h.Write([]byte(ent.LoggerName))
+	}
+	h.Write([]byte{0})
+	h.Write([]byte(a.normalize(msg)))
+	return _fingerprint_encoding.EncodeToString(h.Sum(nil))[:a.length]
+}
+
+// fingerprintCaller is the trimmed file and the function of the caller,
+// without the line, which moves with unrelated edits.
+func fingerprintCaller(caller zapcore.EntryCaller) string {
+	path := caller.TrimmedPath()
+	if colon := strings.LastIndexByte(path, ':'); colon >= 0 {
+		path = path[:colon]
+	}
+	return path + " " + caller.Function
+}
+
+// normalize replaces the variable parts of msg with the placeholders of the
+// rules, applied in order.
+func (a *fingerprintAction) normalize(msg string) string {
+	for _, rule := range a.rules {
+		if rule.validate == nil {
+			msg = rule.regex.ReplaceAllLiteralString(msg, rule.placeholder)
+			continue
+		}
+		msg = rule.regex.ReplaceAllStringFunc(msg, func(s string) string {
+			if rule.validate(s) {
+				return rule.placeholder
+			}
+			return s
+		})
+	}
+	return msg
+}
@@ -0,0 +1,123 @@
+package zaplogback
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestFingerprint(t *testing.T) {
+	caller := zapcore.NewEntryCaller(0, "/src/app/order/service.go", 42, true)
+	caller.Function = "app/order.(*Service).Pay"
+	ent := func(msg string) zapcore.Entry {
+		return zapcore.Entry{Message: msg, LoggerName: "order", Caller: caller}
+	}
+	fp := func(format string, e zapcore.Entry) string {
+		return encodeForTest(t, format, e)
+	}
+
+	a := fp(`%fingerprint`, ent(`payment 1234 of user "li" failed, trace 7f3a9c0d12e4, request 0b9a2c1e-7d4f-4a8b-9c3e-2f1d0e5a6b7c`))
+	if !regexp.MustCompile(`^[a-z2-7]{10}$`).MatchString(a) {
+		t.Fatalf("fingerprint %q", a)
+	}
+	if b := fp(`%fingerprint`, ent(`payment 98 of user "wang" failed, trace 0x1f, request 11111111-2222-3333-4444-555555555555`)); b != a {
+		t.Errorf("same template: %s != %s", b, a)
+	}
+	moved := ent(`payment 1 of user "li" failed, trace 7f3a9c0d12e4, request 0b9a2c1e-7d4f-4a8b-9c3e-2f1d0e5a6b7c`)
+	moved.Caller.Line = 50
+	if b := fp(`%fingerprint`, moved); b != a {
+		t.Errorf("the line is not part of the fingerprint: %s != %s", b, a)
+	}
+	if b := fp(`%fingerprint`, ent(`refund 1234 of user "li" failed`)); b == a {
+		t.Errorf("different messages have the same fingerprint %s", a)
+	}
+	// deadline 不是十六进制
+	if got, want := _default_fingerprint.normalize(`deadline 0xff a1b2c3d4e5 at 10.0.0.1 'x'`), `deadline <hex> <hex> at <n>.<n> <q>`; got != want {
+		t.Errorf("normalize = %q, want %q", got, want)
+	}
+
+	other := ent("payment 1 failed")
+	other.LoggerName = "billing"
+	other.Caller.Function = "app/billing.Charge"
+	if fp(`%fingerprint`, other) == fp(`%fingerprint`, ent("payment 1 failed")) {
+		t.Errorf("caller and logger are part of the fingerprint")
+	}
+	if fp(`%fingerprint{nocaller,nologger}`, other) != fp(`%fingerprint{nocaller,nologger}`, ent("payment 1 failed")) {
+		t.Errorf("nocaller,nologger")
+	}
+	if fp(`%fingerprint{none}`, ent("retry 1")) == fp(`%fingerprint{none}`, ent("retry 2")) {
+		t.Errorf("none normalizes digits")
+	}
+	if got := fp(`%fingerprint{len=16,digits}`, ent("x")); len(got) != 16 {
+		t.Errorf("len=16: %q", got)
+	}
+
+	if err := RegisterFingerprintRule("order_no", `ORD-\w+`, "<order>"); err != nil {
+		t.Fatal(err)
+	}
+	if fp(`%fingerprint{order_no}`, ent("ship ORD-a1")) != fp(`%fingerprint{order_no}`, ent("ship ORD-zz")) {
+		t.Errorf("custom rule")
+	}
+	if err := RegisterFingerprintRule("digits", `\d`, "<d>"); err == nil {
+		t.Errorf("registered digits twice")
+	}
+
+	for _, format := range []string{`%fingerprint{len=2}`, `%fingerprint{words}`, `%fingerprint{none,digits}`} {
+		if _, err := Compile(format); err == nil {
+			t.Errorf("%s: expected an error", format)
+		}
+	}
+}
+
+func TestFingerprintJSON(t *testing.T) {
+	cfg := zap.NewProductionEncoderConfig()
+	cfg.TimeKey = ""
+	cfg.SkipLineEnding = true
+	e := zapcore.Entry{Message: "user 12 logged in"}
+	want := encodeForTest(t, `%fingerprint`, e)
+
+	layout, err := NewJSONLayoutEncoder(cfg, `%message %fingerprint`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := layout.EncodeEntry(e, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != `{"msg":"user 12 logged in","fingerprint":"`+want+`"}` {
+		t.Errorf("JSON layout: %s", got)
+	}
+
+	got := encodeForTest(t, `%json{msg,fp}`, e)
+	if got != `{"msg":"user 12 logged in","fingerprint":"`+want+`"}` {
+		t.Errorf("%%json: %s", got)
+	}
+
+	// %json{fingerprint} 与同一格式中的 %fingerprint 一致
+	custom := encodeForTest(t, `%fingerprint{none,len=12}`, e)
+	got = encodeForTest(t, `%fingerprint{none,len=12} %json{fingerprint}`, e)
+	if want := custom + ` {"fingerprint":"` + custom + `"}`; got != want {
+		t.Errorf("%%json with %%fingerprint: got %s, want %s", got, want)
+	}
+	got = encodeForTest(t, `%json{fingerprint=none|len=12} %fingerprint`, e)
+	if want := `{"fingerprint":"` + custom + `"} ` + want; got != want {
+		t.Errorf("%%json{fingerprint=...}: got %s, want %s", got, want)
+	}
+	for _, format := range []string{`%json{fingerprint=words}`, `%json{msg=x}`} {
+		if _, err := Compile(format); err == nil {
+			t.Errorf("%s: expected an error", format)
+		}
+	}
+
+	p, err := NewParser(`%fingerprint %message`, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, ok := p.ParseRecord(want + " " + e.Message)
+	if !ok || r.Values["fingerprint"] != want || !strings.HasPrefix(r.Message, "user") {
+		t.Errorf("ParseRecord = %+v, %v", r, ok)
+	}
+}
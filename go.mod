@@ -2,6 +2,8 @@ module github.com/SheldonXLD/zaplogback
 
 go 1.22.4
 
-require go.uber.org/zap v1.27.0
-
-require go.uber.org/multierr v1.10.0 // indirect
+require (
+	go.uber.org/multierr v1.10.0
+	go.uber.org/zap v1.27.0
+	gopkg.in/yaml.v3 v3.0.1
+)
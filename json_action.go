@@ -0,0 +1,276 @@
+package zaplogback
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/multierr"
+	"go.uber.org/zap/zapcore"
+)
+
+// _json_part_names are the parts %json{...} accepts, with their aliases.
+var _json_part_names = map[string]string{
+	"time":        "date",
+	"date":        "date",
+	"ts":          "date",
+	"level":       "level",
+	"caller":      "caller",
+	"logger":      "logger",
+	"name":        "logger",
+	"msg":         "message",
+	"message":     "message",
+	"func":        "func",
+	"function":    "func",
+	"stacktrace":  "stacktrace",
+	"stack":       "stacktrace",
+	"fields":      "fields",
+	"fingerprint": "fingerprint",
+	"fp":          "fingerprint",
+	"template":    "template",
+}
+
+// jsonAction is %json{msg,fields,caller}: the selected entry parts and the
+// fields as one JSON object, for pipelines that split a line at its first
+// '{'. Strings are escaped like field values. The fields are the fields of
+// the entry that %x did not write, and the context of With.
+//
+// The fingerprint part takes the config of %fingerprint with '|' in place of
+// ',', e.g. %json{msg,fingerprint=quoted|order|len=12}. Without one it uses
+// the %fingerprint of the same pattern, or the default rules.
+type jsonAction struct {
+	parts       []string
+	fingerprint *fingerprintAction
+}
+
+// %json, %json{msg,fields} 或 %json{time,level,msg,caller,fields}
+func newJSONAction(config string) (Action, error) {
+	if config == "" {
+		config = "msg,fields"
+	}
+	a := jsonAction{}
+	for _, name := range strings.Split(config, ",") {
+		name, options, has_options := strings.Cut(strings.TrimSpace(name), "=")
+		part, ok := _json_part_names[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown %%json part %q", name)
+		}
+		if has_options {
+			if part != "fingerprint" {
+				return nil, fmt.Errorf("%%json part %q has no options", name)
+			}
+			fingerprint, err := newFingerprintAction(strings.ReplaceAll(options, "|", ","))
+			if err != nil {
+				return nil, err
+			}
+			a.fingerprint = fingerprint.(*fingerprintAction)
+		}
+		a.parts = append(a.parts, part)
+	}
+	return a, nil
+}
+
+// linkJSONFingerprint makes %json{fingerprint} without options use the
+// first %fingerprint of the pattern, so that both write the same ID.
+func linkJSONFingerprint(elements []PatternAction) {
+	var fingerprint *fingerprintAction
+	for _, element := range elements {
+		if a, ok := element.Action.(*fingerprintAction); ok {
+			fingerprint = a
+			break
+		}
+	}
+	if fingerprint == nil {
+		return
+	}
+	for i, element := range elements {
+		if a, ok := element.Action.(jsonAction); ok && a.fingerprint == nil {
+			a.fingerprint = fingerprint
+			elements[i].Action = a
+		}
+	}
+}
+
+func (a jsonAction) configure(logback_config *LogbackConfig) {
+	for _, part := range a.parts {
+		switch part {
+		case "fields":
+			logback_config.writes_json_context = true
+		case "stacktrace":
+			logback_config.writes_stack = true
+		}
+	}
+}
+
+// jsonPartKey returns the key of a part, the key of the EncoderConfig.
+func jsonPartKey(part string, cfg *zapcore.EncoderConfig) string {
+	if part == "func" {
+		return cfg.FunctionKey
+	}
+	return jsonKeyOf(PatternAction{Name: part}, cfg)
+}
+
+func (a jsonAction) AppendEntry(w ActionWriter, ent *zapcore.Entry, fields []zapcore.Field) {
+	final := w.enc
+	line := final.buf
+	scratch := final.clone()
+	defer func() {
+		scratch.buf.Free()
+		putlogbackEncoder(scratch)
+	}()
+	value_enc := &jsonValueEncoder{logbackEncoder: scratch}
+
+	line.AppendByte('{')
+	// 每个部分的开始位置, 超过行长度限制时按部分丢弃, 见 limitHybridLine
+	final.json_object.starts = final.json_object.starts[:0]
+	for _, part := range a.parts {
+		final.json_object.starts = append(final.json_object.starts, line.Len())
+		switch part {
+		case "fields":
+			a.appendFields(final, fields)
+			continue
+		case "func":
+			if key := final.FunctionKey; key != "" && ent.Caller.Function != "" {
+				appendJSONKey(line, key)
+				appendJSONString(line, ent.Caller.Function)
+			}
+			continue
+		case "fingerprint":
+			appendJSONKey(line, "fingerprint")
+			fingerprint := a.fingerprint
+			if fingerprint == nil {
+				fingerprint = _default_fingerprint
+			}
+			appendJSONString(line, fingerprint.fingerprint(ent, final.messageTemplate(ent)))
+			continue
+		case "template":
+			appendJSONKey(line, "template")
+			appendJSONString(line, final.messageTemplate(ent))
+			continue
+		}
+		if key := jsonPartKey(part, final.EncoderConfig); key != "" {
+			value_enc.appendEntryPart(line, part, key, ent)
+		}
+	}
+	final.json_object.end = line.Len()
+	line.AppendByte('}')
+}
+
+// appendFields appends the fields that %x did not write, after the context
+// of With when the encoder keeps it as JSON.
+func (a jsonAction) appendFields(final *logbackEncoder, fields []zapcore.Field) {
+	remaining := make([]zapcore.Field, 0, len(fields))
+	for i, f := range fields {
+		if !final.isUsedField(i, f) {
+			remaining = append(remaining, f)
+		}
+	}
+	if _, err := appendJSONMembers(final.buf, final.json_context, remaining); err != nil {
+		appendJSONKey(final.buf, "error")
+		appendJSONString(final.buf, err.Error())
+	}
+}
+
+// jsonContextEncoder is the encoder of a pattern with %json{fields}. The
+// context of With is kept as text for %fields and as JSON for %json.
+type jsonContextEncoder struct {
+	*logbackEncoder
+}
+
+func (enc jsonContextEncoder) AddArray(key string, arr zapcore.ArrayMarshaler) error {
+	arr = enc.limits.limitArray(arr)
+	return multierr.Append(enc.logbackEncoder.AddArray(key, arr), enc.json_context.AddArray(key, arr))
+}
+
+func (enc jsonContextEncoder) AddObject(key string, obj zapcore.ObjectMarshaler) error {
+	return multierr.Append(enc.logbackEncoder.AddObject(key, obj), enc.json_context.AddObject(key, obj))
+}
+
+func (enc jsonContextEncoder) AddReflected(key string, obj interface{}) error {
+	if text, ok := enc.limits.limitReflected(obj); ok {
+		enc.AddString(key, text)
+		return nil
+	}
+	return multierr.Append(enc.logbackEncoder.AddReflected(key, obj), enc.json_context.AddReflected(key, obj))
+}
+
+func (enc jsonContextEncoder) AddBinary(key string, val []byte) {
+	if text, ok := enc.limits.limitBinary(val, enc.value_format); ok {
+		enc.AddString(key, text)
+		return
+	}
+	enc.logbackEncoder.AddBinary(key, val)
+	enc.json_context.AddBinary(key, val)
+}
+
+func (enc jsonContextEncoder) AddByteString(key string, val []byte) {
+	val = enc.limits.limitBytes(val)
+	enc.logbackEncoder.AddByteString(key, val)
+	enc.json_context.AddByteString(key, val)
+}
+
+func (enc jsonContextEncoder) AddBool(key string, val bool) {
+	enc.logbackEncoder.AddBool(key, val)
+	enc.json_context.AddBool(key, val)
+}
+
+func (enc jsonContextEncoder) AddComplex128(key string, val complex128) {
+	enc.logbackEncoder.AddComplex128(key, val)
+	enc.json_context.AddComplex128(key, val)
+}
+
+func (enc jsonContextEncoder) AddComplex64(key string, val complex64) {
+	enc.logbackEncoder.AddComplex64(key, val)
+	enc.json_context.AddComplex64(key, val)
+}
+
+func (enc jsonContextEncoder) AddDuration(key string, val time.Duration) {
+	enc.logbackEncoder.AddDuration(key, val)
+	enc.json_context.AddDuration(key, val)
+}
+
+func (enc jsonContextEncoder) AddFloat64(key string, val float64) {
+	enc.logbackEncoder.AddFloat64(key, val)
+	enc.json_context.AddFloat64(key, val)
+}
+
+func (enc jsonContextEncoder) AddFloat32(key string, val float32) {
+	enc.logbackEncoder.AddFloat32(key, val)
+	enc.json_context.AddFloat32(key, val)
+}
+
+func (enc jsonContextEncoder) AddInt64(key string, val int64) {
+	enc.logbackEncoder.AddInt64(key, val)
+	enc.json_context.AddInt64(key, val)
+}
+
+func (enc jsonContextEncoder) AddUint64(key string, val uint64) {
+	enc.logbackEncoder.AddUint64(key, val)
+	enc.json_context.AddUint64(key, val)
+}
+
+func (enc jsonContextEncoder) AddString(key, val string) {
+	val = enc.limits.limitString(val)
+	enc.logbackEncoder.AddString(key, val)
+	enc.json_context.AddString(key, val)
+}
+
+func (enc jsonContextEncoder) AddTime(key string, val time.Time) {
+	enc.logbackEncoder.AddTime(key, val)
+	enc.json_context.AddTime(key, val)
+}
+
+func (enc jsonContextEncoder) OpenNamespace(key string) {
+	enc.logbackEncoder.OpenNamespace(key)
+	enc.json_context.OpenNamespace(key)
+}
+
+func (enc jsonContextEncoder) AddInt(k string, v int)         { enc.AddInt64(k, int64(v)) }
+func (enc jsonContextEncoder) AddInt32(k string, v int32)     { enc.AddInt64(k, int64(v)) }
+func (enc jsonContextEncoder) AddInt16(k string, v int16)     { enc.AddInt64(k, int64(v)) }
+func (enc jsonContextEncoder) AddInt8(k string, v int8)       { enc.AddInt64(k, int64(v)) }
+func (enc jsonContextEncoder) AddUint(k string, v uint)       { enc.AddUint64(k, uint64(v)) }
+func (enc jsonContextEncoder) AddUint32(k string, v uint32)   { enc.AddUint64(k, uint64(v)) }
+func (enc jsonContextEncoder) AddUint16(k string, v uint16)   { enc.AddUint64(k, uint64(v)) }
+func (enc jsonContextEncoder) AddUint8(k string, v uint8)     { enc.AddUint64(k, uint64(v)) }
+func (enc jsonContextEncoder) AddUintptr(k string, v uintptr) { enc.AddUint64(k, uint64(v)) }
@@ -0,0 +1,74 @@
+package zaplogback
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestJSONAction(t *testing.T) {
+	ent := zapcore.Entry{
+		Level:   zapcore.InfoLevel,
+		Time:    time.Date(2024, 7, 6, 20, 32, 18, 335e6, time.UTC),
+		Message: "paid \"10\"\n\x1b[31m",
+		Caller:  zapcore.EntryCaller{Defined: true, File: "/src/shop/pay.go", Line: 7, Function: "shop.Pay"},
+	}
+	fields := []zapcore.Field{zap.String("tid", "abc"), zap.Int("user", 1), zap.String("note", "a\tb")}
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{`%date{%Y-%m-%d %H:%M:%S.%3f} %level{upper} %json`,
+			`2024-07-06 20:32:18.335 INFO {"msg":"paid \"10\"\n\u001b[31m","tid":"abc","user":1,"note":"a\tb"}`},
+		{`%level{upper} %x{tid} %json{msg, fields, caller, func}`,
+			`INFO abc {"msg":"paid \"10\"\n\u001b[31m","user":1,"note":"a\tb","caller":"shop/pay.go:7"}`},
+		{`%json{level,time}`,
+			`{"level":"info","ts":1720297938.335}`},
+	}
+	for _, tt := range tests {
+		got := encodeForTest(t, tt.format, ent, fields...)
+		if got != tt.want {
+			t.Errorf("%s:\ngot  %s\nwant %s", tt.format, got, tt.want)
+		}
+		if body := got[strings.IndexByte(got, '{'):]; !json.Valid([]byte(body)) {
+			t.Errorf("%s: invalid JSON %s", tt.format, body)
+		}
+	}
+
+	if _, err := Compile(`%json{msg,nope}`); err == nil {
+		t.Error("expected an error for an unknown part")
+	}
+}
+
+func TestJSONActionContext(t *testing.T) {
+	cfg := zap.NewProductionEncoderConfig()
+	cfg.FunctionKey = "func"
+	enc, err := BuildZaplogbackEncoder(cfg, `%level{upper} %json{msg,fields,func}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zap.String("service", "shop").AddTo(enc)
+	with := enc.Clone()
+	zap.Namespace("req").AddTo(with)
+
+	ent := zapcore.Entry{
+		Level:   zapcore.ErrorLevel,
+		Message: "failed",
+		Caller:  zapcore.EntryCaller{Defined: true, File: "pay.go", Line: 7, Function: "shop.Pay"},
+		Stack:   "main.main\n\tmain.go:3",
+	}
+	buf, err := with.EncodeEntry(ent, []zapcore.Field{zap.Int("id", 7)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer buf.Free()
+	want := `ERROR {"msg":"failed","service":"shop","req":{"id":7},"func":"shop.Pay"}` + "\nmain.main\n\tmain.go:3\n"
+	if buf.String() != want {
+		t.Errorf("got  %q\nwant %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,403 @@
+package zaplogback
+
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/SheldonXLD/zaplogback/internal/bufferpool"
+)
+
+const _json_layout_encoding_name = "zaplogback-json"
+
+// jsonLayoutEncoder writes each entry as one JSON object whose members
+// follow the order of the pattern, e.g. `%date %level %message %x{tid}
+// %fields` writes {"ts":"...","level":"INFO","msg":"...","tid":"abc",...}.
+// Literal text is ignored. The key of an action is its as= option, or the
+// key of the EncoderConfig (TimeKey for %date, MessageKey for %message...),
+// or the field name for %x and the action name otherwise.
+type jsonLayoutEncoder struct {
+	// fields holds the context of With, a zap JSON encoder without entry keys
+	zapcore.Encoder
+	// bare writes the fields of %x, without the context
+	bare zapcore.Encoder
+	// text runs the actions of the pattern
+	text    *logbackEncoder
+	pattern *Pattern
+}
+
+// NewJSONEncoder builds an encoder that writes entries as JSON objects with
+// the keys in the order of the pattern. It ignores the value encoders of
+// %fields{time=...}, which NewJSONLayoutEncoder rejects.
+func (p *Pattern) NewJSONEncoder(cfg zapcore.EncoderConfig) zapcore.Encoder {
+	text := newZaplogbackEncoder(cfg)
+	text.usePattern(p)
+	fields_cfg := jsonFieldsConfig(text.EncoderConfig)
+	enc := &jsonLayoutEncoder{
+		Encoder: zapcore.NewJSONEncoder(fields_cfg),
+		bare:    zapcore.NewJSONEncoder(fields_cfg),
+		text:    text,
+		pattern: p,
+	}
+	if text.encrypter != nil {
+		return text.encrypter.Wrap(enc)
+	}
+	return enc
+}
+
+// NewJSONLayoutEncoder compiles log_format and builds its JSON layout
+// encoder, see Pattern.NewJSONEncoder.
+func NewJSONLayoutEncoder(cfg zapcore.EncoderConfig, log_format string) (zapcore.Encoder, error) {
+	pattern, err := Compile(log_format)
+	if err != nil {
+		return nil, err
+	}
+	if err := pattern.checkKeyring(); err != nil {
+		return nil, err
+	}
+	if err := pattern.checkChain(); err != nil {
+		return nil, err
+	}
+	if err := pattern.checkJSONLayout(); err != nil {
+		return nil, err
+	}
+	return pattern.NewJSONEncoder(cfg), nil
+}
+
+// RegisterJSONLayoutEncoder registers the JSON layout of log_format as a zap
+// encoding, "zaplogback-json" by default.
+func RegisterJSONLayoutEncoder(encoding string, log_format string) error {
+	if log_format == "" {
+		log_format = _default_log_format
+	}
+	if encoding == "" {
+		encoding = _json_layout_encoding_name
+	}
+
+	pattern, err := Compile(log_format)
+	if err == nil {
+		err = pattern.checkChain()
+	}
+	if err == nil {
+		err = pattern.checkJSONLayout()
+	}
+	if err != nil {
+		return err
+	}
+	err = zap.RegisterEncoder(encoding, func(encoderConfig zapcore.EncoderConfig) (zapcore.Encoder, error) {
+		if err := pattern.checkKeyring(); err != nil {
+			return nil, err
+		}
+		return pattern.NewJSONEncoder(encoderConfig), nil
+	})
+	if err != nil {
+		return fmt.Errorf("encoding %q already exists", encoding)
+	}
+	return nil
+}
+
+// jsonFieldsConfig returns the config of a zap JSON encoder that writes the
+// fields only, with the value encoders of cfg.
+func jsonFieldsConfig(cfg *zapcore.EncoderConfig) zapcore.EncoderConfig {
+	fields_cfg := *cfg
+	fields_cfg.TimeKey = ""
+	fields_cfg.LevelKey = ""
+	fields_cfg.NameKey = ""
+	fields_cfg.CallerKey = ""
+	fields_cfg.FunctionKey = ""
+	fields_cfg.MessageKey = ""
+	fields_cfg.StacktraceKey = ""
+	fields_cfg.SkipLineEnding = true
+	return fields_cfg
+}
+
+// appendJSONMembers appends the members written by a zap JSON encoder for
+// the fields, after its context. It reports whether anything was written.
+func appendJSONMembers(line *buffer.Buffer, enc zapcore.Encoder, fields []zapcore.Field) (bool, error) {
+	object, err := enc.EncodeEntry(zapcore.Entry{}, fields)
+	if err != nil {
+		return false, err
+	}
+	defer object.Free()
+	members := bytes.TrimSuffix(bytes.TrimPrefix(object.Bytes(), []byte{'{'}), []byte{'}'})
+	if len(members) == 0 {
+		return false, nil
+	}
+	appendJSONSeparator(line)
+	line.Write(members)
+	return true, nil
+}
+
+// appendJSONSeparator appends a comma unless line ends with the opening
+// brace of the object.
+func appendJSONSeparator(line *buffer.Buffer) {
+	if b := line.Bytes(); len(b) > 0 && b[len(b)-1] != '{' {
+		line.AppendByte(',')
+	}
+}
+
+func appendJSONString(line *buffer.Buffer, s string) {
+	line.AppendByte('"')
+	safeAppendStringLike((*buffer.Buffer).AppendString, utf8.DecodeRuneInString, line, s)
+	line.AppendByte('"')
+}
+
+func appendJSONKey(line *buffer.Buffer, key string) {
+	appendJSONSeparator(line)
+	appendJSONString(line, key)
+	line.AppendByte(':')
+}
+
+// jsonValueEncoder collects what the level, time, caller and name encoders
+// of the EncoderConfig append. Strings are kept unescaped and remembered, so
+// they can be quoted once.
+type jsonValueEncoder struct {
+	*logbackEncoder
+	is_string bool
+}
+
+func (enc *jsonValueEncoder) AppendString(val string) {
+	enc.is_string = true
+	enc.buf.AppendString(val)
+}
+
+func (enc *jsonValueEncoder) AppendByteString(val []byte) {
+	enc.is_string = true
+	enc.buf.AppendBytes(val)
+}
+
+// appendValue appends what encode wrote as a JSON value, or fallback when it
+// wrote nothing.
+func (enc *jsonValueEncoder) appendValue(line *buffer.Buffer, key string, encode func(zapcore.PrimitiveArrayEncoder), fallback string) {
+	enc.buf.Reset()
+	enc.is_string = false
+	encode(enc)
+	appendJSONKey(line, key)
+	switch {
+	case enc.buf.Len() == 0:
+		appendJSONString(line, fallback)
+	case enc.is_string:
+		appendJSONString(line, enc.buf.String())
+	default:
+		line.Write(enc.buf.Bytes())
+	}
+}
+
+// appendEntryPart appends the member of an entry part: "date", "level",
+// "caller", "logger", "message" or "stacktrace". The parts that are not set,
+// like the caller of an entry without caller, are left out. It reports
+// whether part is one of those.
+func (enc *jsonValueEncoder) appendEntryPart(line *buffer.Buffer, part string, key string, ent *zapcore.Entry) bool {
+	cfg := enc.EncoderConfig
+	switch part {
+	case "date":
+		if ent.Time.IsZero() {
+			break
+		}
+		encode_time := cfg.EncodeTime
+		if encode_time == nil {
+			encode_time = zapcore.EpochNanosTimeEncoder
+		}
+		enc.appendValue(line, key, func(arr zapcore.PrimitiveArrayEncoder) {
+			encode_time(ent.Time, arr)
+		}, ent.Time.String())
+	case "level":
+		enc.appendValue(line, key, func(arr zapcore.PrimitiveArrayEncoder) {
+			if cfg.EncodeLevel != nil {
+				cfg.EncodeLevel(ent.Level, arr)
+			}
+		}, ent.Level.String())
+	case "caller":
+		if !ent.Caller.Defined {
+			break
+		}
+		enc.appendValue(line, key, func(arr zapcore.PrimitiveArrayEncoder) {
+			if cfg.EncodeCaller != nil {
+				cfg.EncodeCaller(ent.Caller, arr)
+			}
+		}, ent.Caller.String())
+	case "logger":
+		if ent.LoggerName == "" {
+			break
+		}
+		enc.appendValue(line, key, func(arr zapcore.PrimitiveArrayEncoder) {
+			if cfg.EncodeName != nil {
+				cfg.EncodeName(ent.LoggerName, arr)
+			}
+		}, ent.LoggerName)
+	case "message":
+		appendJSONKey(line, key)
+		appendJSONString(line, ent.Message)
+	case "stacktrace":
+		if ent.Stack != "" {
+			appendJSONKey(line, key)
+			appendJSONString(line, ent.Stack)
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// jsonKeyOf returns the key of an element, "" to leave it out.
+func jsonKeyOf(element PatternAction, cfg *zapcore.EncoderConfig) string {
+	if element.Key != "" {
+		return element.Key
+	}
+	switch element.Name {
+	case "date":
+		return cfg.TimeKey
+	case "level":
+		return cfg.LevelKey
+	case "caller":
+		return cfg.CallerKey
+	case "message":
+		return cfg.MessageKey
+	case "logger":
+		return cfg.NameKey
+	case "stacktrace":
+		return cfg.StacktraceKey
+	case "method":
+		return cfg.FunctionKey
+	case "x":
+		return _x_config_regex_pattern.FindStringSubmatch(element.Config)[1]
+	}
+	return element.Name
+}
+
+// AddArray and the Add methods below truncate the context of With like the
+// fields of the entries.
+func (enc *jsonLayoutEncoder) AddArray(key string, arr zapcore.ArrayMarshaler) error {
+	return enc.Encoder.AddArray(key, enc.text.limits.limitArray(arr))
+}
+
+func (enc *jsonLayoutEncoder) AddReflected(key string, obj interface{}) error {
+	if text, ok := enc.text.limits.limitReflected(obj); ok {
+		enc.Encoder.AddString(key, text)
+		return nil
+	}
+	return enc.Encoder.AddReflected(key, obj)
+}
+
+func (enc *jsonLayoutEncoder) AddBinary(key string, val []byte) {
+	if text, ok := enc.text.limits.limitBinary(val, nil); ok {
+		enc.Encoder.AddString(key, text)
+		return
+	}
+	enc.Encoder.AddBinary(key, val)
+}
+
+func (enc *jsonLayoutEncoder) AddByteString(key string, val []byte) {
+	enc.Encoder.AddByteString(key, enc.text.limits.limitBytes(val))
+}
+
+func (enc *jsonLayoutEncoder) AddString(key, val string) {
+	enc.Encoder.AddString(key, enc.text.limits.limitString(val))
+}
+
+func (enc *jsonLayoutEncoder) Clone() zapcore.Encoder {
+	return &jsonLayoutEncoder{
+		Encoder: enc.Encoder.Clone(),
+		bare:    enc.bare,
+		text:    enc.text,
+		pattern: enc.pattern,
+	}
+}
+
+func (enc *jsonLayoutEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	cfg := enc.text.EncoderConfig
+	scratch := enc.text.clone()
+	defer func() {
+		scratch.buf.Free()
+		putlogbackEncoder(scratch)
+	}()
+	scratch.renderTemplate(&ent, fields)
+	limits := enc.text.limits
+	if limits != nil {
+		ent.Message = limits.limitMessage(ent.Message)
+		fields = limits.limitFields(fields, nil)
+	}
+	line := bufferpool.Get()
+	value_enc := &jsonValueEncoder{logbackEncoder: scratch}
+
+	line.AppendByte('{')
+	// 每个 element 的开始位置, 超过行长度限制时从后往前丢弃
+	var starts []int
+	for _, element := range enc.pattern.elements {
+		key := jsonKeyOf(element, cfg)
+		if element.IsLiteral() || element.Name == "n" || key == "" {
+			continue
+		}
+		starts = append(starts, line.Len())
+
+		if element.Name == "logger" && element.Config != "" {
+			if ent.LoggerName != "" {
+				enc.appendActionValue(line, key, element, scratch, &ent, fields)
+			}
+			continue
+		}
+		if value_enc.appendEntryPart(line, element.Name, key, &ent) {
+			continue
+		}
+
+		switch element.Name {
+		case "x":
+			field := _x_config_regex_pattern.FindStringSubmatch(element.Config)[1]
+			for _, f := range fields {
+				if f.Key == field {
+					f.Key = key
+					if _, err := appendJSONMembers(line, enc.bare, []zapcore.Field{f}); err != nil {
+						return nil, err
+					}
+					break
+				}
+			}
+		case "fields":
+			remaining := make([]zapcore.Field, 0, len(fields))
+			for i, f := range fields {
+				if !scratch.isUsedField(i, f) {
+					remaining = append(remaining, f)
+				}
+			}
+			if _, err := appendJSONMembers(line, enc.Encoder, remaining); err != nil {
+				return nil, err
+			}
+		default:
+			enc.appendActionValue(line, key, element, scratch, &ent, fields)
+		}
+	}
+
+	// 同文本格式一样, 格式中没有 %stacktrace 时追加 stacktrace
+	if ent.Stack != "" && cfg.StacktraceKey != "" && !enc.pattern.logback_config.writes_stack {
+		starts = append(starts, line.Len())
+		appendJSONKey(line, cfg.StacktraceKey)
+		appendJSONString(line, ent.Stack)
+	}
+	limits.limitJSONLine(line, starts)
+	line.AppendByte('}')
+	line.AppendString(cfg.LineEnding)
+	return line, nil
+}
+
+// appendActionValue runs the text action of an element and appends its
+// output, as a number for the numeric actions and as a string otherwise.
+// Nothing is appended when the action writes nothing.
+func (enc *jsonLayoutEncoder) appendActionValue(line *buffer.Buffer, key string, element PatternAction,
+	scratch *logbackEncoder, ent *zapcore.Entry, fields []zapcore.Field) {
+	scratch.buf.Reset()
+	element.Action.AppendEntry(ActionWriter{scratch}, ent, fields)
+	if scratch.buf.Len() == 0 {
+		return
+	}
+	appendJSONKey(line, key)
+	switch element.Name {
+	case "line", "relative", "delta", "seq":
+		line.Write(scratch.buf.Bytes())
+	default:
+		appendJSONString(line, scratch.buf.String())
+	}
+}
@@ -0,0 +1,89 @@
+package zaplogback
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestJSONLayout(t *testing.T) {
+	ent := zapcore.Entry{
+		Level:      zapcore.WarnLevel,
+		Time:       time.Date(2024, 7, 6, 20, 32, 18, 335e6, time.UTC),
+		LoggerName: "app.db",
+		Message:    "slow \"query\"\n",
+		Caller:     zapcore.EntryCaller{Defined: true, File: "/src/app/db/conn.go", Line: 42},
+	}
+	fields := []zapcore.Field{zap.String("tid", "abc"), zap.Int("rows", 3), zap.Strings("tags", []string{"a", "b"})}
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{
+			`%date{%Y-%m-%d %H:%M:%S.%3f} [%level{upper}] %caller - %message %x{tid} %fields`,
+			`{"ts":"2024-07-06 20:32:18.335","level":"WARN","caller":"db/conn.go:42","msg":"slow \"query\"\n","tid":"abc","rows":3,"tags":["a","b"]}`,
+		},
+		{
+			`%message{as=message} %level{lower,as=severity} %x{tid:[$0],as=trace_id} %logger %line %fields %date{as=time}`,
+			`{"message":"slow \"query\"\n","severity":"warn","trace_id":"abc","logger":"app.db","line":42,"rows":3,"tags":["a","b"],"time":1720297938.335}`,
+		},
+	}
+	for _, tt := range tests {
+		pattern, err := Compile(tt.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg := zap.NewProductionEncoderConfig()
+		enc := pattern.NewJSONEncoder(cfg)
+		buf, err := enc.EncodeEntry(ent, fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := buf.String()
+		buf.Free()
+		if got != tt.want+"\n" {
+			t.Errorf("%s:\ngot  %s\nwant %s", tt.format, got, tt.want)
+		}
+		if !json.Valid([]byte(got)) {
+			t.Errorf("%s: invalid JSON %s", tt.format, got)
+		}
+	}
+}
+
+func TestJSONLayoutWith(t *testing.T) {
+	enc, err := NewJSONLayoutEncoder(zap.NewProductionEncoderConfig(), `%level %message %fields`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zap.String("service", "shop").AddTo(enc)
+	with := enc.Clone()
+	with.OpenNamespace("req")
+
+	ent := zapcore.Entry{Level: zapcore.ErrorLevel, Message: "failed", Stack: "main.main\n\tmain.go:3"}
+	buf, err := with.EncodeEntry(ent, []zapcore.Field{zap.Int("id", 7)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer buf.Free()
+	want := `{"level":"error","msg":"failed","service":"shop","req":{"id":7},"stacktrace":"main.main\n\tmain.go:3"}` + "\n"
+	if buf.String() != want {
+		t.Errorf("got  %s\nwant %s", buf.String(), want)
+	}
+}
+
+func TestPatternStringKeepsKey(t *testing.T) {
+	pattern, err := Compile(`%date{%H:%M,as=time} %level{as=severity}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := pattern.String(), `%date{%H:%M,as=time} %level{as=severity}`; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if elements := pattern.Actions(); elements[0].Config != "%H:%M" || elements[0].Key != "time" {
+		t.Errorf("got %+v", elements[0])
+	}
+}
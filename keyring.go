@@ -0,0 +1,254 @@
+package zaplogback
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// _envelope_prefix starts the ciphertext envelopes written by the encrypt
+// strategy: enc:v1:<key id>:<base64url of the nonce and the ciphertext>.
+const _envelope_prefix = "enc:v1:"
+
+var (
+	_key_id_regex_pattern   = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+	_envelope_regex_pattern = regexp.MustCompile(`enc:v1:[A-Za-z0-9_.-]+:[A-Za-z0-9_-]+`)
+)
+
+// ErrUnknownKeyID is returned when decrypting an envelope whose key is not
+// in the keyring.
+var ErrUnknownKeyID = errors.New("unknown key id")
+
+// A Keyring holds the AES keys of the encrypt strategy by key ID. The last
+// key added encrypts, all of them decrypt, so keys can be rotated by
+// appending a new one.
+type Keyring struct {
+	keys    map[string]cipher.AEAD
+	current string
+}
+
+// NewKeyring returns a keyring with one AES-128, AES-192 or AES-256 key.
+func NewKeyring(id string, key []byte) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]cipher.AEAD)}
+	if err := k.Add(id, key); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Add adds a key, which encrypts from now on.
+func (k *Keyring) Add(id string, key []byte) error {
+	if !_key_id_regex_pattern.MatchString(id) {
+		return fmt.Errorf("invalid key id %q", id)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return fmt.Errorf("key %q: %w", id, err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return fmt.Errorf("key %q: %w", id, err)
+	}
+	if k.keys == nil {
+		k.keys = make(map[string]cipher.AEAD)
+	}
+	k.keys[id] = aead
+	k.current = id
+	return nil
+}
+
+// LoadKeyring reads a key file, see ParseKeyring.
+func LoadKeyring(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	k, err := ParseKeyring(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return k, nil
+}
+
+// ParseKeyring parses a key file: one `<key id> <base64 key>` per line,
+// blank lines and lines starting with # are ignored. The last key encrypts.
+//
+//	# openssl rand -base64 32
+//	2024-01 3q2+7wXcVTuy2m0oK3GfQH0Zrj9yXxXgJ1v1R2p3yXQ=
+func ParseKeyring(data []byte) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]cipher.AEAD)}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line_number := 1; scanner.Scan(); line_number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.Fields(line)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("line %d: want `<key id> <base64 key>`", line_number)
+		}
+		key, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line_number, err)
+		}
+		if err := k.Add(parts[0], key); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line_number, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if k.current == "" {
+		return nil, errors.New("no keys")
+	}
+	return k, nil
+}
+
+// Encrypt seals plaintext with the current key into an envelope.
+func (k *Keyring) Encrypt(plaintext string) (string, error) {
+	aead := k.keys[k.current]
+	sealed := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(sealed); err != nil {
+		return "", err
+	}
+	sealed = aead.Seal(sealed, sealed, []byte(plaintext), nil)
+	return _envelope_prefix + k.current + ":" + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens an envelope written by Encrypt.
+func (k *Keyring) Decrypt(envelope string) (string, error) {
+	rest, ok := strings.CutPrefix(envelope, _envelope_prefix)
+	id, data, found := strings.Cut(rest, ":")
+	if !ok || !found {
+		return "", fmt.Errorf("not an envelope: %q", envelope)
+	}
+	aead, ok := k.keys[id]
+	if !ok {
+		return "", fmt.Errorf("%w %q", ErrUnknownKeyID, id)
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(data)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("malformed envelope: %q", envelope)
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("key %q: %w", id, err)
+	}
+	return string(plaintext), nil
+}
+
+// DecryptText replaces the envelopes found in text with their plaintext,
+// transformed by escape, e.g. to keep a JSON string valid. The envelopes
+// that can not be decrypted are kept, the first error is returned.
+func (k *Keyring) DecryptText(text string, escape func(string) string) (string, error) {
+	var first error
+	decrypted := _envelope_regex_pattern.ReplaceAllStringFunc(text, func(envelope string) string {
+		plaintext, err := k.Decrypt(envelope)
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			return envelope
+		}
+		if escape != nil {
+			return escape(plaintext)
+		}
+		return plaintext
+	})
+	return decrypted, first
+}
+
+// IsEnvelope reports whether s is a ciphertext envelope.
+func IsEnvelope(s string) bool {
+	m := _envelope_regex_pattern.FindStringIndex(s)
+	return m != nil && m[0] == 0 && m[1] == len(s)
+}
+
+var (
+	_keyring_mutex   sync.RWMutex
+	_default_keyring *Keyring
+
+	// errNoKeyring is the error of the encrypt strategy without a keyring,
+	// of a MaskRule or of %fields{encrypt=...}.
+	errNoKeyring = errors.New("the encrypt strategy needs a keyring or a key file")
+)
+
+// SetDefaultKeyring sets the keyring of %fields{encrypt=...} for the
+// encoders built afterwards. It must be set before them: without a keyring
+// BuildZaplogbackEncoder, NewJSONLayoutEncoder, NewAtomicPattern and
+// Config.Build return an error, NewZaplogbackEncoder panics.
+func SetDefaultKeyring(k *Keyring) {
+	_keyring_mutex.Lock()
+	defer _keyring_mutex.Unlock()
+	_default_keyring = k
+}
+
+// DefaultKeyring returns the keyring set by SetDefaultKeyring.
+func DefaultKeyring() *Keyring {
+	_keyring_mutex.RLock()
+	defer _keyring_mutex.RUnlock()
+	return _default_keyring
+}
+
+// encrypterOf returns a Masker encrypting the values of keys with keyring,
+// nil when keys is empty. The keyring is checked by checkKeyring first; if
+// it was removed since, the values are written as *** rather than in clear.
+func encrypterOf(keys []string, keyring *Keyring) *Masker {
+	if len(keys) == 0 {
+		return nil
+	}
+	rule := &maskRule{strategy: MaskEncrypt, keyring: keyring}
+	for _, key := range keys {
+		rule.keys = append(rule.keys, strings.ToLower(key))
+	}
+	return &Masker{key_rules: []*maskRule{rule}}
+}
+
+// checkKeyring returns an error when the pattern has %fields{encrypt=...}
+// and there is no default keyring, like a MaskRule of the encrypt strategy.
+func (p *Pattern) checkKeyring() error {
+	if keys := p.logback_config.encrypt_fields; len(keys) > 0 && DefaultKeyring() == nil {
+		return fmt.Errorf("%%fields{encrypt=%s}: %w, see SetDefaultKeyring", strings.Join(keys, ","), errNoKeyring)
+	}
+	return nil
+}
+
+// Decrypt replaces the field values that are envelopes with their
+// plaintext, quoted when the envelope was. The fields that can not be
+// decrypted are kept, the first error is returned.
+func (r *Record) Decrypt(k *Keyring) error {
+	var first error
+	for i, f := range r.Fields {
+		envelope := f.Value
+		quoted := len(envelope) >= 2 && envelope[0] == '"' && envelope[len(envelope)-1] == '"'
+		if quoted {
+			envelope = envelope[1 : len(envelope)-1]
+		}
+		if !IsEnvelope(envelope) {
+			continue
+		}
+		plaintext, err := k.Decrypt(envelope)
+		if err != nil {
+			if first == nil {
+				first = fmt.Errorf("field %q: %w", f.Key, err)
+			}
+			continue
+		}
+		if quoted {
+			b, _ := json.Marshal(plaintext)
+			plaintext = string(b)
+		}
+		r.Fields[i].Value = plaintext
+	}
+	return first
+}
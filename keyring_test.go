@@ -0,0 +1,239 @@
+package zaplogback
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func keyringForTest(t *testing.T) *Keyring {
+	t.Helper()
+	k, err := ParseKeyring([]byte("# old key\n2023-12 " + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 16)) +
+		"\n\n2024-01 " + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32)) + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestKeyring(t *testing.T) {
+	k := keyringForTest(t)
+	envelope, err := k.Encrypt("C-10086")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(envelope, "enc:v1:2024-01:") || !IsEnvelope(envelope) {
+		t.Fatalf("envelope %q", envelope)
+	}
+	if again, _ := k.Encrypt("C-10086"); again == envelope {
+		t.Error("the nonce is reused")
+	}
+	if got, err := k.Decrypt(envelope); err != nil || got != "C-10086" {
+		t.Errorf("Decrypt = %q, %v", got, err)
+	}
+
+	old, err := NewKeyring("2023-12", bytes.Repeat([]byte{1}, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, _ := old.Encrypt("x")
+	if got, err := k.Decrypt(rotated); err != nil || got != "x" {
+		t.Errorf("Decrypt with a rotated key = %q, %v", got, err)
+	}
+	if _, err := old.Decrypt(envelope); !errors.Is(err, ErrUnknownKeyID) {
+		t.Errorf("Decrypt with an unknown key: %v", err)
+	}
+	tampered := envelope[:len(envelope)-2] + "AA"
+	if _, err := k.Decrypt(tampered); err == nil {
+		t.Error("Decrypt of a tampered envelope: expected an error")
+	}
+
+	text, err := k.DecryptText(`{"id":"`+envelope+`","q":"`+mustEncrypt(t, k, `a"b`)+`"}`, func(s string) string {
+		return strings.ReplaceAll(s, `"`, `\"`)
+	})
+	if want := `{"id":"C-10086","q":"a\"b"}`; err != nil || text != want {
+		t.Errorf("DecryptText = %s, %v, want %s", text, err, want)
+	}
+
+	for _, data := range []string{"", "# none\n", "k1\n", "k1 !!!\n", "k1 " + base64.StdEncoding.EncodeToString([]byte("short")) + "\n", "k/1 " + base64.StdEncoding.EncodeToString(make([]byte, 16))} {
+		if _, err := ParseKeyring([]byte(data)); err == nil {
+			t.Errorf("ParseKeyring(%q): expected an error", data)
+		}
+	}
+}
+
+func mustEncrypt(t *testing.T, k *Keyring, plaintext string) string {
+	t.Helper()
+	envelope, err := k.Encrypt(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return envelope
+}
+
+var _envelope_test_regex = regexp.MustCompile(`enc:v1:2024-01:[A-Za-z0-9_-]+`)
+
+func TestFieldsEncrypt(t *testing.T) {
+	k := keyringForTest(t)
+	SetDefaultKeyring(k)
+	defer SetDefaultKeyring(nil)
+
+	cfg := zap.NewProductionEncoderConfig()
+	cfg.SkipLineEnding = true
+	enc, err := BuildZaplogbackEncoder(cfg, `%message %x{card_ref} %fields{encrypt=customer_id,card_ref}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	with := enc.Clone()
+	zap.String("customer_id", "C-10086").AddTo(with)
+	buf, err := with.Clone().EncodeEntry(zapcore.Entry{Message: "paid"}, []zapcore.Field{zap.Int("card_ref", 42), zap.String("order", "o1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := buf.String()
+	if got, want := _envelope_test_regex.ReplaceAllString(line, "ENC"), `paid ENC {"order":o1} "customer_id":ENC`; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	for i, envelope := range _envelope_test_regex.FindAllString(line, -1) {
+		plaintext, err := k.Decrypt(envelope)
+		if want := []string{"42", "C-10086"}[i]; err != nil || plaintext != want {
+			t.Errorf("envelope %d = %q, %v, want %q", i, plaintext, err, want)
+		}
+	}
+
+	layout, err := NewJSONLayoutEncoder(cfg, `%message %fields{encrypt=customer_id}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err = layout.EncodeEntry(zapcore.Entry{Message: "paid"}, []zapcore.Field{zap.String("customer_id", "C-10086")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := _envelope_test_regex.ReplaceAllString(buf.String(), "ENC"), `{"msg":"paid","customer_id":"ENC"}`; got != want {
+		t.Errorf("JSON layout: got %s, want %s", got, want)
+	}
+
+	// 没有密钥时报错, 而不是把值写成 ***
+	SetDefaultKeyring(nil)
+	const log_format = `%message %fields{encrypt=customer_id}`
+	if _, err := BuildZaplogbackEncoder(cfg, log_format); !errors.Is(err, errNoKeyring) {
+		t.Errorf("NewZaplogbackEncoder without a keyring: %v", err)
+	}
+	if _, err := NewJSONLayoutEncoder(cfg, log_format); !errors.Is(err, errNoKeyring) {
+		t.Errorf("NewJSONLayoutEncoder without a keyring: %v", err)
+	}
+	if _, err := NewAtomicPattern(log_format); !errors.Is(err, errNoKeyring) {
+		t.Errorf("NewAtomicPattern without a keyring: %v", err)
+	}
+	config := Config{Config: zap.NewProductionConfig(), Pattern: log_format}
+	config.Encoding = _default_encoding_name
+	config.OutputPaths = []string{filepath.Join(t.TempDir(), "app.log")}
+	if _, err := config.Build(); !errors.Is(err, errNoKeyring) {
+		t.Errorf("Config.Build without a keyring: %v", err)
+	}
+	// 解析日志不需要密钥
+	if _, err := NewParser(log_format, cfg); err != nil {
+		t.Errorf("NewParser: %v", err)
+	}
+
+	for _, format := range []string{`%fields{encrypt=}`, `%fields{encrypt=a,,b}`, `%fields{encrypt=[}`} {
+		if _, err := Compile(format); err == nil {
+			t.Errorf("%s: expected an error", format)
+		}
+	}
+}
+
+func TestFieldsEncryptAtomicPattern(t *testing.T) {
+	k := keyringForTest(t)
+	SetDefaultKeyring(k)
+	defer SetDefaultKeyring(nil)
+
+	ap, err := NewAtomicPattern(`%message %fields{encrypt=customer_id}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := zap.NewProductionEncoderConfig()
+	cfg.SkipLineEnding = true
+	var out bytes.Buffer
+	logger := zap.New(zapcore.NewCore(ap.NewEncoder(cfg), zapcore.AddSync(&out), zapcore.DebugLevel))
+	logger.With(zap.String("customer_id", "C-12345")).Info("hi", zap.String("customer_id", "C-67890"))
+
+	line := out.String()
+	if got, want := _envelope_test_regex.ReplaceAllString(line, "ENC"), `hi {"customer_id":ENC} "customer_id":ENC`; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	for i, envelope := range _envelope_test_regex.FindAllString(line, -1) {
+		// 只加密一次
+		plaintext, err := k.Decrypt(envelope)
+		if want := []string{"C-67890", "C-12345"}[i]; err != nil || plaintext != want {
+			t.Errorf("envelope %d = %q, %v, want %q", i, plaintext, err, want)
+		}
+	}
+}
+
+func TestRecordDecrypt(t *testing.T) {
+	k := keyringForTest(t)
+	m, err := NewMasker(MaskRule{Keys: []string{"customer_id"}, Strategy: MaskEncrypt, Keyring: k})
+	if err != nil {
+		t.Fatal(err)
+	}
+	log_format := `%level %message %fields`
+	cfg := zap.NewProductionEncoderConfig()
+	cfg.SkipLineEnding = true
+	text, err := BuildZaplogbackEncoder(cfg, log_format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := m.Wrap(text).EncodeEntry(zapcore.Entry{Message: "paid"}, []zapcore.Field{zap.String("customer_id", "C-10086"), zap.Int("n", 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewParser(log_format, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, ok := p.ParseRecord(buf.String())
+	if !ok {
+		t.Fatalf("ParseRecord(%q) failed", buf.String())
+	}
+	if value, _ := r.Field("customer_id"); !IsEnvelope(value) {
+		t.Fatalf("customer_id = %q", value)
+	}
+	if err := r.Decrypt(k); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := r.Field("customer_id"); got != "C-10086" {
+		t.Errorf("customer_id = %q", got)
+	}
+
+	r.Fields = []RecordField{{Key: "q", Value: `"` + mustEncrypt(t, k, `a"b`) + `"`}}
+	if err := r.Decrypt(k); err != nil || r.Fields[0].Value != `"a\"b"` {
+		t.Errorf("quoted: %q, %v", r.Fields[0].Value, err)
+	}
+}
+
+func TestMaskRuleKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log.keys")
+	if err := os.WriteFile(path, []byte("k1 "+base64.StdEncoding.EncodeToString(make([]byte, 32))+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewMasker(MaskRule{Keys: []string{"ssn"}, Strategy: MaskEncrypt, KeyFile: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.keyRule("SSN").mask("123"); !strings.HasPrefix(got, "enc:v1:k1:") {
+		t.Errorf("mask = %q", got)
+	}
+	if _, err := NewMasker(MaskRule{Keys: []string{"ssn"}, Strategy: MaskEncrypt}); err == nil {
+		t.Error("expected an error without a keyring")
+	}
+}
@@ -0,0 +1,495 @@
+package zaplogback
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+// _truncated_key is the member added by the JSON layout when it drops
+// members to keep a line under the limit.
+const _truncated_key = "truncated"
+
+// sizeLimits is %limit{line=1MB,message=64KB,field=4KB,array=100}, it
+// writes nothing. What is over a limit is cut on a UTF-8 boundary and
+// followed by a marker such as `…[truncated 4.9MB]`:
+//
+//	line     bytes of a line, without the line ending
+//	message  bytes of the message
+//	field    bytes of a string, binary, error, stringer or reflected field
+//	         value, the values over it are written as strings
+//	array    elements of an array field
+//
+// The JSON layout drops the members at the end of a line over the limit and
+// adds "truncated", so the line stays valid JSON; other lines are cut.
+type sizeLimits struct {
+	logActionOperation
+	line    int
+	message int
+	field   int
+	array   int
+}
+
+func newLimitAction(config string) (Action, error) {
+	l := &sizeLimits{logActionOperation: logAddNothingAction}
+	if config == "" {
+		return nil, fmt.Errorf("%%limit needs limits, e.g. %%limit{line=1MB,field=4KB}")
+	}
+	for _, item := range strings.Split(config, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(item), "=")
+		var err error
+		switch name {
+		case "line":
+			l.line, err = parseSize(value)
+		case "message", "msg":
+			l.message, err = parseSize(value)
+		case "field":
+			l.field, err = parseSize(value)
+		case "array":
+			l.array, err = strconv.Atoi(value)
+			if err == nil && l.array <= 0 {
+				err = fmt.Errorf("array limit must be positive, got %d", l.array)
+			}
+		default:
+			return nil, fmt.Errorf("unknown limit %q, want line, message, field or array", name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("limit %s: %w", name, err)
+		}
+	}
+	return l, nil
+}
+
+// limitsOf returns the limits of the %limit element of a compiled pattern,
+// nil when there is none.
+func limitsOf(elements []PatternAction) (*sizeLimits, error) {
+	var limits *sizeLimits
+	for _, element := range elements {
+		l, ok := element.Action.(*sizeLimits)
+		if !ok {
+			continue
+		}
+		if limits != nil {
+			return nil, &PatternError{Offset: element.Offset, Action: "%limit", Err: fmt.Errorf("%%limit is used more than once")}
+		}
+		limits = l
+	}
+	return limits, nil
+}
+
+// parseSize parses 512, 512B, 64KB, 1MB or 1GB, with 1KB = 1024 bytes.
+func parseSize(text string) (int, error) {
+	upper := strings.ToUpper(strings.TrimSpace(text))
+	unit := 1
+	for _, suffix := range []struct {
+		name string
+		unit int
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(upper, suffix.name) {
+			upper = strings.TrimSpace(strings.TrimSuffix(upper, suffix.name))
+			unit = suffix.unit
+			break
+		}
+	}
+	n, err := strconv.Atoi(upper)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", text)
+	}
+	return n * unit, nil
+}
+
+// formatSize formats a number of bytes like 512B, 64.0KB or 4.9MB.
+func formatSize(n int) string {
+	switch {
+	case n < 1<<10:
+		return strconv.Itoa(n) + "B"
+	case n < 1<<20:
+		return strconv.FormatFloat(float64(n)/(1<<10), 'f', 1, 64) + "KB"
+	case n < 1<<30:
+		return strconv.FormatFloat(float64(n)/(1<<20), 'f', 1, 64) + "MB"
+	}
+	return strconv.FormatFloat(float64(n)/(1<<30), 'f', 1, 64) + "GB"
+}
+
+func truncatedMarker(n int) string {
+	return "…[truncated " + formatSize(n) + "]"
+}
+
+// runeBoundary returns the largest index <= n that does not split a rune of
+// b, n is at most len(b).
+func runeBoundary[S []byte | string](b S, n int) int {
+	// 一个 rune 最多 4 个字节
+	for i := n; i > 0 && i > n-utf8.UTFMax; i-- {
+		if i == len(b) || utf8.RuneStart(b[i]) {
+			return i
+		}
+	}
+	return n
+}
+
+func truncateString(s string, limit int) string {
+	if limit <= 0 || len(s) <= limit {
+		return s
+	}
+	n := runeBoundary(s, limit)
+	return s[:n] + truncatedMarker(len(s)-n)
+}
+
+func (l *sizeLimits) limitMessage(msg string) string {
+	if l == nil {
+		return msg
+	}
+	return truncateString(msg, l.message)
+}
+
+func (l *sizeLimits) limitString(s string) string {
+	if l == nil {
+		return s
+	}
+	return truncateString(s, l.field)
+}
+
+func (l *sizeLimits) limitBytes(b []byte) []byte {
+	if l == nil || l.field <= 0 || len(b) <= l.field {
+		return b
+	}
+	n := runeBoundary(b, l.field)
+	return append(b[:n:n], truncatedMarker(len(b)-n)...)
+}
+
+// limitBinary returns b in the binary= encoding of format, truncated, when
+// b is over the limit.
+func (l *sizeLimits) limitBinary(b []byte, format *valueFormat) (string, bool) {
+	if l == nil || l.field <= 0 || len(b) <= l.field {
+		return "", false
+	}
+	return format.binaryText(b[:l.field]) + truncatedMarker(len(b)-l.field), true
+}
+
+// limitReflected returns the JSON of obj truncated, ok is false when it is
+// not over the limit.
+func (l *sizeLimits) limitReflected(obj interface{}) (string, bool) {
+	if l == nil || l.field <= 0 || obj == nil {
+		return "", false
+	}
+	text, err := json.Marshal(obj)
+	if err != nil || len(text) <= l.field {
+		return "", false
+	}
+	return truncateString(string(text), l.field), true
+}
+
+func (l *sizeLimits) limitArray(arr zapcore.ArrayMarshaler) zapcore.ArrayMarshaler {
+	if l == nil || l.array <= 0 {
+		return arr
+	}
+	if _, ok := arr.(limitedArray); ok {
+		return arr
+	}
+	return limitedArray{ArrayMarshaler: arr, limit: l.array}
+}
+
+// limitField returns f, or a field with its value truncated.
+func (l *sizeLimits) limitField(f zapcore.Field, format *valueFormat) zapcore.Field {
+	if f.Type == zapcore.ArrayMarshalerType {
+		f.Interface = l.limitArray(f.Interface.(zapcore.ArrayMarshaler))
+		return f
+	}
+	if l.field <= 0 {
+		return f
+	}
+	switch f.Type {
+	case zapcore.StringType:
+		f.String = l.limitString(f.String)
+	case zapcore.ByteStringType:
+		f.Interface = l.limitBytes(f.Interface.([]byte))
+	case zapcore.BinaryType:
+		if text, ok := l.limitBinary(f.Interface.([]byte), format); ok {
+			return zap.String(f.Key, text)
+		}
+	case zapcore.ReflectType:
+		if text, ok := l.limitReflected(f.Interface); ok {
+			return zap.String(f.Key, text)
+		}
+	case zapcore.ErrorType:
+		if err, ok := f.Interface.(error); ok && err != nil && len(err.Error()) > l.field {
+			return zap.String(f.Key, l.limitString(err.Error()))
+		}
+	case zapcore.StringerType:
+		if text, ok := stringOf(f.Interface.(fmt.Stringer)); ok && len(text) > l.field {
+			return zap.String(f.Key, l.limitString(text))
+		}
+	}
+	return f
+}
+
+// stringOf calls String, ok is false when it panics; zap reports the panic
+// when it encodes the field.
+func stringOf(stringer fmt.Stringer) (text string, ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	return stringer.String(), true
+}
+
+// limitFields returns fields, or a copy with the values over the limits
+// truncated, the binary values in the binary= encoding of format.
+func (l *sizeLimits) limitFields(fields []zapcore.Field, format *valueFormat) []zapcore.Field {
+	if l == nil || (l.field <= 0 && l.array <= 0) {
+		return fields
+	}
+	limited := make([]zapcore.Field, len(fields))
+	for i, f := range fields {
+		limited[i] = l.limitField(f, format)
+	}
+	return limited
+}
+
+// limitLine cuts buf[start:] at the line limit.
+func (l *sizeLimits) limitLine(buf *buffer.Buffer, start int) {
+	if l == nil || l.line <= 0 || buf.Len()-start <= l.line {
+		return
+	}
+	line := buf.Bytes()
+	n := start + runeBoundary(line[start:], l.line)
+	marker := truncatedMarker(len(line) - n)
+	truncateBuffer(buf, n)
+	buf.AppendString(marker)
+}
+
+// jsonObjectSpan is where %json wrote its object in a text line: the
+// offsets of its parts and of its closing brace, 0 without %json.
+type jsonObjectSpan struct {
+	starts []int
+	end    int
+}
+
+// limitHybridLine is limitLine for a line with the object of %json, which
+// is kept valid: when the cut falls inside it, the parts over the limit are
+// dropped like limitJSONLine does, with the rest of the line.
+func (l *sizeLimits) limitHybridLine(buf *buffer.Buffer, object jsonObjectSpan) {
+	if l == nil || l.line <= 0 || buf.Len() <= l.line {
+		return
+	}
+	// 在对象之前或之后截断时 JSON 不受影响
+	if len(object.starts) == 0 || l.line < object.starts[0] || l.line > object.end {
+		l.limitLine(buf, 0)
+		return
+	}
+	cut := object.starts[0]
+	for _, start := range object.starts {
+		if start <= l.line {
+			cut = start
+		}
+	}
+	marker := truncatedMarker(buf.Len() - cut)
+	truncateBuffer(buf, cut)
+	appendJSONKey(buf, _truncated_key)
+	appendJSONString(buf, marker)
+	buf.AppendByte('}')
+}
+
+// limitJSONLine drops the members of a JSON object over the line limit,
+// starts are the offsets of the members in line, and adds "truncated" with
+// the marker. The closing brace is not written yet.
+func (l *sizeLimits) limitJSONLine(line *buffer.Buffer, starts []int) {
+	if l == nil || l.line <= 0 || line.Len() <= l.line || len(starts) == 0 {
+		return
+	}
+	cut := starts[0]
+	for _, start := range starts {
+		if start <= l.line {
+			cut = start
+		}
+	}
+	marker := truncatedMarker(line.Len() - cut)
+	truncateBuffer(line, cut)
+	appendJSONKey(line, _truncated_key)
+	appendJSONString(line, marker)
+}
+
+// truncateBuffer keeps the first n bytes of buf.
+func truncateBuffer(buf *buffer.Buffer, n int) {
+	kept := buf.Bytes()[:n]
+	buf.Reset()
+	// kept 与 buf 共用底层数组, Write 不会扩容
+	buf.Write(kept)
+}
+
+// limitedArray writes the first limit elements of an array, then a marker
+// such as "…[truncated 90 elements]".
+type limitedArray struct {
+	zapcore.ArrayMarshaler
+	limit int
+}
+
+func (arr limitedArray) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	counter := &countingArrayEncoder{ArrayEncoder: enc, limit: arr.limit}
+	err := arr.ArrayMarshaler.MarshalLogArray(counter)
+	if dropped := counter.count - arr.limit; dropped > 0 {
+		enc.AppendString(fmt.Sprintf("…[truncated %d elements]", dropped))
+	}
+	return err
+}
+
+// countingArrayEncoder drops the elements after the limit.
+type countingArrayEncoder struct {
+	zapcore.ArrayEncoder
+	limit int
+	count int
+}
+
+func (enc *countingArrayEncoder) keep() bool {
+	enc.count++
+	return enc.count <= enc.limit
+}
+
+func (enc *countingArrayEncoder) AppendBool(v bool) {
+	if enc.keep() {
+		enc.ArrayEncoder.AppendBool(v)
+	}
+}
+
+func (enc *countingArrayEncoder) AppendByteString(v []byte) {
+	if enc.keep() {
+		enc.ArrayEncoder.AppendByteString(v)
+	}
+}
+
+func (enc *countingArrayEncoder) AppendComplex128(v complex128) {
+	if enc.keep() {
+		enc.ArrayEncoder.AppendComplex128(v)
+	}
+}
+
+func (enc *countingArrayEncoder) AppendComplex64(v complex64) {
+	if enc.keep() {
+		enc.ArrayEncoder.AppendComplex64(v)
+	}
+}
+
+func (enc *countingArrayEncoder) AppendFloat64(v float64) {
+	if enc.keep() {
+		enc.ArrayEncoder.AppendFloat64(v)
+	}
+}
+
+func (enc *countingArrayEncoder) AppendFloat32(v float32) {
+	if enc.keep() {
+		enc.ArrayEncoder.AppendFloat32(v)
+	}
+}
+
+func (enc *countingArrayEncoder) AppendInt(v int) {
+	if enc.keep() {
+		enc.ArrayEncoder.AppendInt(v)
+	}
+}
+
+func (enc *countingArrayEncoder) AppendInt64(v int64) {
+	if enc.keep() {
+		enc.ArrayEncoder.AppendInt64(v)
+	}
+}
+
+func (enc *countingArrayEncoder) AppendInt32(v int32) {
+	if enc.keep() {
+		enc.ArrayEncoder.AppendInt32(v)
+	}
+}
+
+func (enc *countingArrayEncoder) AppendInt16(v int16) {
+	if enc.keep() {
+		enc.ArrayEncoder.AppendInt16(v)
+	}
+}
+
+func (enc *countingArrayEncoder) AppendInt8(v int8) {
+	if enc.keep() {
+		enc.ArrayEncoder.AppendInt8(v)
+	}
+}
+
+func (enc *countingArrayEncoder) AppendString(v string) {
+	if enc.keep() {
+		enc.ArrayEncoder.AppendString(v)
+	}
+}
+
+func (enc *countingArrayEncoder) AppendUint(v uint) {
+	if enc.keep() {
+		enc.ArrayEncoder.AppendUint(v)
+	}
+}
+
+func (enc *countingArrayEncoder) AppendUint64(v uint64) {
+	if enc.keep() {
+		enc.ArrayEncoder.AppendUint64(v)
+	}
+}
+
+func (enc *countingArrayEncoder) AppendUint32(v uint32) {
+	if enc.keep() {
+		enc.ArrayEncoder.AppendUint32(v)
+	}
+}
+
+func (enc *countingArrayEncoder) AppendUint16(v uint16) {
+	if enc.keep() {
+		enc.ArrayEncoder.AppendUint16(v)
+	}
+}
+
+func (enc *countingArrayEncoder) AppendUint8(v uint8) {
+	if enc.keep() {
+		enc.ArrayEncoder.AppendUint8(v)
+	}
+}
+
+func (enc *countingArrayEncoder) AppendUintptr(v uintptr) {
+	if enc.keep() {
+		enc.ArrayEncoder.AppendUintptr(v)
+	}
+}
+
+func (enc *countingArrayEncoder) AppendDuration(v time.Duration) {
+	if enc.keep() {
+		enc.ArrayEncoder.AppendDuration(v)
+	}
+}
+
+func (enc *countingArrayEncoder) AppendTime(v time.Time) {
+	if enc.keep() {
+		enc.ArrayEncoder.AppendTime(v)
+	}
+}
+
+func (enc *countingArrayEncoder) AppendArray(v zapcore.ArrayMarshaler) error {
+	if enc.keep() {
+		return enc.ArrayEncoder.AppendArray(v)
+	}
+	return nil
+}
+
+func (enc *countingArrayEncoder) AppendObject(v zapcore.ObjectMarshaler) error {
+	if enc.keep() {
+		return enc.ArrayEncoder.AppendObject(v)
+	}
+	return nil
+}
+
+func (enc *countingArrayEncoder) AppendReflected(v interface{}) error {
+	if enc.keep() {
+		return enc.ArrayEncoder.AppendReflected(v)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package zaplogback
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestLimits(t *testing.T) {
+	ent := zapcore.Entry{Level: zapcore.InfoLevel, Message: "价格是一二三"}
+	dump := strings.Repeat("x", 5<<20)
+	fields := []zapcore.Field{
+		zap.String("sql", dump),
+		zap.Ints("ids", []int{1, 2, 3, 4, 5}),
+		zap.Reflect("row", map[string]string{"name": "abcdefghij"}),
+		zap.Error(errors.New("0123456789abc")),
+		zap.Binary("raw", []byte("0123456789")),
+		zap.String("ok", "short"),
+	}
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{`%limit{message=10} %message`,
+			" 价格是…[truncated 9B]"},
+		{`%limit{field=8,array=2}%fields`,
+			`{"sql":xxxxxxxx…[truncated 5.0MB],"ids":[1 2…[truncated 3 elements]],"row":{\"name\":…[truncated 13B],` +
+				`"error":01234567…[truncated 5B],"raw":MDEyMzQ1Njc=…[truncated 2B],"ok":short}`},
+		{`%limit{line=12} %level %message`,
+			" info 价格…[truncated 12B]"},
+	}
+	for _, tt := range tests {
+		if got := encodeForTest(t, tt.format, ent, fields...); got != tt.want {
+			t.Errorf("%s:\ngot  %s\nwant %s", tt.format, got, tt.want)
+		}
+	}
+
+	for _, format := range []string{`%limit`, `%limit{line=0}`, `%limit{line=1XB}`, `%limit{depth=3}`, `%limit{array=-1}`, `%limit{line=1MB} %limit{field=1KB}`} {
+		if _, err := Compile(format); err == nil {
+			t.Errorf("%s: expected an error", format)
+		}
+	}
+}
+
+func TestLimitsHybridJSON(t *testing.T) {
+	tests := []struct {
+		format string
+		msg    string
+		want   string
+	}{
+		{`%limit{line=40} %level %json{msg,fields}`, strings.Repeat("m", 60), ` info {"truncated":"…[truncated 128B]"}`},
+		{`%limit{line=40} %level %json{msg,fields}`, "hi", ` info {"msg":"hi","truncated":"…[truncated 60B]"}`},
+		{`%limit{line=40} %json{msg} %message`, "hi", ` {"msg":"hi"} hi`},
+		{`%limit{line=40} %message %json{msg}`, strings.Repeat("m", 50), " " + strings.Repeat("m", 39) + `…[truncated 72B]`},
+	}
+	for _, tt := range tests {
+		got := encodeForTest(t, tt.format, zapcore.Entry{Level: zapcore.InfoLevel, Message: tt.msg}, zap.String("sql", strings.Repeat("x", 50)))
+		if got != tt.want {
+			t.Errorf("%s %q:\ngot  %s\nwant %s", tt.format, tt.msg, got, tt.want)
+		}
+		if object := got[strings.Index(got, "{")+1:]; strings.HasPrefix(tt.format, "%limit{line=40} %level") && !json.Valid([]byte("{"+object)) {
+			t.Errorf("%s: invalid JSON %s", tt.format, object)
+		}
+	}
+}
+
+func TestLimitsWith(t *testing.T) {
+	cfg := zap.NewProductionEncoderConfig()
+	cfg.SkipLineEnding = true
+	enc, err := BuildZaplogbackEncoder(cfg, `%limit{field=4}%message %fields`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	with := enc.Clone()
+	zap.String("sql", "select 1").AddTo(with)
+	buf, err := with.EncodeEntry(zapcore.Entry{Message: "hi"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), `hi {} "sql":sele…[truncated 4B]`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestLimitsJSONLayout(t *testing.T) {
+	cfg := zap.NewProductionEncoderConfig()
+	cfg.TimeKey = ""
+	cfg.SkipLineEnding = true
+	enc, err := NewJSONLayoutEncoder(cfg, `%limit{line=64,field=16,array=1} %level %message %x{tid} %fields`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zap.String("service", strings.Repeat("s", 100)).AddTo(enc)
+
+	tests := []struct {
+		ent    zapcore.Entry
+		fields []zapcore.Field
+		want   string
+	}{
+		{zapcore.Entry{Message: "hi"}, []zapcore.Field{zap.String("tid", "abc"), zap.Ints("ids", []int{1, 2})},
+			`{"level":"info","msg":"hi","tid":"abc","truncated":"…[truncated 85B]"}`},
+		{zapcore.Entry{Message: strings.Repeat("m", 100)}, nil,
+			`{"level":"info","truncated":"…[truncated 156B]"}`},
+	}
+	for _, tt := range tests {
+		buf, err := enc.EncodeEntry(tt.ent, tt.fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := buf.String()
+		if got != tt.want {
+			t.Errorf("got  %s\nwant %s", got, tt.want)
+		}
+		if !json.Valid([]byte(got)) {
+			t.Errorf("invalid JSON %s", got)
+		}
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	for n, want := range map[int]string{12: "12B", 1536: "1.5KB", 5<<20 - 100<<10: "4.9MB", 3 << 30: "3.0GB"} {
+		if got := formatSize(n); got != want {
+			t.Errorf("formatSize(%d) = %s, want %s", n, got, want)
+		}
+	}
+	for text, want := range map[string]int{"512": 512, "512B": 512, "64KB": 64 << 10, "1mb": 1 << 20, "2G": 2 << 30} {
+		if got, err := parseSize(text); err != nil || got != want {
+			t.Errorf("parseSize(%s) = %d, %v, want %d", text, got, err, want)
+		}
+	}
+}
@@ -1,11 +1,12 @@
 package zaplogback
 
 import (
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"math"
+	"slices"
+	"sync/atomic"
 	"time"
 	"unicode/utf8"
 
@@ -31,8 +32,27 @@ type LogbackConfig struct {
 	EncodeTime   zapcore.TimeEncoder
 	EncodeCaller zapcore.CallerEncoder
 	// 新增format
-	actions     []logActionOperation
+	actions     []Action
 	used_fields map[string]EMPTY
+	// 格式中有 %stacktrace 时不再把 stacktrace 追加到末尾
+	writes_stack bool
+	// 格式中有 %json{fields} 时 With 的字段由 %json 输出
+	writes_json_context bool
+	// %multiline 的换行策略, nil 表示原样输出
+	multiline *multilinePolicy
+	// %limit 的大小限制, nil 表示不限制
+	limits *sizeLimits
+	// %fields{encrypt=...} 中要加密的字段
+	encrypt_fields []string
+	// 格式中有不带 key 的 %error 时错误字段不再由 %fields 输出
+	writes_errors bool
+	// %chain 的密钥和检查点间隔, 见 Pattern.WrapWriter
+	chain *chainAction
+	// %message{template} 用字段替换消息中的 {name}, keep 时字段仍由 %fields 输出
+	render_template      bool
+	keep_template_fields bool
+	// %fields{time=...,duration=...} 的值编码, nil 时使用 EncoderConfig
+	value_format *valueFormat
 }
 
 type logbackEncoder struct {
@@ -45,8 +65,35 @@ type logbackEncoder struct {
 	reflectEnc zapcore.ReflectedEncoder
 
 	// 新增format
-	actions     []logActionOperation
-	used_fields map[string]EMPTY
+	actions      []Action
+	used_fields  map[string]EMPTY
+	writes_stack bool
+	// 错误字段由 %error 输出, 见 isUsedField
+	writes_errors bool
+	// With 的字段的 JSON 形式, 见 jsonContextEncoder
+	json_context zapcore.Encoder
+	multiline    *multilinePolicy
+	limits       *sizeLimits
+	// 加密 %fields{encrypt=...} 的字段, 见 encoder
+	encrypter *Masker
+	// %message 默认的转义方式, 见 SetDefaultMessageEscape
+	message_escape string
+	// 见 renderTemplate
+	render_template      bool
+	keep_template_fields bool
+	// %fields 和 %x 的值编码, 见 valueFormat
+	value_format *valueFormat
+	// 本条日志中 %json 对象的位置, 见 limitHybridLine
+	json_object jsonObjectSpan
+	// 本条日志的消息模板和模板用到的字段的下标
+	template        string
+	template_fields []int
+	// header 模式下续行行首的长度, 见 appendEntryActions
+	multiline_header_len int
+
+	// 动态格式, 见 AtomicPattern
+	atomic_pattern *atomic.Pointer[Pattern]
+	pattern_state  *atomic.Pointer[patternState]
 }
 
 type logActionOperation func(*logbackEncoder, *zapcore.Entry, []zapcore.Field)
@@ -55,12 +102,28 @@ var _logbackPool = pool.New(func() *logbackEncoder {
 	return &logbackEncoder{}
 })
 
+// NewZaplogbackEncoder builds an encoder that writes entries with
+// log_format. It panics if log_format is invalid, BuildZaplogbackEncoder
+// returns the error instead.
 func NewZaplogbackEncoder(cfg zapcore.EncoderConfig, log_format string) zapcore.Encoder {
-	encoder := newZaplogbackEncoder(cfg)
-	encoder.UseLogFormat(log_format)
+	encoder, err := BuildZaplogbackEncoder(cfg, log_format)
+	if err != nil {
+		panic(err)
+	}
 	return encoder
 }
 
+// BuildZaplogbackEncoder builds an encoder that writes entries with
+// log_format. %message without escape= uses DefaultMessageEscape, "safe"
+// unless SetDevelopment or SetDefaultMessageEscape changed it.
+func BuildZaplogbackEncoder(cfg zapcore.EncoderConfig, log_format string) (zapcore.Encoder, error) {
+	encoder := newZaplogbackEncoder(cfg)
+	if err := encoder.UseLogFormat(log_format); err != nil {
+		return nil, err
+	}
+	return encoder.encoder(), nil
+}
+
 func newZaplogbackEncoder(cfg zapcore.EncoderConfig) *logbackEncoder {
 	if cfg.SkipLineEnding {
 		cfg.LineEnding = ""
@@ -74,11 +137,16 @@ func newZaplogbackEncoder(cfg zapcore.EncoderConfig) *logbackEncoder {
 	}
 
 	return &logbackEncoder{
-		EncoderConfig: &cfg,
-		buf:           bufferpool.Get(),
+		EncoderConfig:  &cfg,
+		buf:            bufferpool.Get(),
+		message_escape: DefaultMessageEscape(),
 	}
 }
 
+// RegisterLogbackEncoder registers an encoding of zap.Config that writes
+// entries with logformat, the default format when empty. Like
+// NewZaplogbackEncoder, %message without escape= uses DefaultMessageEscape:
+// zap.Config.Development does not reach the encoder, call SetDevelopment.
 func RegisterLogbackEncoder(encoding string, logformat string) error {
 	if logformat == "" {
 		logformat = _default_log_format
@@ -88,8 +156,17 @@ func RegisterLogbackEncoder(encoding string, logformat string) error {
 		encoding = _default_encoding_name
 	}
 
-	err := zap.RegisterEncoder(encoding, func(encoderConfig zapcore.EncoderConfig) (zapcore.Encoder, error) {
-		return NewZaplogbackEncoder(encoderConfig, logformat), nil
+	// 注册前先校验格式, 避免在 cfg.Build() 时才发现错误
+	pattern, err := Compile(logformat)
+	if err != nil {
+		return err
+	}
+	if err := pattern.checkChain(); err != nil {
+		return err
+	}
+
+	err = zap.RegisterEncoder(encoding, func(encoderConfig zapcore.EncoderConfig) (zapcore.Encoder, error) {
+		return BuildZaplogbackEncoder(encoderConfig, logformat)
 	})
 
 	if err != nil {
@@ -109,27 +186,78 @@ func putlogbackEncoder(enc *logbackEncoder) {
 	enc.reflectEnc = nil
 	enc.actions = nil
 	enc.used_fields = nil
+	enc.writes_stack = false
+	enc.writes_errors = false
+	enc.json_context = nil
+	enc.multiline = nil
+	enc.limits = nil
+	enc.encrypter = nil
+	enc.message_escape = ""
+	enc.render_template = false
+	enc.keep_template_fields = false
+	enc.value_format = nil
+	enc.template = ""
+	enc.template_fields = nil
+	enc.json_object = jsonObjectSpan{}
+	enc.multiline_header_len = 0
+	enc.atomic_pattern = nil
+	enc.pattern_state = nil
 	_logbackPool.Put(enc)
 }
 
 func (enc *logbackEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
 	final := enc.clone()
-
-	for _, action := range enc.actions {
-		action(final, &ent, fields)
+	actions := enc.actions
+	if enc.atomic_pattern != nil {
+		state := enc.currentPatternState()
+		final.EncoderConfig = state.cfg
+		final.used_fields = state.pattern.used_fields
+		final.writes_stack = state.pattern.logback_config.writes_stack
+		final.writes_errors = state.pattern.logback_config.writes_errors
+		final.multiline = state.pattern.logback_config.multiline
+		final.limits = state.pattern.logback_config.limits
+		final.render_template = state.pattern.logback_config.render_template
+		final.keep_template_fields = state.pattern.logback_config.keep_template_fields
+		final.value_format = state.pattern.logback_config.value_format
+		actions = state.pattern.logback_config.actions
+		if !state.pattern.logback_config.writes_json_context {
+			// 当前格式没有 %json{fields}, 上下文按文本输出
+			final.json_context = nil
+		}
+		if state.encrypter != nil {
+			// 动态格式只加密本条日志的字段, With 的上下文不加密
+			encrypted := make([]zapcore.Field, len(fields))
+			for i, f := range fields {
+				encrypted[i] = state.encrypter.maskField(f)
+			}
+			fields = encrypted
+		}
+	}
+	final.renderTemplate(&ent, fields)
+	limits := final.limits
+	if limits != nil {
+		ent.Message = limits.limitMessage(ent.Message)
+		fields = limits.limitFields(fields, final.value_format)
+		// 字段已截断, Add* 不再截断
+		final.limits = nil
 	}
 
-	if enc.buf.Len() > 0 {
+	final.appendEntryActions(actions, &ent, fields)
+
+	if final.json_context != nil {
+		// 上下文已由 %json 输出
+		final.openNamespaces = 0
+	} else if enc.buf.Len() > 0 {
 		final.addElementSeparator()
 		final.buf.Write(enc.buf.Bytes())
 	}
 
 	final.closeOpenNamespaces()
-	if ent.Stack != "" && final.StacktraceKey != "" {
+	if ent.Stack != "" && final.StacktraceKey != "" && !final.writes_stack {
 		// final.AddString(final.StacktraceKey, ent.Stack)
-		final.buf.AppendByte('\n')
-		final.buf.AppendBytes([]byte(ent.Stack))
+		final.appendStack(ent.Stack)
 	}
+	limits.limitHybridLine(final.buf, final.json_object)
 	final.buf.AppendString(final.LineEnding)
 
 	ret := final.buf
@@ -137,22 +265,87 @@ func (enc *logbackEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field
 	return ret, nil
 }
 
-func (enc *logbackEncoder) UseLogFormat(log_format string) {
-	logback_config := Parse_compile_log_format(log_format)
+func (enc *logbackEncoder) UseLogFormat(log_format string) error {
+	pattern, err := Compile(log_format)
+	if err != nil {
+		return err
+	}
+	if err := pattern.checkKeyring(); err != nil {
+		return err
+	}
+	if err := pattern.checkChain(); err != nil {
+		return err
+	}
+	enc.usePattern(pattern)
+	return nil
+}
+
+func (enc *logbackEncoder) usePattern(pattern *Pattern) {
+	enc.actions = pattern.logback_config.actions
+	enc.used_fields = pattern.used_fields
+	enc.writes_stack = pattern.logback_config.writes_stack
+	enc.writes_errors = pattern.logback_config.writes_errors
+	enc.multiline = pattern.logback_config.multiline
+	enc.limits = pattern.logback_config.limits
+	enc.render_template = pattern.logback_config.render_template
+	enc.keep_template_fields = pattern.logback_config.keep_template_fields
+	enc.value_format = pattern.logback_config.value_format
+	enc.encrypter = encrypterOf(pattern.logback_config.encrypt_fields, DefaultKeyring())
+	enc.EncoderConfig = pattern.encoderConfigOf(enc.EncoderConfig)
+	enc.json_context = nil
+	if pattern.logback_config.writes_json_context {
+		enc.json_context = zapcore.NewJSONEncoder(jsonFieldsConfig(enc.EncoderConfig))
+	}
+}
 
-	enc.actions = logback_config.actions
-	enc.used_fields = logback_config.used_fields
-	if logback_config.EncodeTime != nil {
-		enc.EncodeTime = logback_config.EncodeTime
+// encoder returns enc, wrapped to keep the context of With as JSON too when
+// the pattern has %json{fields}, and to encrypt the fields of
+// %fields{encrypt=...}, those of the current pattern for an AtomicPattern.
+func (enc *logbackEncoder) encoder() zapcore.Encoder {
+	var encoder zapcore.Encoder = enc
+	if enc.json_context != nil {
+		encoder = jsonContextEncoder{enc}
 	}
+	encrypter := enc.encrypter
+	if enc.atomic_pattern != nil {
+		// With 的上下文按添加时的格式加密, 本条日志的字段在 EncodeEntry 中加密
+		encrypter = enc.currentPatternState().encrypter
+	}
+	if encrypter != nil {
+		encoder = encrypter.Wrap(encoder)
+	}
+	return encoder
+}
 
-	if logback_config.EncodeLevel != nil {
-		enc.EncodeLevel = logback_config.EncodeLevel
+// isUsedField reports whether f, the i-th field of the entry, is written by
+// an action such as %x or %error, or by the message template, and so left
+// out of %fields.
+func (enc *logbackEncoder) isUsedField(i int, f zapcore.Field) bool {
+	if _, used := enc.used_fields[f.Key]; used {
+		return true
+	}
+	if slices.Contains(enc.template_fields, i) {
+		return true
 	}
+	return enc.writes_errors && f.Type == zapcore.ErrorType
+}
 
-	if logback_config.EncodeCaller != nil {
-		enc.EncodeCaller = logback_config.EncodeCaller
+// currentPatternState returns the current pattern of an AtomicPattern
+// encoder along with its EncoderConfig, which is rebuilt only when the
+// pattern has changed.
+func (enc *logbackEncoder) currentPatternState() *patternState {
+	pattern := enc.atomic_pattern.Load()
+	state := enc.pattern_state.Load()
+	if state != nil && state.pattern == pattern {
+		return state
 	}
+	state = &patternState{
+		pattern:   pattern,
+		cfg:       pattern.encoderConfigOf(enc.EncoderConfig),
+		encrypter: encrypterOf(pattern.logback_config.encrypt_fields, DefaultKeyring()),
+	}
+	enc.pattern_state.Store(state)
+	return state
 }
 
 func defaultReflectedEncoder(w io.Writer) zapcore.ReflectedEncoder {
@@ -164,7 +357,7 @@ func defaultReflectedEncoder(w io.Writer) zapcore.ReflectedEncoder {
 
 func (enc *logbackEncoder) AddArray(key string, arr zapcore.ArrayMarshaler) error {
 	enc.addKey(key)
-	return enc.AppendArray(arr)
+	return enc.AppendArray(enc.limits.limitArray(arr))
 }
 
 func (enc *logbackEncoder) AddObject(key string, obj zapcore.ObjectMarshaler) error {
@@ -173,12 +366,16 @@ func (enc *logbackEncoder) AddObject(key string, obj zapcore.ObjectMarshaler) er
 }
 
 func (enc *logbackEncoder) AddBinary(key string, val []byte) {
-	enc.AddString(key, base64.StdEncoding.EncodeToString(val))
+	if text, ok := enc.limits.limitBinary(val, enc.value_format); ok {
+		enc.AddString(key, text)
+		return
+	}
+	enc.AddString(key, enc.value_format.binaryText(val))
 }
 
 func (enc *logbackEncoder) AddByteString(key string, val []byte) {
 	enc.addKey(key)
-	enc.AppendByteString(val)
+	enc.AppendByteString(enc.limits.limitBytes(val))
 }
 
 func (enc *logbackEncoder) AddBool(key string, val bool) {
@@ -242,6 +439,10 @@ func (enc *logbackEncoder) encodeReflected(obj interface{}) ([]byte, error) {
 }
 
 func (enc *logbackEncoder) AddReflected(key string, obj interface{}) error {
+	if text, ok := enc.limits.limitReflected(obj); ok {
+		enc.AddString(key, text)
+		return nil
+	}
 	valueBytes, err := enc.encodeReflected(obj)
 	if err != nil {
 		return err
@@ -259,7 +460,7 @@ func (enc *logbackEncoder) OpenNamespace(key string) {
 
 func (enc *logbackEncoder) AddString(key, val string) {
 	enc.addKey(key)
-	enc.AppendString(val)
+	enc.AppendString(enc.limits.limitString(val))
 }
 
 func (enc *logbackEncoder) AddTime(key string, val time.Time) {
@@ -295,6 +496,9 @@ func (enc *logbackEncoder) AppendObject(obj zapcore.ObjectMarshaler) error {
 }
 
 func (enc *logbackEncoder) AppendBool(val bool) {
+	if enc.value_format.appendBool(enc, val) {
+		return
+	}
 	enc.addElementSeparator()
 	enc.buf.AppendBool(val)
 }
@@ -328,6 +532,9 @@ func (enc *logbackEncoder) appendComplex(val complex128, precision int) {
 }
 
 func (enc *logbackEncoder) AppendDuration(val time.Duration) {
+	if enc.value_format.appendDuration(enc, val) {
+		return
+	}
 	cur := enc.buf.Len()
 	if e := enc.EncodeDuration; e != nil {
 		e(val, enc)
@@ -373,6 +580,14 @@ func (enc *logbackEncoder) AppendStringQuota(val string) {
 // }
 
 func (enc *logbackEncoder) AppendTime(val time.Time) {
+	if enc.value_format.appendTime(enc, val) {
+		return
+	}
+	enc.appendEncodedTime(val)
+}
+
+// appendEncodedTime appends val with EncodeTime, as %date does.
+func (enc *logbackEncoder) appendEncodedTime(val time.Time) {
 	cur := enc.buf.Len()
 	if e := enc.EncodeTime; e != nil {
 		e(val, enc)
@@ -415,13 +630,29 @@ func (enc *logbackEncoder) AppendUintptr(v uintptr)        { enc.AppendUint64(ui
 func (enc *logbackEncoder) Clone() zapcore.Encoder {
 	clone := enc.clone()
 	clone.buf.Write(enc.buf.Bytes())
-	return clone
+	if enc.json_context != nil {
+		clone.json_context = enc.json_context.Clone()
+	}
+	return clone.encoder()
 }
 
 func (enc *logbackEncoder) clone() *logbackEncoder {
 	clone := _logbackPool.Get()
 	clone.EncoderConfig = enc.EncoderConfig
+	clone.actions = enc.actions
 	clone.used_fields = enc.used_fields
+	clone.writes_stack = enc.writes_stack
+	clone.writes_errors = enc.writes_errors
+	clone.json_context = enc.json_context
+	clone.multiline = enc.multiline
+	clone.limits = enc.limits
+	clone.encrypter = enc.encrypter
+	clone.message_escape = enc.message_escape
+	clone.render_template = enc.render_template
+	clone.keep_template_fields = enc.keep_template_fields
+	clone.value_format = enc.value_format
+	clone.atomic_pattern = enc.atomic_pattern
+	clone.pattern_state = enc.pattern_state
 	clone.openNamespaces = enc.openNamespaces
 	clone.buf = bufferpool.Get()
 	return clone
@@ -460,6 +691,9 @@ func (enc *logbackEncoder) addElementSeparator() {
 }
 
 func (enc *logbackEncoder) appendFloat(val float64, bitSize int) {
+	if enc.value_format.appendFloat(enc, val) {
+		return
+	}
 	enc.addElementSeparator()
 	switch {
 	case math.IsNaN(val):
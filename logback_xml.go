@@ -0,0 +1,340 @@
+package zaplogback
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// LogbackXML is a logback.xml translated into zaplogback patterns.
+// Everything that could not be translated is listed in Diagnostics with
+// its line number.
+type LogbackXML struct {
+	Appenders []LogbackAppender
+	// RootLevel is the level of <root>, DEBUG by default like logback.
+	RootLevel zapcore.Level
+	// RootAppenders are the names referenced by <appender-ref> in <root>.
+	RootAppenders []string
+	Diagnostics   []Diagnostic
+}
+
+// LogbackAppender is a console or file <appender>.
+type LogbackAppender struct {
+	Name  string
+	Class string
+	// Pattern is the translated <encoder><pattern>.
+	Pattern string
+	// Path is "stdout" or "stderr" for console appenders and the <file> of
+	// file appenders.
+	Path string
+	// Line is the line of the <appender> element.
+	Line int
+}
+
+const (
+	_logback_console_appender       = "ch.qos.logback.core.ConsoleAppender"
+	_logback_file_appender          = "ch.qos.logback.core.FileAppender"
+	_logback_rolling_file_appender  = "ch.qos.logback.core.rolling.RollingFileAppender"
+	_logback_pattern_layout_encoder = "ch.qos.logback.classic.encoder.PatternLayoutEncoder"
+	_logback_pattern_layout         = "ch.qos.logback.classic.PatternLayout"
+)
+
+// xmlNode is an element of the parsed logback.xml.
+type xmlNode struct {
+	name     string
+	attrs    map[string]string
+	text     string
+	children []*xmlNode
+	line     int
+}
+
+func (n *xmlNode) child(name string) *xmlNode {
+	for _, c := range n.children {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// LoadLogbackXML reads and translates a logback.xml file.
+func LoadLogbackXML(path string) (*LogbackXML, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseLogbackXML(f)
+}
+
+// ParseLogbackXML translates a logback.xml. ${name} and ${name:-default}
+// are replaced with <property> values or environment variables.
+func ParseLogbackXML(r io.Reader) (*LogbackXML, error) {
+	root, err := parseXMLTree(r)
+	if err != nil {
+		return nil, err
+	}
+	if root.name != "configuration" {
+		return nil, fmt.Errorf("line %d: root element is <%s>, want <configuration>", root.line, root.name)
+	}
+
+	x := &LogbackXML{RootLevel: zapcore.DebugLevel}
+	properties := make(map[string]string)
+	// <appender-ref> 所在的行, 与 RootAppenders 一一对应
+	var ref_lines []int
+	subst := func(s string) string { return substituteLogbackVariables(s, properties) }
+
+	for _, n := range root.children {
+		switch n.name {
+		case "property", "variable":
+			if n.attrs["name"] == "" || n.attrs["file"] != "" || n.attrs["resource"] != "" {
+				x.report(n.line, "only <%s name=\"...\" value=\"...\"> is supported, ignored", n.name)
+				continue
+			}
+			properties[n.attrs["name"]] = subst(n.attrs["value"])
+		case "appender":
+			if appender, ok := x.parseAppender(n, subst); ok {
+				x.Appenders = append(x.Appenders, appender)
+			}
+		case "root":
+			if level, ok := n.attrs["level"]; ok {
+				x.RootLevel = x.parseLevel(n.line, subst(level))
+			}
+			for _, c := range n.children {
+				if c.name != "appender-ref" {
+					x.report(c.line, "<%s> in <root> is not supported, ignored", c.name)
+					continue
+				}
+				x.RootAppenders = append(x.RootAppenders, subst(c.attrs["ref"]))
+				ref_lines = append(ref_lines, c.line)
+			}
+		case "logger":
+			x.report(n.line, "<logger name=%q> is not supported, zap has one level per logger", n.attrs["name"])
+		default:
+			x.report(n.line, "<%s> is not supported, ignored", n.name)
+		}
+	}
+
+	for i, name := range x.RootAppenders {
+		if _, ok := x.appender(name); !ok {
+			x.report(ref_lines[i], "appender-ref %q does not refer to a supported appender", name)
+		}
+	}
+	return x, nil
+}
+
+func (x *LogbackXML) report(line int, format string, args ...interface{}) {
+	x.Diagnostics = append(x.Diagnostics, Diagnostic{Line: line, Offset: -1, Message: fmt.Sprintf(format, args...)})
+}
+
+func (x *LogbackXML) appender(name string) (LogbackAppender, bool) {
+	for _, appender := range x.Appenders {
+		if appender.Name == name {
+			return appender, true
+		}
+	}
+	return LogbackAppender{}, false
+}
+
+func (x *LogbackXML) parseLevel(line int, level string) zapcore.Level {
+	switch strings.ToUpper(level) {
+	case "ALL", "TRACE":
+		x.report(line, "level %s is mapped to DEBUG", level)
+		return zapcore.DebugLevel
+	case "DEBUG":
+		return zapcore.DebugLevel
+	case "INFO":
+		return zapcore.InfoLevel
+	case "WARN":
+		return zapcore.WarnLevel
+	case "ERROR":
+		return zapcore.ErrorLevel
+	case "OFF":
+		return zapcore.InvalidLevel
+	}
+	x.report(line, "unknown level %q, DEBUG is used", level)
+	return zapcore.DebugLevel
+}
+
+func (x *LogbackXML) parseAppender(n *xmlNode, subst func(string) string) (LogbackAppender, bool) {
+	appender := LogbackAppender{
+		Name:  subst(n.attrs["name"]),
+		Class: subst(n.attrs["class"]),
+		Line:  n.line,
+	}
+
+	switch appender.Class {
+	case _logback_console_appender:
+		appender.Path = "stdout"
+	case _logback_file_appender, _logback_rolling_file_appender:
+	default:
+		x.report(n.line, "appender %q of class %s is not supported, ignored", appender.Name, appender.Class)
+		return appender, false
+	}
+
+	for _, c := range n.children {
+		switch c.name {
+		case "encoder", "layout":
+			appender.Pattern = x.parseEncoder(c, subst)
+		case "target":
+			switch target := strings.TrimSpace(subst(c.text)); target {
+			case "System.out":
+				appender.Path = "stdout"
+			case "System.err":
+				appender.Path = "stderr"
+			default:
+				x.report(c.line, "unknown target %q, System.out is used", target)
+			}
+		case "file":
+			appender.Path = strings.TrimSpace(subst(c.text))
+		case "append", "immediateFlush":
+			if strings.TrimSpace(subst(c.text)) == "false" {
+				x.report(c.line, "<%s>false</%s> is not supported, ignored", c.name, c.name)
+			}
+		case "rollingPolicy", "triggeringPolicy":
+			x.report(c.line, "<%s> is not supported, the file is not rolled", c.name)
+		default:
+			x.report(c.line, "<%s> in appender %q is not supported, ignored", c.name, appender.Name)
+		}
+	}
+
+	if appender.Path == "" {
+		x.report(n.line, "appender %q has no <file>, ignored", appender.Name)
+		return appender, false
+	}
+	if appender.Pattern == "" {
+		x.report(n.line, "appender %q has no <pattern>, the default pattern is used", appender.Name)
+		appender.Pattern = _default_log_format
+	}
+	return appender, true
+}
+
+func (x *LogbackXML) parseEncoder(n *xmlNode, subst func(string) string) string {
+	if class := n.attrs["class"]; class != "" && class != _logback_pattern_layout_encoder && class != _logback_pattern_layout {
+		x.report(n.line, "<%s class=%q> is not supported, only its <pattern> is used", n.name, class)
+	}
+
+	var log_format string
+	for _, c := range n.children {
+		switch c.name {
+		case "pattern":
+			var diagnostics []Diagnostic
+			log_format, diagnostics = TranslateLogbackPattern(strings.TrimSpace(subst(c.text)))
+			for _, d := range diagnostics {
+				d.Line = c.line
+				x.Diagnostics = append(x.Diagnostics, d)
+			}
+		case "layout":
+			log_format = x.parseEncoder(c, subst)
+		case "charset", "immediateFlush", "outputPatternAsHeader":
+		default:
+			x.report(c.line, "<%s> in <%s> is not supported, ignored", c.name, n.name)
+		}
+	}
+	return log_format
+}
+
+var _logback_variable_regex_pattern = regexp.MustCompile(`\$\{([\w.]+)(:-([^}]*))?\}`)
+
+func substituteLogbackVariables(s string, properties map[string]string) string {
+	return _logback_variable_regex_pattern.ReplaceAllStringFunc(s, func(m string) string {
+		sub := _logback_variable_regex_pattern.FindStringSubmatch(m)
+		if value, ok := properties[sub[1]]; ok {
+			return value
+		}
+		if value, ok := os.LookupEnv(sub[1]); ok {
+			return value
+		}
+		return sub[3]
+	})
+}
+
+func parseXMLTree(r io.Reader) (*xmlNode, error) {
+	decoder := xml.NewDecoder(r)
+	var stack []*xmlNode
+	var root *xmlNode
+
+	for {
+		line, _ := decoder.InputPos()
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			n := &xmlNode{name: token.Name.Local, attrs: make(map[string]string), line: line}
+			for _, attr := range token.Attr {
+				n.attrs[attr.Name.Local] = attr.Value
+			}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, n)
+			} else if root == nil {
+				root = n
+			}
+			stack = append(stack, n)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text += string(token)
+			}
+		}
+	}
+
+	if root == nil {
+		return nil, errors.New("empty logback configuration")
+	}
+	return root, nil
+}
+
+// Build builds a logger writing to the appenders of <root>, with the level
+// of <root>. zap.AddCaller is applied before opts, since logback patterns
+// usually print the caller.
+func (x *LogbackXML) Build(cfg zapcore.EncoderConfig, opts ...zap.Option) (*zap.Logger, error) {
+	var (
+		cores   []zapcore.Core
+		closers []func()
+	)
+	closeAll := func() {
+		for _, closeSink := range closers {
+			closeSink()
+		}
+	}
+
+	for _, name := range x.RootAppenders {
+		appender, ok := x.appender(name)
+		if !ok {
+			continue
+		}
+		pattern, err := Compile(appender.Pattern)
+		if err == nil {
+			err = pattern.checkKeyring()
+		}
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("line %d: appender %q: %w", appender.Line, name, err)
+		}
+		sink, closeSink, err := zap.Open(appender.Path)
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("line %d: appender %q: %w", appender.Line, name, err)
+		}
+		closers = append(closers, closeSink)
+		cores = append(cores, zapcore.NewCore(pattern.NewEncoder(cfg), sink, x.RootLevel))
+	}
+
+	opts = append([]zap.Option{zap.AddCaller()}, opts...)
+	return zap.New(zapcore.NewTee(cores...), opts...), nil
+}
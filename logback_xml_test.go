@@ -0,0 +1,66 @@
+package zaplogback
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestParseLogbackXML(t *testing.T) {
+	dir := t.TempDir()
+	config := `<configuration scan="true">
+  <property name="LOG_DIR" value="` + dir + `"/>
+  <appender name="FILE" class="ch.qos.logback.core.rolling.RollingFileAppender">
+    <file>${LOG_DIR}/app.log</file>
+    <rollingPolicy class="ch.qos.logback.core.rolling.TimeBasedRollingPolicy">
+      <fileNamePattern>app.%d.log</fileNamePattern>
+    </rollingPolicy>
+    <encoder>
+      <pattern>%-5level %logger - %X{tid} %msg%n</pattern>
+    </encoder>
+  </appender>
+  <appender name="SYSLOG" class="ch.qos.logback.classic.net.SyslogAppender"/>
+  <logger name="com.example" level="DEBUG"/>
+  <root level="INFO">
+    <appender-ref ref="FILE"/>
+    <appender-ref ref="SYSLOG"/>
+  </root>
+</configuration>`
+
+	x, err := ParseLogbackXML(strings.NewReader(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := []int{}
+	for _, d := range x.Diagnostics {
+		lines = append(lines, d.Line)
+	}
+	if want := []int{5, 12, 13, 16}; !slices.Equal(lines, want) {
+		t.Errorf("diagnostic lines = %v, want %v: %v", lines, want, x.Diagnostics)
+	}
+
+	logger, err := x.Build(zap.NewProductionEncoderConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger = logger.Named("svc")
+	logger.Debug("hidden")
+	logger.Info("hi", zap.String("tid", "t1"))
+	_ = logger.Sync()
+
+	got, err := os.ReadFile(filepath.Join(dir, "app.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "INFO  svc - t1 hi\n"; string(got) != want {
+		t.Errorf("app.log = %q, want %q", got, want)
+	}
+	if x.RootLevel != zapcore.InfoLevel {
+		t.Errorf("RootLevel = %v", x.RootLevel)
+	}
+}
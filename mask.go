@@ -0,0 +1,299 @@
+package zaplogback
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// The strategies of a MaskRule.
+const (
+	// MaskFull replaces the value with ***.
+	MaskFull = "full"
+	// MaskPartial keeps the ends of the value, e.g. 138****5678, and the
+	// domain of an email, e.g. z***g@example.com.
+	MaskPartial = "partial"
+	// MaskHMAC replaces the value with a keyed HMAC-SHA256 pseudonym, e.g.
+	// hmac:5e0c2d1f9a7b3c84, so equal values can still be correlated.
+	MaskHMAC = "hmac"
+	// MaskEncrypt replaces the value with an AES-GCM envelope, e.g.
+	// enc:v1:2024-01:Yq3..., which auditors holding the key file can
+	// decrypt, see Keyring.
+	MaskEncrypt = "encrypt"
+)
+
+// MaskRule is a rule of a Masker. It masks the values of the fields whose
+// key matches one of Keys, or the parts of the strings matched by Regex or
+// Detector. Exactly one of them is set.
+type MaskRule struct {
+	// Keys are globs of field keys, e.g. "*password*" or "token", matched
+	// without case. Every value under a matching key is masked, including
+	// numbers and the members of objects.
+	Keys []string `json:"keys" yaml:"keys"`
+	// Regex masks its matches, or its first group when it has groups.
+	Regex string `json:"regex" yaml:"regex"`
+	// Detector is a built-in detector: "pan" (Luhn-checked card numbers),
+	// "email", "cn_mobile", "cn_id" (checksummed Chinese ID numbers), "jwt"
+	// or "bearer" (the token of "Bearer <token>").
+	Detector string `json:"detector" yaml:"detector"`
+	// Strategy is "full" (the default), "partial", "hmac" or "encrypt".
+	Strategy string `json:"strategy" yaml:"strategy"`
+	// HMACKey is the key of the "hmac" strategy.
+	HMACKey string `json:"hmacKey" yaml:"hmacKey"`
+	// KeyFile is the key file of the "encrypt" strategy, see ParseKeyring.
+	KeyFile string `json:"keyFile" yaml:"keyFile"`
+	// Keyring is the keyring of the "encrypt" strategy, instead of KeyFile.
+	Keyring *Keyring `json:"-" yaml:"-"`
+}
+
+type maskDetector struct {
+	regex    *regexp.Regexp
+	validate func(string) bool
+}
+
+var _mask_detectors = map[string]maskDetector{
+	"pan":       {regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`), isLuhnValid},
+	"email":     {regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`), nil},
+	"cn_mobile": {regexp.MustCompile(`\b1[3-9]\d{9}\b`), nil},
+	"cn_id":     {regexp.MustCompile(`\b\d{17}[\dXx]\b`), isCNIDValid},
+	"jwt":       {regexp.MustCompile(`\beyJ[A-Za-z0-9_-]*\.eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]*`), nil},
+	"bearer":    {regexp.MustCompile(`(?i)\bbearer\s+([A-Za-z0-9._~+/-]+=*)`), nil},
+}
+
+// isLuhnValid checks the Luhn checksum of a card number of 13 to 19 digits,
+// spaces and dashes are ignored.
+func isLuhnValid(text string) bool {
+	sum, n := 0, 0
+	for i := len(text) - 1; i >= 0; i-- {
+		c := text[i]
+		if c == ' ' || c == '-' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && n <= 19 && sum%10 == 0
+}
+
+var _cn_id_weights = [17]int{7, 9, 10, 5, 8, 4, 2, 1, 6, 3, 7, 9, 10, 5, 8, 4, 2}
+
+// isCNIDValid checks the check digit of an 18 digits Chinese ID number.
+func isCNIDValid(text string) bool {
+	sum := 0
+	for i, w := range _cn_id_weights {
+		sum += int(text[i]-'0') * w
+	}
+	return "10X98765432"[sum%11] == text[17] || (text[17] == 'x' && sum%11 == 2)
+}
+
+type maskRule struct {
+	keys     []string
+	regex    *regexp.Regexp
+	validate func(string) bool
+	strategy string
+	hmac_key []byte
+	keyring  *Keyring
+}
+
+// A Masker masks sensitive data in the entries of the encoders it wraps,
+// see Wrap. The key rules are tried in order on the keys, the first match
+// masks the value; then every string is rewritten by the regex and detector
+// rules in order.
+type Masker struct {
+	key_rules   []*maskRule
+	value_rules []*maskRule
+}
+
+// NewMasker checks and compiles the rules.
+func NewMasker(rules ...MaskRule) (*Masker, error) {
+	m := &Masker{}
+	for i, rule := range rules {
+		r, err := compileMaskRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("mask rule %d: %w", i, err)
+		}
+		if len(r.keys) > 0 {
+			m.key_rules = append(m.key_rules, r)
+		} else {
+			m.value_rules = append(m.value_rules, r)
+		}
+	}
+	return m, nil
+}
+
+func compileMaskRule(rule MaskRule) (*maskRule, error) {
+	r := &maskRule{strategy: rule.Strategy}
+	switch r.strategy {
+	case "":
+		r.strategy = MaskFull
+	case MaskFull, MaskPartial:
+	case MaskHMAC:
+		if rule.HMACKey == "" {
+			return nil, fmt.Errorf("the hmac strategy needs a key")
+		}
+		r.hmac_key = []byte(rule.HMACKey)
+	case MaskEncrypt:
+		r.keyring = rule.Keyring
+		if r.keyring == nil && rule.KeyFile != "" {
+			keyring, err := LoadKeyring(rule.KeyFile)
+			if err != nil {
+				return nil, err
+			}
+			r.keyring = keyring
+		}
+		if r.keyring == nil {
+			return nil, errNoKeyring
+		}
+	default:
+		return nil, fmt.Errorf("unknown strategy %q, want full, partial, hmac or encrypt", rule.Strategy)
+	}
+
+	set := 0
+	if len(rule.Keys) > 0 {
+		set++
+		for _, glob := range rule.Keys {
+			glob = strings.ToLower(glob)
+			if _, err := path.Match(glob, ""); err != nil {
+				return nil, fmt.Errorf("invalid key glob %q", glob)
+			}
+			r.keys = append(r.keys, glob)
+		}
+	}
+	if rule.Regex != "" {
+		set++
+		regex, err := regexp.Compile(rule.Regex)
+		if err != nil {
+			return nil, err
+		}
+		r.regex = regex
+	}
+	if rule.Detector != "" {
+		set++
+		detector, ok := _mask_detectors[rule.Detector]
+		if !ok {
+			return nil, fmt.Errorf("unknown detector %q", rule.Detector)
+		}
+		r.regex, r.validate = detector.regex, detector.validate
+	}
+	if set != 1 {
+		return nil, fmt.Errorf("a rule needs one of keys, regex and detector")
+	}
+	return r, nil
+}
+
+// keyRule returns the first key rule matching key, nil if none.
+func (m *Masker) keyRule(key string) *maskRule {
+	if len(m.key_rules) == 0 {
+		return nil
+	}
+	key = strings.ToLower(key)
+	for _, r := range m.key_rules {
+		for _, glob := range r.keys {
+			if ok, _ := path.Match(glob, key); ok {
+				return r
+			}
+		}
+	}
+	return nil
+}
+
+// MaskString rewrites the parts of s matched by the regex and detector
+// rules.
+func (m *Masker) MaskString(s string) string {
+	for _, r := range m.value_rules {
+		s = r.replace(s)
+	}
+	return s
+}
+
+func (r *maskRule) replace(s string) string {
+	matches := r.regex.FindAllStringSubmatchIndex(s, -1)
+	if matches == nil {
+		return s
+	}
+	var sb strings.Builder
+	last := 0
+	for _, m := range matches {
+		start, end := m[0], m[1]
+		if len(m) >= 4 && m[2] >= 0 {
+			start, end = m[2], m[3]
+		}
+		if r.validate != nil && !r.validate(s[start:end]) {
+			continue
+		}
+		sb.WriteString(s[last:start])
+		sb.WriteString(r.mask(s[start:end]))
+		last = end
+	}
+	if last == 0 {
+		return s
+	}
+	sb.WriteString(s[last:])
+	return sb.String()
+}
+
+// mask masks a whole value with the strategy of the rule.
+func (r *maskRule) mask(value string) string {
+	switch r.strategy {
+	case MaskPartial:
+		if at := strings.LastIndexByte(value, '@'); at > 0 {
+			return maskPartially(value[:at]) + value[at:]
+		}
+		return maskPartially(value)
+	case MaskHMAC:
+		mac := hmac.New(sha256.New, r.hmac_key)
+		mac.Write([]byte(value))
+		return "hmac:" + hex.EncodeToString(mac.Sum(nil))[:16]
+	case MaskEncrypt:
+		// 没有密钥或加密失败时不写明文
+		if r.keyring == nil {
+			return "***"
+		}
+		if IsEnvelope(value) {
+			// 动态格式的 With 字段已在包装层加密过
+			return value
+		}
+		if envelope, err := r.keyring.Encrypt(value); err == nil {
+			return envelope
+		}
+	}
+	return "***"
+}
+
+// maskPartially keeps the first and last runes of value, 3 and 4 of a value
+// of 11 runes or more, and replaces the others with '*'.
+func maskPartially(value string) string {
+	n := utf8.RuneCountInString(value)
+	head, tail := 0, 0
+	switch {
+	case n >= 11:
+		head, tail = 3, 4
+	case n >= 7:
+		head, tail = 2, 2
+	case n >= 3:
+		head, tail = 1, 1
+	}
+	var sb strings.Builder
+	i := 0
+	for _, r := range value {
+		if i < head || i >= n-tail {
+			sb.WriteRune(r)
+		} else {
+			sb.WriteByte('*')
+		}
+		i++
+	}
+	return sb.String()
+}
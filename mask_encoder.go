@@ -0,0 +1,406 @@
+package zaplogback
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+// Wrap returns an encoder that masks the message, the fields and the
+// context of With before enc writes them, so %message, %x, %fields, the
+// JSON layout and zap's encoders all see the same masked values. Objects,
+// arrays and reflected values are masked member by member.
+func (m *Masker) Wrap(enc zapcore.Encoder) zapcore.Encoder {
+	// Clone 出的编码器可能已经包装过, 不再重复掩码
+	if masking, ok := enc.(*maskingEncoder); ok && masking.masker == m {
+		return masking
+	}
+	return &maskingEncoder{
+		maskingObjectEncoder: maskingObjectEncoder{ObjectEncoder: enc, masker: m},
+		encoder:              enc,
+	}
+}
+
+type maskingEncoder struct {
+	maskingObjectEncoder
+	encoder zapcore.Encoder
+}
+
+func (enc *maskingEncoder) Clone() zapcore.Encoder {
+	return enc.masker.Wrap(enc.encoder.Clone())
+}
+
+func (enc *maskingEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	ent.Message = enc.masker.MaskString(ent.Message)
+	masked := make([]zapcore.Field, len(fields))
+	for i, f := range fields {
+		masked[i] = enc.masker.maskField(f)
+	}
+	return enc.encoder.EncodeEntry(ent, masked)
+}
+
+// maskField returns f with its value masked.
+func (m *Masker) maskField(f zapcore.Field) zapcore.Field {
+	rule := m.keyRule(f.Key)
+	switch f.Type {
+	case zapcore.StringType:
+		f.String = m.maskValue(rule, f.String)
+		return f
+	case zapcore.ByteStringType:
+		f.Interface = []byte(m.maskValue(rule, string(f.Interface.([]byte))))
+		return f
+	case zapcore.ObjectMarshalerType:
+		f.Interface = maskedObject{f.Interface.(zapcore.ObjectMarshaler), m, rule}
+		return f
+	case zapcore.InlineMarshalerType:
+		// zap.Inline 的成员写在外层, 按各自的 key 掩码
+		f.Interface = maskedObject{f.Interface.(zapcore.ObjectMarshaler), m, nil}
+		return f
+	case zapcore.ArrayMarshalerType:
+		f.Interface = maskedArray{f.Interface.(zapcore.ArrayMarshaler), m, rule}
+		return f
+	case zapcore.ReflectType:
+		if masked, ok := m.maskReflected(f.Interface, rule); ok {
+			return zap.Reflect(f.Key, masked)
+		}
+		return f
+	case zapcore.StringerType, zapcore.ErrorType:
+		text, ok := "", false
+		if f.Type == zapcore.StringerType {
+			text, ok = stringOf(f.Interface.(fmt.Stringer))
+		} else if err, is_err := f.Interface.(error); is_err && err != nil {
+			text, ok = err.Error(), true
+		}
+		if masked := m.maskValue(rule, text); ok && masked != text {
+			return zap.String(f.Key, masked)
+		}
+		return f
+	case zapcore.SkipType, zapcore.NamespaceType:
+		return f
+	}
+	if rule == nil {
+		return f
+	}
+	// 数字, 布尔值, 时间等: 按文本掩码
+	if f.Type == zapcore.BinaryType {
+		return zap.String(f.Key, rule.mask(base64.StdEncoding.EncodeToString(f.Interface.([]byte))))
+	}
+	values := zapcore.NewMapObjectEncoder()
+	f.AddTo(values)
+	return zap.String(f.Key, rule.mask(fmt.Sprint(values.Fields[f.Key])))
+}
+
+// maskValue masks value with the key rule, or with the value rules when
+// rule is nil.
+func (m *Masker) maskValue(rule *maskRule, value string) string {
+	if rule != nil {
+		return rule.mask(value)
+	}
+	return m.MaskString(value)
+}
+
+// maskReflected returns the JSON of obj with its strings and the values of
+// the matching keys masked, the order of the members is kept.
+func (m *Masker) maskReflected(obj interface{}, rule *maskRule) (json.RawMessage, bool) {
+	if obj == nil {
+		return nil, false
+	}
+	text, err := json.Marshal(obj)
+	if err != nil {
+		return nil, false
+	}
+	dec := json.NewDecoder(bytes.NewReader(text))
+	dec.UseNumber()
+	var out bytes.Buffer
+	if err := m.maskJSON(&out, dec, rule); err != nil {
+		return nil, false
+	}
+	return out.Bytes(), true
+}
+
+func (m *Masker) maskJSON(out *bytes.Buffer, dec *json.Decoder, rule *maskRule) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch v := tok.(type) {
+	case json.Delim:
+		end := byte('}')
+		if v == '[' {
+			end = ']'
+		}
+		out.WriteByte(byte(v))
+		for i := 0; dec.More(); i++ {
+			if i > 0 {
+				out.WriteByte(',')
+			}
+			member_rule := rule
+			if v == '{' {
+				key_tok, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				key, _ := key_tok.(string)
+				writeJSONValue(out, key)
+				out.WriteByte(':')
+				if member_rule == nil {
+					member_rule = m.keyRule(key)
+				}
+			}
+			if err := m.maskJSON(out, dec, member_rule); err != nil {
+				return err
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+		out.WriteByte(end)
+	case string:
+		writeJSONValue(out, m.maskValue(rule, v))
+	default:
+		// 数字, 布尔值和 null, 键匹配时按文本掩码
+		if rule != nil && v != nil {
+			writeJSONValue(out, rule.mask(fmt.Sprint(v)))
+		} else {
+			writeJSONValue(out, v)
+		}
+	}
+	return nil
+}
+
+func writeJSONValue(out io.Writer, v interface{}) {
+	text, _ := json.Marshal(v)
+	out.Write(text)
+}
+
+// maskedObject masks the members of an object, all of them when rule is
+// set by the key of the object.
+type maskedObject struct {
+	zapcore.ObjectMarshaler
+	masker *Masker
+	rule   *maskRule
+}
+
+func (obj maskedObject) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	return obj.ObjectMarshaler.MarshalLogObject(maskingObjectEncoder{enc, obj.masker, obj.rule})
+}
+
+type maskedArray struct {
+	zapcore.ArrayMarshaler
+	masker *Masker
+	rule   *maskRule
+}
+
+func (arr maskedArray) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	return arr.ArrayMarshaler.MarshalLogArray(maskingArrayEncoder{enc, arr.masker, arr.rule})
+}
+
+// maskingObjectEncoder masks what is added to an ObjectEncoder. rule is set
+// inside an object whose key matches a key rule.
+type maskingObjectEncoder struct {
+	zapcore.ObjectEncoder
+	masker *Masker
+	rule   *maskRule
+}
+
+func (enc maskingObjectEncoder) ruleOf(key string) *maskRule {
+	if enc.rule != nil {
+		return enc.rule
+	}
+	return enc.masker.keyRule(key)
+}
+
+// addMasked adds the text of a value whose key matches a key rule.
+func (enc maskingObjectEncoder) addMasked(key string, value interface{}) bool {
+	rule := enc.ruleOf(key)
+	if rule == nil {
+		return false
+	}
+	enc.ObjectEncoder.AddString(key, rule.mask(fmt.Sprint(value)))
+	return true
+}
+
+func (enc maskingObjectEncoder) AddArray(key string, arr zapcore.ArrayMarshaler) error {
+	return enc.ObjectEncoder.AddArray(key, maskedArray{arr, enc.masker, enc.ruleOf(key)})
+}
+
+func (enc maskingObjectEncoder) AddObject(key string, obj zapcore.ObjectMarshaler) error {
+	return enc.ObjectEncoder.AddObject(key, maskedObject{obj, enc.masker, enc.ruleOf(key)})
+}
+
+func (enc maskingObjectEncoder) AddReflected(key string, obj interface{}) error {
+	if masked, ok := enc.masker.maskReflected(obj, enc.ruleOf(key)); ok {
+		return enc.ObjectEncoder.AddReflected(key, masked)
+	}
+	return enc.ObjectEncoder.AddReflected(key, obj)
+}
+
+func (enc maskingObjectEncoder) AddString(key, val string) {
+	enc.ObjectEncoder.AddString(key, enc.masker.maskValue(enc.ruleOf(key), val))
+}
+
+func (enc maskingObjectEncoder) AddByteString(key string, val []byte) {
+	enc.ObjectEncoder.AddByteString(key, []byte(enc.masker.maskValue(enc.ruleOf(key), string(val))))
+}
+
+func (enc maskingObjectEncoder) AddBinary(key string, val []byte) {
+	if !enc.addMasked(key, base64.StdEncoding.EncodeToString(val)) {
+		enc.ObjectEncoder.AddBinary(key, val)
+	}
+}
+
+func (enc maskingObjectEncoder) AddBool(key string, val bool) {
+	if !enc.addMasked(key, val) {
+		enc.ObjectEncoder.AddBool(key, val)
+	}
+}
+
+func (enc maskingObjectEncoder) AddComplex128(key string, val complex128) {
+	if !enc.addMasked(key, val) {
+		enc.ObjectEncoder.AddComplex128(key, val)
+	}
+}
+
+func (enc maskingObjectEncoder) AddDuration(key string, val time.Duration) {
+	if !enc.addMasked(key, val) {
+		enc.ObjectEncoder.AddDuration(key, val)
+	}
+}
+
+func (enc maskingObjectEncoder) AddFloat64(key string, val float64) {
+	if !enc.addMasked(key, val) {
+		enc.ObjectEncoder.AddFloat64(key, val)
+	}
+}
+
+func (enc maskingObjectEncoder) AddInt64(key string, val int64) {
+	if !enc.addMasked(key, val) {
+		enc.ObjectEncoder.AddInt64(key, val)
+	}
+}
+
+func (enc maskingObjectEncoder) AddUint64(key string, val uint64) {
+	if !enc.addMasked(key, val) {
+		enc.ObjectEncoder.AddUint64(key, val)
+	}
+}
+
+func (enc maskingObjectEncoder) AddTime(key string, val time.Time) {
+	if !enc.addMasked(key, val) {
+		enc.ObjectEncoder.AddTime(key, val)
+	}
+}
+
+func (enc maskingObjectEncoder) AddComplex64(k string, v complex64) {
+	enc.AddComplex128(k, complex128(v))
+}
+func (enc maskingObjectEncoder) AddFloat32(k string, v float32) { enc.AddFloat64(k, float64(v)) }
+func (enc maskingObjectEncoder) AddInt(k string, v int)         { enc.AddInt64(k, int64(v)) }
+func (enc maskingObjectEncoder) AddInt32(k string, v int32)     { enc.AddInt64(k, int64(v)) }
+func (enc maskingObjectEncoder) AddInt16(k string, v int16)     { enc.AddInt64(k, int64(v)) }
+func (enc maskingObjectEncoder) AddInt8(k string, v int8)       { enc.AddInt64(k, int64(v)) }
+func (enc maskingObjectEncoder) AddUint(k string, v uint)       { enc.AddUint64(k, uint64(v)) }
+func (enc maskingObjectEncoder) AddUint32(k string, v uint32)   { enc.AddUint64(k, uint64(v)) }
+func (enc maskingObjectEncoder) AddUint16(k string, v uint16)   { enc.AddUint64(k, uint64(v)) }
+func (enc maskingObjectEncoder) AddUint8(k string, v uint8)     { enc.AddUint64(k, uint64(v)) }
+func (enc maskingObjectEncoder) AddUintptr(k string, v uintptr) { enc.AddUint64(k, uint64(v)) }
+
+// maskingArrayEncoder masks the elements of an array, all of them when
+// rule is set by the key of the array.
+type maskingArrayEncoder struct {
+	zapcore.ArrayEncoder
+	masker *Masker
+	rule   *maskRule
+}
+
+func (enc maskingArrayEncoder) appendMasked(value interface{}) bool {
+	if enc.rule == nil {
+		return false
+	}
+	enc.ArrayEncoder.AppendString(enc.rule.mask(fmt.Sprint(value)))
+	return true
+}
+
+func (enc maskingArrayEncoder) AppendArray(arr zapcore.ArrayMarshaler) error {
+	return enc.ArrayEncoder.AppendArray(maskedArray{arr, enc.masker, enc.rule})
+}
+
+func (enc maskingArrayEncoder) AppendObject(obj zapcore.ObjectMarshaler) error {
+	return enc.ArrayEncoder.AppendObject(maskedObject{obj, enc.masker, enc.rule})
+}
+
+func (enc maskingArrayEncoder) AppendReflected(val interface{}) error {
+	if masked, ok := enc.masker.maskReflected(val, enc.rule); ok {
+		return enc.ArrayEncoder.AppendReflected(masked)
+	}
+	return enc.ArrayEncoder.AppendReflected(val)
+}
+
+func (enc maskingArrayEncoder) AppendString(val string) {
+	enc.ArrayEncoder.AppendString(enc.masker.maskValue(enc.rule, val))
+}
+
+func (enc maskingArrayEncoder) AppendByteString(val []byte) {
+	enc.ArrayEncoder.AppendByteString([]byte(enc.masker.maskValue(enc.rule, string(val))))
+}
+
+func (enc maskingArrayEncoder) AppendBool(val bool) {
+	if !enc.appendMasked(val) {
+		enc.ArrayEncoder.AppendBool(val)
+	}
+}
+
+func (enc maskingArrayEncoder) AppendComplex128(val complex128) {
+	if !enc.appendMasked(val) {
+		enc.ArrayEncoder.AppendComplex128(val)
+	}
+}
+
+func (enc maskingArrayEncoder) AppendDuration(val time.Duration) {
+	if !enc.appendMasked(val) {
+		enc.ArrayEncoder.AppendDuration(val)
+	}
+}
+
+func (enc maskingArrayEncoder) AppendFloat64(val float64) {
+	if !enc.appendMasked(val) {
+		enc.ArrayEncoder.AppendFloat64(val)
+	}
+}
+
+func (enc maskingArrayEncoder) AppendInt64(val int64) {
+	if !enc.appendMasked(val) {
+		enc.ArrayEncoder.AppendInt64(val)
+	}
+}
+
+func (enc maskingArrayEncoder) AppendUint64(val uint64) {
+	if !enc.appendMasked(val) {
+		enc.ArrayEncoder.AppendUint64(val)
+	}
+}
+
+func (enc maskingArrayEncoder) AppendTime(val time.Time) {
+	if !enc.appendMasked(val) {
+		enc.ArrayEncoder.AppendTime(val)
+	}
+}
+
+func (enc maskingArrayEncoder) AppendComplex64(v complex64) { enc.AppendComplex128(complex128(v)) }
+func (enc maskingArrayEncoder) AppendFloat32(v float32)     { enc.AppendFloat64(float64(v)) }
+func (enc maskingArrayEncoder) AppendInt(v int)             { enc.AppendInt64(int64(v)) }
+func (enc maskingArrayEncoder) AppendInt32(v int32)         { enc.AppendInt64(int64(v)) }
+func (enc maskingArrayEncoder) AppendInt16(v int16)         { enc.AppendInt64(int64(v)) }
+func (enc maskingArrayEncoder) AppendInt8(v int8)           { enc.AppendInt64(int64(v)) }
+func (enc maskingArrayEncoder) AppendUint(v uint)           { enc.AppendUint64(uint64(v)) }
+func (enc maskingArrayEncoder) AppendUint32(v uint32)       { enc.AppendUint64(uint64(v)) }
+func (enc maskingArrayEncoder) AppendUint16(v uint16)       { enc.AppendUint64(uint64(v)) }
+func (enc maskingArrayEncoder) AppendUint8(v uint8)         { enc.AppendUint64(uint64(v)) }
+func (enc maskingArrayEncoder) AppendUintptr(v uintptr)     { enc.AppendUint64(uint64(v)) }
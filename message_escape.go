@@ -0,0 +1,193 @@
+package zaplogback
+
+import (
+	"fmt"
+	"sync"
+	"unicode/utf8"
+
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+// The escapes of %message{escape=...}:
+//
+//	none   the message as it is
+//	json   escaped like a JSON string, without the quotes
+//	quote  a JSON string, with the quotes
+//	safe   CR and LF written as `\r` and `\n`, the other C0 and C1 controls,
+//	       e.g. the ESC of ANSI escape sequences, and the bidi controls
+//	       written as `\x1b` or `\u202e`, so a message can neither forge a
+//	       log line nor drive the terminal; tabs are kept
+const (
+	MessageEscapeNone  = "none"
+	MessageEscapeJSON  = "json"
+	MessageEscapeQuote = "quote"
+	MessageEscapeSafe  = "safe"
+)
+
+var (
+	_message_escape_mutex sync.RWMutex
+	// 空表示未设置, 由 _development 决定
+	_default_message_escape string
+	_development            bool
+)
+
+// _message_escape_safe_lines is the safe escape of a pattern with a
+// %multiline policy, which rewrites the line breaks itself.
+const _message_escape_safe_lines = "safe_lines"
+
+// defaultMessageEscape returns the escape of %message without escape=.
+func (enc *logbackEncoder) defaultMessageEscape() string {
+	if enc.message_escape == MessageEscapeSafe && enc.multiline != nil {
+		return _message_escape_safe_lines
+	}
+	return enc.message_escape
+}
+
+func checkMessageEscape(escape string) error {
+	switch escape {
+	case MessageEscapeNone, MessageEscapeJSON, MessageEscapeQuote, MessageEscapeSafe:
+		return nil
+	}
+	return fmt.Errorf("unknown message escape %q, want none, json, quote or safe", escape)
+}
+
+// SetDefaultMessageEscape sets the escape of %message without escape= for
+// the encoders built afterwards, in place of the default of SetDevelopment.
+// It is the escape of NewZaplogbackEncoder, RegisterLogbackEncoder,
+// Pattern.NewEncoder and AtomicPattern.NewEncoder; Config.Build uses
+// Config.MessageEscape instead. An empty escape restores the default.
+func SetDefaultMessageEscape(escape string) error {
+	if escape == "" {
+		_message_escape_mutex.Lock()
+		defer _message_escape_mutex.Unlock()
+		_default_message_escape = ""
+		return nil
+	}
+	if err := checkMessageEscape(escape); err != nil {
+		return err
+	}
+	_message_escape_mutex.Lock()
+	defer _message_escape_mutex.Unlock()
+	_default_message_escape = escape
+	return nil
+}
+
+// SetDevelopment marks the encoders built afterwards as development ones,
+// whose %message defaults to "none"; production encoders, the default,
+// use "safe" so a message can not forge a log line. Config.Build follows
+// Config.Development instead.
+func SetDevelopment(development bool) {
+	_message_escape_mutex.Lock()
+	defer _message_escape_mutex.Unlock()
+	_development = development
+}
+
+// DefaultMessageEscape returns the escape set by SetDefaultMessageEscape,
+// or "safe" for production and "none" for development, see SetDevelopment.
+func DefaultMessageEscape() string {
+	_message_escape_mutex.RLock()
+	defer _message_escape_mutex.RUnlock()
+	switch {
+	case _default_message_escape != "":
+		return _default_message_escape
+	case _development:
+		return MessageEscapeNone
+	}
+	return MessageEscapeSafe
+}
+
+// messageAction is %message{escape=safe}, %message without escape= uses the
+// escape of the encoder. With template, the {name} placeholders of the
+// message are replaced with the fields, see renderTemplate.
+type messageAction struct {
+	escape   string
+	template string
+}
+
+func (a messageAction) configure(logback_config *LogbackConfig) {
+	if a.template != "" {
+		logback_config.render_template = true
+		logback_config.keep_template_fields = a.template == "keep"
+	}
+}
+
+func (a messageAction) AppendEntry(w ActionWriter, ent *zapcore.Entry, fields []zapcore.Field) {
+	if w.enc.MessageKey != "" {
+		escape := a.escape
+		if escape == "" {
+			escape = w.enc.defaultMessageEscape()
+		}
+		appendEscapedMessage(w.enc.buf, escape, ent.Message)
+	}
+}
+
+func appendEscapedMessage(buf *buffer.Buffer, escape string, msg string) {
+	switch escape {
+	case MessageEscapeJSON:
+		safeAppendStringLike((*buffer.Buffer).AppendString, utf8.DecodeRuneInString, buf, msg)
+	case MessageEscapeQuote:
+		appendJSONString(buf, msg)
+	case MessageEscapeSafe, _message_escape_safe_lines:
+		appendSafeString(buf, msg, escape == _message_escape_safe_lines)
+	default:
+		buf.AppendString(msg)
+	}
+}
+
+// isBidiControl reports whether r reorders the text around it, see
+// https://www.unicode.org/reports/tr9/
+func isBidiControl(r rune) bool {
+	return r == 0x061c || r == 0x200e || r == 0x200f ||
+		(r >= 0x202a && r <= 0x202e) || (r >= 0x2066 && r <= 0x2069)
+}
+
+// appendSafeString appends s with the line breaks and the control
+// characters made visible, see MessageEscapeSafe. keep_line_breaks leaves
+// CR and LF to the %multiline policy.
+func appendSafeString(buf *buffer.Buffer, s string, keep_line_breaks bool) {
+	last := 0
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if !isSafeRune(r, size) && !(keep_line_breaks && (r == '\n' || r == '\r')) {
+			buf.AppendString(s[last:i])
+			appendSafeRune(buf, r, size)
+			last = i + size
+		}
+		i += size
+	}
+	buf.AppendString(s[last:])
+}
+
+// isSafeRune reports whether r, decoded from size bytes, is written as it is
+// by the safe escape.
+func isSafeRune(r rune, size int) bool {
+	switch {
+	case r == '\t' || (r >= 0x20 && r < 0x7f):
+		return true
+	case r == utf8.RuneError && size == 1, r <= 0x9f:
+		return false
+	}
+	// U+2028 和 U+2029 在一些查看器中会换行
+	return !isBidiControl(r) && r != 0x2028 && r != 0x2029
+}
+
+func appendSafeRune(buf *buffer.Buffer, r rune, size int) {
+	switch {
+	case r == '\n':
+		buf.AppendString(`\n`)
+	case r == '\r':
+		buf.AppendString(`\r`)
+	case r == utf8.RuneError && size == 1:
+		buf.AppendString(`\ufffd`)
+	case r < 0x80:
+		buf.AppendString(`\x`)
+		buf.AppendByte(_hex[r>>4])
+		buf.AppendByte(_hex[r&0xf])
+	default:
+		buf.AppendString(`\u`)
+		for shift := 12; shift >= 0; shift -= 4 {
+			buf.AppendByte(_hex[(r>>shift)&0xf])
+		}
+	}
+}
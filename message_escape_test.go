@@ -0,0 +1,147 @@
+package zaplogback
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// _forged_message tries to forge a second line, color the terminal and
+// reverse the text after it.
+const _forged_message = "paid \"10\\\" \r\n2024-07-06 INFO admin login\x1b[31m\u202egnp.exe\u0085\x00\tok\xff"
+
+func TestMessageEscape(t *testing.T) {
+	ent := zapcore.Entry{Level: zapcore.InfoLevel, Message: _forged_message}
+	tests := []struct {
+		format string
+		want   string
+	}{
+		// 默认是 production 的 safe
+		{`%level %message`,
+			`info paid "10\" \r\n2024-07-06 INFO admin login\x1b[31m\u202egnp.exe\u0085\x00` + "\tok" + `\ufffd`},
+		{`%level %message{escape=none}`,
+			"info " + _forged_message},
+		{`%level %message{escape=json}`,
+			`info paid \"10\\\" \r\n2024-07-06 INFO admin login\u001b[31m` + "\u202egnp.exe\u0085" + `\u0000\tok\ufffd`},
+		{`%level %message{escape=quote}`,
+			`info "paid \"10\\\" \r\n2024-07-06 INFO admin login\u001b[31m` + "\u202egnp.exe\u0085" + `\u0000\tok\ufffd"`},
+		{`%level %message{escape=safe}`,
+			`info paid "10\" \r\n2024-07-06 INFO admin login\x1b[31m\u202egnp.exe\u0085\x00` + "\tok" + `\ufffd`},
+		{`%level %msg{escape=safe,as=text}`,
+			`info paid "10\" \r\n2024-07-06 INFO admin login\x1b[31m\u202egnp.exe\u0085\x00` + "\tok" + `\ufffd`},
+	}
+	for _, tt := range tests {
+		if got := encodeForTest(t, tt.format, ent); got != tt.want {
+			t.Errorf("%s:\ngot  %q\nwant %q", tt.format, got, tt.want)
+		}
+	}
+
+	safe := encodeForTest(t, `%message{escape=safe}`, zapcore.Entry{Message: "a\u2028b\u2066c\u061cd\x7fe 中文"})
+	if want := `a\u2028b\u2066c\u061cd\x7fe 中文`; safe != want {
+		t.Errorf("safe = %q, want %q", safe, want)
+	}
+
+	// %multiline 改写换行, safe 只转义其余的控制字符
+	if got, want := encodeForTest(t, `%multiline{indent}%message`, zapcore.Entry{Message: "a\nb\x1b[31m"}), "a\n\tb\\x1b[31m"; got != want {
+		t.Errorf("%%multiline{indent}%%message = %q, want %q", got, want)
+	}
+
+	for _, format := range []string{`%message{escape=html}`, `%message{safe}`} {
+		if _, err := Compile(format); err == nil {
+			t.Errorf("%s: expected an error", format)
+		}
+	}
+}
+
+func TestDevelopmentMessageEscape(t *testing.T) {
+	SetDevelopment(true)
+	defer SetDevelopment(false)
+	ent := zapcore.Entry{Message: "a\nb"}
+	if got, want := encodeForTest(t, `%message`, ent), "a\nb"; got != want {
+		t.Errorf("development: got %q, want %q", got, want)
+	}
+	if got, want := encodeForTest(t, `%message{escape=safe}`, ent), `a\nb`; got != want {
+		t.Errorf("development escape=safe: got %q, want %q", got, want)
+	}
+	if err := SetDefaultMessageEscape(MessageEscapeQuote); err != nil {
+		t.Fatal(err)
+	}
+	defer SetDefaultMessageEscape("")
+	if got, want := encodeForTest(t, `%message`, ent), `"a\nb"`; got != want {
+		t.Errorf("SetDefaultMessageEscape over development: got %q, want %q", got, want)
+	}
+}
+
+func TestDefaultMessageEscape(t *testing.T) {
+	if err := SetDefaultMessageEscape("html"); err == nil {
+		t.Error("expected an error for an unknown escape")
+	}
+	if err := SetDefaultMessageEscape(MessageEscapeSafe); err != nil {
+		t.Fatal(err)
+	}
+	defer SetDefaultMessageEscape("")
+
+	ent := zapcore.Entry{Message: "a\nb"}
+	if got, want := encodeForTest(t, `%message`, ent), `a\nb`; got != want {
+		t.Errorf("default safe: got %q, want %q", got, want)
+	}
+	if got, want := encodeForTest(t, `%message{escape=none}`, ent), "a\nb"; got != want {
+		t.Errorf("escape=none: got %q, want %q", got, want)
+	}
+}
+
+func TestConfigMessageEscape(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		config string
+		want   string
+	}{
+		{`{}`, `a\nb\x1b[0m` + "\n"},
+		{`{"development": true}`, "a\nb\x1b[0m\n"},
+		{`{"messageEscape": "quote"}`, `"a\nb\u001b[0m"` + "\n"},
+	}
+	for i, tt := range tests {
+		cfg, err := ParseConfig([]byte(tt.config), "json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, "app.log"+string(rune('0'+i)))
+		cfg.OutputPaths = []string{path}
+		cfg.Pattern = "%message"
+		logger, err := cfg.Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		logger.Info("a\nb\x1b[0m")
+		_ = logger.Sync()
+
+		got, _ := os.ReadFile(path)
+		if string(got) != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.config, got, tt.want)
+		}
+	}
+
+	if _, err := ParseConfig([]byte(`{"messageEscape": "html"}`), "json"); err == nil {
+		t.Error("expected an error for an unknown escape")
+	}
+}
+
+func TestParserMessageEscape(t *testing.T) {
+	for _, format := range []string{`%level %x{tid} %message{escape=json}`, `%level %message{escape=quote} %x{tid}`} {
+		line := encodeForTest(t, format, zapcore.Entry{Message: "a \"b\"\nc"}, zap.String("tid", "t1"))
+		p, err := NewParser(format, zap.NewProductionEncoderConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, ok := p.ParseRecord(line)
+		if !ok {
+			t.Fatalf("%s: no match for %q", format, line)
+		}
+		if r.Message != "a \"b\"\nc" || len(r.Fields) != 1 || r.Fields[0].Value != "t1" {
+			t.Errorf("%s: got %q %v", format, r.Message, r.Fields)
+		}
+	}
+}
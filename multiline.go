@@ -0,0 +1,159 @@
+package zaplogback
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+// multilinePolicy rewrites the line breaks written by the actions of a
+// pattern, e.g. in a message or a stacktrace, so that every line of the log
+// can be told apart from the start of an entry:
+//
+//	%multiline{escape}         \n and \r are written as `\n` and `\r`
+//	%multiline{indent}         continuation lines start with a tab, the
+//	                           same as %multiline
+//	%multiline{indent=>> }     continuation lines start with ">> "
+//	%multiline{header}         continuation lines start with the output of
+//	                           the pattern before %multiline, e.g.
+//	                           `%date %level | %multiline{header}%message`
+//
+// Literal text and %n are left as they are.
+type multilinePolicy struct {
+	mode   string
+	prefix string
+	// rewrites[i] 表示是否改写第 i 个 action 的输出
+	rewrites []bool
+	// header 模式下, 前 header_actions 个 action 的输出作为续行的行首
+	header_actions int
+}
+
+// multilineAction is the %multiline marker, it writes nothing.
+type multilineAction struct {
+	logActionOperation
+	mode   string
+	prefix string
+}
+
+func newMultilineAction(config string) (Action, error) {
+	a := multilineAction{logActionOperation: logAddNothingAction}
+	mode, prefix, has_prefix := strings.Cut(config, "=")
+	switch mode {
+	case "raw", "escape", "header":
+		if has_prefix {
+			return nil, fmt.Errorf("%%multiline{%s} takes no value", mode)
+		}
+	case "", "indent":
+		mode = "indent"
+		a.prefix = "\t"
+		if has_prefix {
+			a.prefix = prefix
+		}
+	default:
+		return nil, fmt.Errorf("unknown multiline policy %q, want raw, escape, indent or header", config)
+	}
+	a.mode = mode
+	return a, nil
+}
+
+// multilinePolicyOf builds the policy of the %multiline element of a
+// compiled pattern, nil when there is none or it is raw.
+func multilinePolicyOf(elements []PatternAction) (*multilinePolicy, error) {
+	var policy *multilinePolicy
+	for i, element := range elements {
+		marker, ok := element.Action.(multilineAction)
+		if !ok {
+			continue
+		}
+		if policy != nil {
+			return nil, &PatternError{Offset: element.Offset, Action: "%multiline", Err: fmt.Errorf("%%multiline is used more than once")}
+		}
+		policy = &multilinePolicy{mode: marker.mode, prefix: marker.prefix, header_actions: i + 1}
+	}
+	if policy == nil || policy.mode == "raw" {
+		return nil, nil
+	}
+
+	policy.rewrites = make([]bool, len(elements))
+	for i, element := range elements {
+		policy.rewrites[i] = !element.IsLiteral() && element.Name != "n" && element.Name != "multiline"
+	}
+	return policy, nil
+}
+
+// hasLineBreak reports whether b contains '\n' or '\r'.
+func hasLineBreak(b []byte) bool {
+	return bytes.IndexByte(b, '\n') >= 0 || bytes.IndexByte(b, '\r') >= 0
+}
+
+// rewrite applies the policy to buf[start:], header is the text repeated by
+// the header mode. header may be a prefix of buf[:start], which is left as
+// it is.
+func (p *multilinePolicy) rewrite(buf *buffer.Buffer, start int, header []byte) {
+	if !hasLineBreak(buf.Bytes()[start:]) {
+		return
+	}
+	line := append([]byte(nil), buf.Bytes()...)
+	buf.Reset()
+	buf.Write(line[:start])
+	segment := line[start:]
+	for i := 0; i < len(segment); i++ {
+		c := segment[i]
+		switch {
+		case p.mode == "escape" && c == '\n':
+			buf.AppendString(`\n`)
+		case p.mode == "escape" && c == '\r':
+			buf.AppendString(`\r`)
+		case c == '\n':
+			buf.AppendByte('\n')
+			if p.mode == "indent" {
+				buf.AppendString(p.prefix)
+			} else {
+				buf.Write(header)
+			}
+		case c == '\r' && i+1 < len(segment) && segment[i+1] == '\n':
+			// \r\n 当作一个换行
+		default:
+			buf.AppendByte(c)
+		}
+	}
+}
+
+// appendEntryActions runs the actions of an entry and applies the
+// multiline policy to their output.
+func (final *logbackEncoder) appendEntryActions(actions []Action, ent *zapcore.Entry, fields []zapcore.Field) {
+	policy := final.multiline
+	if policy == nil {
+		for _, action := range actions {
+			action.AppendEntry(ActionWriter{final}, ent, fields)
+		}
+		return
+	}
+
+	header_len := 0
+	for i, action := range actions {
+		start := final.buf.Len()
+		action.AppendEntry(ActionWriter{final}, ent, fields)
+		if policy.rewrites[i] {
+			policy.rewrite(final.buf, start, final.buf.Bytes()[:header_len])
+		}
+		if i+1 == policy.header_actions {
+			header_len = final.buf.Len()
+		}
+	}
+	final.multiline_header_len = header_len
+}
+
+// appendStack appends the stacktrace of an entry whose pattern has no
+// %stacktrace, on the next lines.
+func (final *logbackEncoder) appendStack(stack string) {
+	start := final.buf.Len()
+	final.buf.AppendByte('\n')
+	final.buf.AppendString(stack)
+	if final.multiline != nil {
+		final.multiline.rewrite(final.buf, start, final.buf.Bytes()[:final.multiline_header_len])
+	}
+}
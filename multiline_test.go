@@ -0,0 +1,50 @@
+package zaplogback
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestMultiline(t *testing.T) {
+	ent := zapcore.Entry{
+		Level:   zapcore.ErrorLevel,
+		Time:    time.Date(2024, 7, 6, 20, 32, 18, 0, time.UTC),
+		Message: "query failed:\r\nSELECT 1\nFROM t",
+		Stack:   "main.f\n\t/src/main.go:7",
+	}
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		// 没有 %multiline 时, 默认的 safe 转义消息中的换行
+		{`%level{upper} %message`,
+			"ERROR query failed:\\r\\nSELECT 1\\nFROM t\nmain.f\n\t/src/main.go:7"},
+		{`%level{upper} %message{escape=none}%multiline{raw}`,
+			"ERROR query failed:\r\nSELECT 1\nFROM t\nmain.f\n\t/src/main.go:7"},
+		{`%multiline{escape}%level{upper} %message`,
+			"ERROR query failed:\\r\\nSELECT 1\\nFROM t\\nmain.f\\n\t/src/main.go:7"},
+		{`%multiline{indent}%level{upper} %message`,
+			"ERROR query failed:\n\tSELECT 1\n\tFROM t\n\tmain.f\n\t\t/src/main.go:7"},
+		{`%multiline{indent=  > }%level{upper} %message`,
+			"ERROR query failed:\n  > SELECT 1\n  > FROM t\n  > main.f\n  > \t/src/main.go:7"},
+		{`%date{%H:%M:%S} %level{upper} | %multiline{header}%message`,
+			"20:32:18 ERROR | query failed:\n20:32:18 ERROR | SELECT 1\n20:32:18 ERROR | FROM t\n" +
+				"20:32:18 ERROR | main.f\n20:32:18 ERROR | \t/src/main.go:7"},
+		{`%date{%H:%M:%S} | %multiline{header}%stacktrace%n%message`,
+			"20:32:18 | main.f\n20:32:18 | \t/src/main.go:7\nquery failed:\n20:32:18 | SELECT 1\n20:32:18 | FROM t"},
+	}
+	for _, tt := range tests {
+		if got := encodeForTest(t, tt.format, ent); got != tt.want {
+			t.Errorf("%s:\ngot  %q\nwant %q", tt.format, got, tt.want)
+		}
+	}
+
+	for _, format := range []string{`%multiline{wrap}`, `%multiline{escape=1}`, `%multiline %message %multiline{indent}`} {
+		if _, err := Compile(format); err == nil {
+			t.Errorf("%s: expected an error", format)
+		}
+	}
+}
@@ -1,9 +1,13 @@
 package zaplogback
 
 import (
+	"fmt"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"go.uber.org/zap/zapcore"
 )
@@ -16,14 +20,14 @@ func logAddBytesAction(value []byte) logActionOperation {
 
 func logAddTimeAction(final *logbackEncoder, ent *zapcore.Entry, fields []zapcore.Field) {
 	if final.TimeKey != "" && !ent.Time.IsZero() {
-		final.AppendTime(ent.Time)
+		final.appendEncodedTime(ent.Time)
 	}
 }
 
 func logAddLevelAction(final *logbackEncoder, ent *zapcore.Entry, fields []zapcore.Field) {
 	if final.LevelKey != "" && final.EncodeLevel != nil {
 		cur := final.buf.Len()
-		final.EncodeLevel(ent.Level, final)
+		final.EncodeLevel(ent.Level, rawStringEncoder{final})
 		if cur == final.buf.Len() {
 			// User-supplied EncodeLevel was a no-op. Fall back to strings to keep
 			// output JSON valid.
@@ -33,6 +37,17 @@ func logAddLevelAction(final *logbackEncoder, ent *zapcore.Entry, fields []zapco
 	}
 }
 
+// rawStringEncoder writes strings without escaping them, like zap's console
+// encoder does for levels, so the escape codes of the color level encoders
+// reach the terminal.
+type rawStringEncoder struct {
+	*logbackEncoder
+}
+
+func (enc rawStringEncoder) AppendString(val string) {
+	enc.buf.AppendString(val)
+}
+
 func logAddCallerAction(final *logbackEncoder, ent *zapcore.Entry, fields []zapcore.Field) {
 
 	if ent.Caller.Defined {
@@ -54,9 +69,91 @@ func logAddCallerAction(final *logbackEncoder, ent *zapcore.Entry, fields []zapc
 	}
 }
 
+// logAddFileAction 输出caller的文件名, full 为 true 时输出完整路径
+func logAddFileAction(full bool) logActionOperation {
+	return func(final *logbackEncoder, ent *zapcore.Entry, fields []zapcore.Field) {
+		if !ent.Caller.Defined {
+			return
+		}
+		if full {
+			final.buf.AppendString(ent.Caller.File)
+		} else {
+			final.buf.AppendString(filepath.Base(ent.Caller.File))
+		}
+	}
+}
+
+func logAddLineAction(final *logbackEncoder, ent *zapcore.Entry, fields []zapcore.Field) {
+	if ent.Caller.Defined {
+		final.buf.AppendInt(int64(ent.Caller.Line))
+	}
+}
+
+// logAddMethodAction 输出不含包路径的函数名, 如 (*T).Method
+func logAddMethodAction(final *logbackEncoder, ent *zapcore.Entry, fields []zapcore.Field) {
+	if !ent.Caller.Defined || ent.Caller.Function == "" {
+		return
+	}
+	function := ent.Caller.Function
+	if slash := strings.LastIndexByte(function, '/'); slash >= 0 {
+		function = function[slash+1:]
+	}
+	if dot := strings.IndexByte(function, '.'); dot >= 0 {
+		function = function[dot+1:]
+	}
+	final.buf.AppendString(function)
+}
+
+func logAddLineEndingAction(final *logbackEncoder, ent *zapcore.Entry, fields []zapcore.Field) {
+	if final.LineEnding == "" {
+		final.buf.AppendByte('\n')
+		return
+	}
+	final.buf.AppendString(final.LineEnding)
+}
+
+func logAddNothingAction(final *logbackEncoder, ent *zapcore.Entry, fields []zapcore.Field) {
+}
+
+// logAddStacktraceAction 在格式中的位置输出stacktrace, 不再追加到日志末尾
+func logAddStacktraceAction(final *logbackEncoder, ent *zapcore.Entry, fields []zapcore.Field) {
+	if ent.Stack != "" && final.StacktraceKey != "" {
+		final.buf.AppendString(ent.Stack)
+	}
+}
+
+// logAddAbbreviatedNameAction 同 logback 的 %logger{length}, 从左到右把包名缩写为首字母,
+// 直到长度不超过 length, 最后一段总是完整输出
+func logAddAbbreviatedNameAction(length int) logActionOperation {
+	return func(final *logbackEncoder, ent *zapcore.Entry, fields []zapcore.Field) {
+		if ent.LoggerName == "" || final.NameKey == "" {
+			return
+		}
+		final.buf.AppendString(abbreviateLoggerName(ent.LoggerName, length))
+	}
+}
+
+func abbreviateLoggerName(name string, length int) string {
+	if len(name) <= length {
+		return name
+	}
+	segments := strings.Split(name, ".")
+	last := len(segments) - 1
+	if length == 0 {
+		return segments[last]
+	}
+	total := len(name)
+	for i := 0; i < last && total > length; i++ {
+		if len(segments[i]) > 1 {
+			total -= len(segments[i]) - 1
+			segments[i] = segments[i][:1]
+		}
+	}
+	return strings.Join(segments, ".")
+}
+
 func logAddNameAction(final *logbackEncoder, ent *zapcore.Entry, fields []zapcore.Field) {
 	if ent.LoggerName != "" && final.NameKey != "" {
-		final.addKey(final.NameKey)
 		cur := final.buf.Len()
 		nameEncoder := final.EncodeName
 
@@ -77,7 +174,41 @@ func logAddNameAction(final *logbackEncoder, ent *zapcore.Entry, fields []zapcor
 
 func logAddMsgAction(final *logbackEncoder, ent *zapcore.Entry, fields []zapcore.Field) {
 	if final.MessageKey != "" {
-		final.buf.AppendBytes([]byte(ent.Message))
+		appendEscapedMessage(final.buf, final.defaultMessageEscape(), ent.Message)
+	}
+}
+
+// _process_start_time is the reference point of %relative, like logback's
+// application start time.
+var _process_start_time = time.Now()
+
+// _global_seq is shared by every %seq{global} action in the process.
+var _global_seq atomic.Uint64
+
+// logAddRelativeAction 输出进程启动至今的毫秒数
+func logAddRelativeAction(final *logbackEncoder, ent *zapcore.Entry, fields []zapcore.Field) {
+	final.buf.AppendInt(ent.Time.Sub(_process_start_time).Milliseconds())
+}
+
+// logAddDeltaAction 输出与同一个encoder上一条日志之间的毫秒数, 第一条日志输出0
+// The state lives in the closure, so all clones of an encoder share it.
+func logAddDeltaAction() logActionOperation {
+	last := new(atomic.Int64)
+	return func(final *logbackEncoder, ent *zapcore.Entry, fields []zapcore.Field) {
+		now := ent.Time.UnixNano()
+		prev := last.Swap(now)
+		if prev == 0 || now <= prev {
+			final.buf.AppendInt(0)
+			return
+		}
+		final.buf.AppendInt((now - prev) / int64(time.Millisecond))
+	}
+}
+
+// logAddSeqAction 输出单调递增的序号, 从1开始
+func logAddSeqAction(counter *atomic.Uint64) logActionOperation {
+	return func(final *logbackEncoder, ent *zapcore.Entry, fields []zapcore.Field) {
+		final.buf.AppendUint(counter.Add(1))
 	}
 }
 
@@ -86,7 +217,7 @@ func logAddUsedFieldAction(field string, before_byte []byte, after_byte []byte)
 		for _, f := range fields {
 			if field == f.Key {
 				final.buf.AppendBytes(before_byte)
-				final.buf.AppendBytes([]byte(f.String))
+				final.appendFieldValue(f)
 				final.buf.AppendBytes(after_byte)
 			}
 		}
@@ -97,9 +228,8 @@ func logAddRemindFieldAction(final *logbackEncoder, ent *zapcore.Entry, fields [
 
 	final.buf.AppendByte('{')
 	comma_need := false
-	for _, field := range fields {
-		_, exists := final.used_fields[field.Key]
-		if exists {
+	for i, field := range fields {
+		if final.isUsedField(i, field) {
 			continue
 		}
 		if comma_need {
@@ -112,107 +242,274 @@ func logAddRemindFieldAction(final *logbackEncoder, ent *zapcore.Entry, fields [
 	final.buf.AppendByte('}')
 }
 
-// log_format := `%date{2024-12-01 12:34:56.789} %level{upper} %caller ["tid":%x{tid}]} %message %fields`
-func Parse_compile_log_format(log_format string) LogbackConfig {
-	log_format_regex_pattern := regexp.MustCompile(`(?P<action>%\w+)(?P<config>\{.*?\})?(?P<remind>[^%]*)`)
+var (
+	_log_format_regex_pattern = regexp.MustCompile(`(?P<action>%\w+)(?P<config>\{.*?\})?(?P<remind>[^%]*)`)
+	// %date{%H:%M:%S,as=time} 或 %level{as=severity}
+	_as_option_regex_pattern = regexp.MustCompile(`(^|,)as=([^,]+)$`)
+)
 
+// PatternError is returned by Compile when the config of an action is
+// invalid.
+type PatternError struct {
+	// Offset is the byte offset of the action in the log format.
+	Offset int
+	// Action is the conversion word as written, e.g. "%date".
+	Action string
+	Err    error
+}
+
+func (e *PatternError) Error() string {
+	return e.Action + ": " + e.Err.Error()
+}
+
+func (e *PatternError) Unwrap() error {
+	return e.Err
+}
+
+// Compile parses a log format into a Pattern.
+//
+// log_format := `%date{2024-12-01 12:34:56.789} %level{upper} %caller ["tid":%x{tid}]} %message %fields`
+func Compile(log_format string) (*Pattern, error) {
 	named_idx := make(map[string]int)
-	for idx, name := range log_format_regex_pattern.SubexpNames() {
+	for idx, name := range _log_format_regex_pattern.SubexpNames() {
 		if idx == 0 {
 			continue
 		}
 		named_idx[name] = idx
 	}
 
-	all_matches := log_format_regex_pattern.FindAllSubmatch([]byte(log_format), -1)
+	all_matches := _log_format_regex_pattern.FindAllStringSubmatchIndex(log_format, -1)
 
-	config_regex_pattern := regexp.MustCompile(`\{(.*)\}`)
-	x_config_regex_pattern := regexp.MustCompile(`(\w+)(:(.*)(\$0)(.*))?`)
-	var logback_config LogbackConfig
-	action_ops := []logActionOperation{}
-	used_fields := make(map[string]EMPTY)
+	pattern := &Pattern{
+		source:      log_format,
+		used_fields: make(map[string]EMPTY),
+	}
+
+	// 第一个action之前的普通字符串
+	prefix_end := len(log_format)
+	if len(all_matches) > 0 {
+		prefix_end = all_matches[0][0]
+	}
+	pattern.addLiteral(0, log_format[:prefix_end])
+
+	submatch := func(m []int, name string) string {
+		idx := named_idx[name]
+		if m[2*idx] < 0 {
+			return ""
+		}
+		return log_format[m[2*idx]:m[2*idx+1]]
+	}
 
 	for _, m := range all_matches {
+		action := submatch(m, "action")
+		config := submatch(m, "config")
+		remind := submatch(m, "remind")
+
 		action_config := ""
+		if len(config) >= 2 {
+			action_config = config[1 : len(config)-1]
+		}
 
-		action := m[named_idx["action"]]
-		config := m[named_idx["config"]]
-		remind := m[named_idx["remind"]]
+		// as=key 只用于 JSON 格式, 不传给 action
+		key := ""
+		if as := _as_option_regex_pattern.FindStringSubmatchIndex(action_config); as != nil {
+			key = action_config[as[4]:as[5]]
+			action_config = action_config[:as[0]]
+		}
 
-		config_match := config_regex_pattern.FindSubmatch(config)
-		if len(config_match) > 0 {
-			action_config = string(config_match[1])
+		name := canonicalActionName(action[1:], action_config)
+		factory, ok := actionFactoryOf(name)
+		if !ok {
+			// 都当成是普通字符串处理
+			pattern.addLiteral(m[0], action+config+remind)
+			continue
 		}
 
-		switch string(action) {
-		case `%date`:
-			if len(action_config) > 0 {
-				// 自定义时间格式
-				logback_config.EncodeTime = TimeEncoderOf(action_config)
+		action_op, err := factory(action_config)
+		if err != nil {
+			return nil, &PatternError{Offset: m[0], Action: action, Err: err}
+		}
+		if action_op != nil {
+			pattern.elements = append(pattern.elements, PatternAction{
+				Name:   name,
+				Config: action_config,
+				Key:    key,
+				Offset: m[0],
+				Action: action_op,
+			})
+		}
+		if configurer, ok := action_op.(encoderConfigurer); ok {
+			configurer.configure(&pattern.logback_config)
+		}
+		if consumer, ok := action_op.(FieldsConsumer); ok {
+			for _, field := range consumer.UsedFields() {
+				pattern.used_fields[field] = empty_member
 			}
-			action_ops = append(action_ops, logAddTimeAction)
+		}
 
-		case `%level`:
-			if len(action_config) > 0 {
-				logback_config.EncodeLevel = LevelEncoderOf(action_config)
-			}
-			action_ops = append(action_ops, logAddLevelAction)
-		case `%caller`:
-			if len(action_config) > 0 {
-				logback_config.EncodeCaller = CallerEncoderOf(action_config)
-			}
+		pattern.addLiteral(m[1]-len(remind), remind)
+	}
 
-			action_ops = append(action_ops, logAddCallerAction)
-		case `%message`:
-			action_ops = append(action_ops, logAddMsgAction)
-		case `%x`:
-			if len(action_config) > 0 {
-				// 从fields 中取出自定义变量
-				adv_config := x_config_regex_pattern.FindSubmatch([]byte(action_config))
-				used_field_name := adv_config[1]
-				used_fields[string(used_field_name)] = empty_member
-				before_field_str := adv_config[3]
-				after_field_str := adv_config[5]
-
-				action_ops = append(action_ops, logAddUsedFieldAction(string(used_field_name), before_field_str, after_field_str))
-			}
-		case `%fields`:
-			action_ops = append(action_ops, logAddRemindFieldAction)
-		default:
-			// 都当成是普通字符串处理
-			remind = append(remind, action...)
-		}
+	// 末尾的 %n 与 encoder 追加的 LineEnding 重复, 同 logback 一样只输出一次
+	if last := len(pattern.elements) - 1; last >= 0 && pattern.elements[last].Name == "n" {
+		pattern.elements[last].Action = logActionOperation(logAddNothingAction)
+	}
 
-		if len(remind) >= 1 {
-			action_ops = append(action_ops, logAddBytesAction(remind))
-		}
+	linkJSONFingerprint(pattern.elements)
+
+	actions := make([]Action, len(pattern.elements))
+	for i, element := range pattern.elements {
+		actions[i] = element.Action
 	}
+	pattern.logback_config.actions = actions
+	pattern.logback_config.used_fields = pattern.used_fields
 
-	logback_config.actions = action_ops
-	logback_config.used_fields = used_fields
+	multiline, err := multilinePolicyOf(pattern.elements)
+	if err != nil {
+		return nil, err
+	}
+	pattern.logback_config.multiline = multiline
+
+	limits, err := limitsOf(pattern.elements)
+	if err != nil {
+		return nil, err
+	}
+	pattern.logback_config.limits = limits
+	pattern.logback_config.encrypt_fields = encryptedFieldsOf(pattern.elements)
 
-	return logback_config
+	return pattern, nil
+}
+
+// log_format := `%date{2024-12-01 12:34:56.789} %level{upper} %caller ["tid":%x{tid}]} %message %fields`
+// It panics if log_format is invalid, Compile returns the error instead.
+func Parse_compile_log_format(log_format string) LogbackConfig {
+	pattern, err := Compile(log_format)
+	if err != nil {
+		panic(err)
+	}
+	return pattern.logback_config
 }
 
 const _default_milesecond_zero_count = 3
 
+var (
+	// glog 风格的单字母级别
+	_letter_level_names = map[zapcore.Level]string{
+		zapcore.DebugLevel:  "D",
+		zapcore.InfoLevel:   "I",
+		zapcore.WarnLevel:   "W",
+		zapcore.ErrorLevel:  "E",
+		zapcore.DPanicLevel: "P",
+		zapcore.PanicLevel:  "P",
+		zapcore.FatalLevel:  "F",
+	}
+	// syslog severity, RFC 5424
+	_syslog_level_names = map[zapcore.Level]string{
+		zapcore.DebugLevel:  "7",
+		zapcore.InfoLevel:   "6",
+		zapcore.WarnLevel:   "4",
+		zapcore.ErrorLevel:  "3",
+		zapcore.DPanicLevel: "2",
+		zapcore.PanicLevel:  "1",
+		zapcore.FatalLevel:  "0",
+	}
+	// 中文级别名称
+	_zh_level_names = map[zapcore.Level]string{
+		zapcore.DebugLevel:  "调试",
+		zapcore.InfoLevel:   "信息",
+		zapcore.WarnLevel:   "警告",
+		zapcore.ErrorLevel:  "错误",
+		zapcore.DPanicLevel: "严重",
+		zapcore.PanicLevel:  "恐慌",
+		zapcore.FatalLevel:  "致命",
+	}
+)
+
+// LevelEncoderOf returns the level encoder for a %level config, the lower
+// case encoder for unknown configs, see ParseLevelEncoder.
 func LevelEncoderOf(level_type string) zapcore.LevelEncoder {
+	encode_level, err := ParseLevelEncoder(level_type)
+	if err != nil {
+		return zapcore.LowercaseLevelEncoder
+	}
+	return encode_level
+}
+
+// ParseLevelEncoder returns the level encoder for a %level config. Besides
+// zap's upper/capital/lower/color/capitalcolor it accepts letter, syslog,
+// zh and map=level:name,... where level is a zap level name or a number
+// for custom levels. Unknown configs are reported as errors.
+func ParseLevelEncoder(level_type string) (zapcore.LevelEncoder, error) {
 	switch level_type {
 	case "upper":
 		fallthrough
 	case "capital":
-		return zapcore.CapitalLevelEncoder
+		return zapcore.CapitalLevelEncoder, nil
 	case "capitalcolor":
-		return zapcore.CapitalColorLevelEncoder
+		return zapcore.CapitalColorLevelEncoder, nil
 	case "color":
-		return zapcore.LowercaseColorLevelEncoder
+		return zapcore.LowercaseColorLevelEncoder, nil
 	case "lower":
-		fallthrough
-	default:
-		return zapcore.LowercaseLevelEncoder
+		return zapcore.LowercaseLevelEncoder, nil
+	case "letter":
+		return levelEncoderOfNames(_letter_level_names), nil
+	case "syslog":
+		return levelEncoderOfNames(_syslog_level_names), nil
+	case "zh":
+		return levelEncoderOfNames(_zh_level_names), nil
+	}
+
+	if mapping, ok := strings.CutPrefix(level_type, "map="); ok {
+		names, err := parseLevelNames(mapping)
+		if err != nil {
+			return nil, err
+		}
+		return levelEncoderOfNames(names), nil
+	}
+	return nil, fmt.Errorf("unknown level config %q", level_type)
+}
+
+// levelEncoderOfNames 按照映射表输出级别, 不在表中的级别(如自定义级别)输出大写名称
+func levelEncoderOfNames(names map[zapcore.Level]string) zapcore.LevelEncoder {
+	return func(l zapcore.Level, enc zapcore.PrimitiveArrayEncoder) {
+		if name, ok := names[l]; ok {
+			enc.AppendString(name)
+			return
+		}
+		enc.AppendString(l.CapitalString())
 	}
 }
 
+// parseLevelNames parses "debug:DBG,info:INF,-2:TRACE".
+func parseLevelNames(mapping string) (map[zapcore.Level]string, error) {
+	names := make(map[zapcore.Level]string)
+	for _, entry := range strings.Split(mapping, ",") {
+		level_text, name, ok := strings.Cut(entry, ":")
+		level_text = strings.TrimSpace(level_text)
+		if !ok || level_text == "" {
+			return nil, fmt.Errorf("invalid level map entry %q", entry)
+		}
+		level, err := parseLevel(level_text)
+		if err != nil {
+			return nil, err
+		}
+		names[level] = name
+	}
+	return names, nil
+}
+
+// parseLevel accepts zap level names and numbers of custom levels.
+func parseLevel(text string) (zapcore.Level, error) {
+	if n, err := strconv.ParseInt(text, 10, 8); err == nil {
+		return zapcore.Level(n), nil
+	}
+	level, err := zapcore.ParseLevel(text)
+	if err != nil {
+		return level, fmt.Errorf("unknown level %q", text)
+	}
+	return level, nil
+}
+
 func CallerEncoderOf(caller_type string) zapcore.CallerEncoder {
 	switch caller_type {
 
@@ -248,12 +545,21 @@ func StrftimeFormatLayout(layout string) string {
 		`%z`:      "-0700",   // 时区（数字表示）
 		`%Z`:      "MST",     // 时区（英文表示）
 		`%j`:      "__2",     // 日 年的第几天
+		`%-d`:     "2",       // 日 不补零
+		`%-m`:     "1",       // 月 不补零
+		`%-I`:     "3",       // 时 12小时制 不补零
+		`%-M`:     "4",       // 分钟 不补零
+		`%-S`:     "5",       // 秒 不补零
 	}
 
 	for k, v := range strf_map {
 		pattern := regexp.MustCompile(k)
 		if k == `%(\d*)f` {
-			zero_count, err := strconv.Atoi(string(pattern.FindSubmatch([]byte(go_time_format))[1]))
+			f_match := pattern.FindStringSubmatch(go_time_format)
+			if f_match == nil {
+				continue
+			}
+			zero_count, err := strconv.Atoi(f_match[1])
 			if err != nil {
 				zero_count = _default_milesecond_zero_count
 			}
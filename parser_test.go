@@ -0,0 +1,229 @@
+package zaplogback
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func encodeForTest(t *testing.T, log_format string, ent zapcore.Entry, fields ...zapcore.Field) string {
+	t.Helper()
+	cfg := zap.NewProductionEncoderConfig()
+	cfg.SkipLineEnding = true
+	enc, err := BuildZaplogbackEncoder(cfg, log_format)
+	if err != nil {
+		t.Fatalf("BuildZaplogbackEncoder(%q): %v", log_format, err)
+	}
+	buf, err := enc.EncodeEntry(ent, fields)
+	if err != nil {
+		t.Fatalf("EncodeEntry: %v", err)
+	}
+	defer buf.Free()
+	return buf.String()
+}
+
+func TestLevelEncoderOf(t *testing.T) {
+	tests := []struct {
+		config string
+		level  zapcore.Level
+		want   string
+	}{
+		{"upper", zapcore.InfoLevel, "INFO"},
+		{"lower", zapcore.WarnLevel, "warn"},
+		{"letter", zapcore.ErrorLevel, "E"},
+		{"syslog", zapcore.DebugLevel, "7"},
+		{"zh", zapcore.WarnLevel, "警告"},
+		{"map=debug:DBG,info:INF", zapcore.InfoLevel, "INF"},
+		{"map=debug:DBG,info:INF", zapcore.ErrorLevel, "ERROR"},
+		{"map=-2:TRACE", zapcore.Level(-2), "TRACE"},
+	}
+	for _, tt := range tests {
+		got := encodeForTest(t, `%level{`+tt.config+`}`, zapcore.Entry{Level: tt.level})
+		if got != tt.want {
+			t.Errorf("%%level{%s} of %v = %q, want %q", tt.config, tt.level, got, tt.want)
+		}
+	}
+
+	for _, config := range []string{"bogus", "map=nope:X", "map=info"} {
+		if _, err := Compile(`%level{` + config + `}`); err == nil {
+			t.Errorf("%%level{%s}: expected an error", config)
+		}
+		if _, err := ParseLevelEncoder(config); err == nil {
+			t.Errorf("ParseLevelEncoder(%q): expected an error", config)
+		}
+	}
+	// 旧的签名: 未知的配置退回小写
+	enc := zapcore.NewMapObjectEncoder()
+	_ = enc.AddArray("l", zapcore.ArrayMarshalerFunc(func(arr zapcore.ArrayEncoder) error {
+		LevelEncoderOf("bogus")(zapcore.WarnLevel, arr)
+		return nil
+	}))
+	if got := enc.Fields["l"].([]interface{}); len(got) != 1 || got[0] != "warn" {
+		t.Errorf(`LevelEncoderOf("bogus") wrote %v, want [warn]`, got)
+	}
+}
+
+func TestNewZaplogbackEncoderPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("NewZaplogbackEncoder with an invalid format: expected a panic")
+		}
+	}()
+	NewZaplogbackEncoder(zap.NewProductionEncoderConfig(), `%level{bogus}`)
+}
+
+func TestLogbackAliases(t *testing.T) {
+	ent := zapcore.Entry{
+		Level:      zapcore.WarnLevel,
+		LoggerName: "com.example.service.Handler",
+		Message:    "hi",
+		Time:       time.Date(2024, 7, 6, 20, 32, 18, 335000000, time.UTC),
+		Caller:     zapcore.NewEntryCaller(0, "/src/app/handler.go", 42, true),
+		Stack:      "goroutine 1",
+	}
+	ent.Caller.Function = "github.com/example/app.(*Handler).Serve"
+
+	// without %ex the stacktrace is appended to the line
+	const stack = "\ngoroutine 1"
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{`%d{yyyy-MM-dd HH:mm:ss.SSS} %p %m`, `2024-07-06 20:32:18.335 warn hi` + stack},
+		{`%d{%H:%M} %le %msg`, `20:32 warn hi` + stack},
+		{`%d{d/M/yyyy h:m:s} %m`, `6/7/2024 8:32:18 hi` + stack},
+		{`%c{15} %lo %logger{0}`, `c.e.s.Handler com.example.service.Handler Handler` + stack},
+		{`%F:%L %M %file{full}`, `handler.go:42 (*Handler).Serve /src/app/handler.go` + stack},
+		{`[%X{tid}] %X`, `[t1] {"n":1}` + stack},
+		{`%m%n%ex|`, "hi\ngoroutine 1|"},
+		{`%m%n`, "hi" + stack},
+	}
+	for _, tt := range tests {
+		got := encodeForTest(t, tt.format, ent, zap.String("tid", "t1"), zap.Int("n", 1))
+		if got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.format, got, tt.want)
+		}
+	}
+
+	p, err := Compile(`%d %p %X{tid} %X %m%n`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := p.String(), `%date %level %x{tid} %fields %message%n`; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	if _, err := Compile(`%d{yyyy ww}`); err == nil {
+		t.Error("expected an error for an unsupported date pattern")
+	}
+}
+
+func TestSequenceActions(t *testing.T) {
+	cfg := zap.NewProductionEncoderConfig()
+	cfg.SkipLineEnding = true
+	newEncoder := func(format string) zapcore.Encoder {
+		enc, err := BuildZaplogbackEncoder(cfg, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return enc
+	}
+	encode := func(enc zapcore.Encoder, ent zapcore.Entry) string {
+		buf, err := enc.EncodeEntry(ent, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer buf.Free()
+		return buf.String()
+	}
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	// 克隆出的 encoder 共用同一个序号
+	enc := newEncoder(`%seq`)
+	clone := enc.Clone()
+	var got []string
+	for _, e := range []zapcore.Encoder{enc, clone, enc, clone.Clone()} {
+		got = append(got, encode(e, zapcore.Entry{}))
+	}
+	if want := []string{"1", "2", "3", "4"}; !slices.Equal(got, want) {
+		t.Errorf("%%seq = %v, want %v", got, want)
+	}
+	if got := encode(newEncoder(`%seq`), zapcore.Entry{}); got != "1" {
+		t.Errorf("%%seq of a new pattern = %s, want 1", got)
+	}
+
+	// %seq{global} 在所有 pattern 之间共享
+	a, b := newEncoder(`%seq{global}`), newEncoder(`a %seq{global}`)
+	first, _ := strconv.ParseUint(encode(a, zapcore.Entry{}), 10, 64)
+	if got, want := encode(b, zapcore.Entry{}), fmt.Sprintf("a %d", first+1); got != want {
+		t.Errorf("%%seq{global} = %s, want %s", got, want)
+	}
+
+	delta := newEncoder(`%delta`)
+	got = nil
+	for _, at := range []time.Duration{0, 1500 * time.Millisecond, 1750 * time.Millisecond, time.Second} {
+		got = append(got, encode(delta, zapcore.Entry{Time: start.Add(at)}))
+	}
+	if want := []string{"0", "1500", "250", "0"}; !slices.Equal(got, want) {
+		t.Errorf("%%delta = %v, want %v", got, want)
+	}
+
+	if got := encode(newEncoder(`%relative %r`), zapcore.Entry{Time: _process_start_time.Add(2500 * time.Millisecond)}); got != "2500 2500" {
+		t.Errorf("%%relative = %s, want 2500 2500", got)
+	}
+
+	for _, format := range []string{`%seq{foo}`, `%relative{x}`, `%delta{ms}`} {
+		if _, err := Compile(format); err == nil {
+			t.Errorf("%s: expected an error", format)
+		}
+	}
+}
+
+func TestSequenceConcurrent(t *testing.T) {
+	cfg := zap.NewProductionEncoderConfig()
+	cfg.SkipLineEnding = true
+	enc, err := BuildZaplogbackEncoder(cfg, `%seq %delta`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const goroutines, entries = 8, 100
+	seqs := make(chan string, goroutines*entries)
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(enc zapcore.Encoder) {
+			defer wg.Done()
+			for j := 0; j < entries; j++ {
+				buf, err := enc.EncodeEntry(zapcore.Entry{Time: time.Now()}, nil)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				seq, _, _ := strings.Cut(buf.String(), " ")
+				seqs <- seq
+				buf.Free()
+			}
+		}(enc.Clone())
+	}
+	wg.Wait()
+	close(seqs)
+
+	seen := map[string]bool{}
+	for seq := range seqs {
+		if seen[seq] {
+			t.Errorf("duplicate %%seq %s", seq)
+		}
+		seen[seq] = true
+	}
+	for i := 1; i <= goroutines*entries; i++ {
+		if !seen[strconv.Itoa(i)] {
+			t.Errorf("missing %%seq %d", i)
+		}
+	}
+}
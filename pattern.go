@@ -0,0 +1,149 @@
+package zaplogback
+
+import (
+	"sort"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// A Pattern is a compiled log format. It is immutable and safe to share, so
+// compile a format once and build as many encoders from it as needed.
+// Encoders built from the same Pattern share the state of %seq and %delta.
+type Pattern struct {
+	source         string
+	elements       []PatternAction
+	used_fields    map[string]EMPTY
+	logback_config LogbackConfig
+}
+
+// PatternAction is one element of a Pattern. Literal text between actions,
+// including unknown %words, is a PatternAction with an empty Name.
+type PatternAction struct {
+	// Name is the conversion word without '%', e.g. "date".
+	Name string
+	// Config is the text between the braces of %name{config}.
+	Config string
+	// Literal is the text of a literal element.
+	Literal string
+	// Key is the as= option of the config, the key of the action in the JSON
+	// layout, see Pattern.NewJSONEncoder.
+	Key string
+	// Offset is the byte offset of the element in the source format.
+	Offset int
+	Action Action
+}
+
+// IsLiteral reports whether the element is literal text.
+func (a PatternAction) IsLiteral() bool {
+	return a.Name == ""
+}
+
+func (p *Pattern) addLiteral(offset int, literal string) {
+	if literal == "" {
+		return
+	}
+	// 合并相邻的普通字符串
+	if last := len(p.elements) - 1; last >= 0 && p.elements[last].IsLiteral() {
+		literal = p.elements[last].Literal + literal
+		offset = p.elements[last].Offset
+		p.elements = p.elements[:last]
+	}
+	p.elements = append(p.elements, PatternAction{
+		Literal: literal,
+		Offset:  offset,
+		Action:  logAddBytesAction([]byte(literal)),
+	})
+}
+
+// Actions returns the elements of the pattern in output order.
+func (p *Pattern) Actions() []PatternAction {
+	actions := make([]PatternAction, len(p.elements))
+	copy(actions, p.elements)
+	return actions
+}
+
+// UsedFields returns the sorted names of the fields written by actions such
+// as %x, which are left out of %fields.
+func (p *Pattern) UsedFields() []string {
+	fields := make([]string, 0, len(p.used_fields))
+	for field := range p.used_fields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// Source returns the log format the pattern was compiled from.
+func (p *Pattern) Source() string {
+	return p.source
+}
+
+// String returns the canonical form of the pattern, which compiles to an
+// equivalent Pattern. Empty configs are dropped, e.g. `%date{}` becomes
+// `%date`.
+func (p *Pattern) String() string {
+	return FormatActions(p.elements)
+}
+
+// FormatActions returns the log format of actions, such as the Actions of a
+// Pattern with some configs changed; it is the String of their Pattern.
+func FormatActions(actions []PatternAction) string {
+	var sb strings.Builder
+	for i, element := range actions {
+		if element.IsLiteral() {
+			sb.WriteString(element.Literal)
+			continue
+		}
+		sb.WriteByte('%')
+		sb.WriteString(element.Name)
+		config := element.Config
+		if element.Key != "" {
+			if config != "" {
+				config += ","
+			}
+			config += "as=" + element.Key
+		}
+		if config != "" || i+1 < len(actions) && keepsEmptyConfig(actions[i+1]) {
+			sb.WriteByte('{')
+			sb.WriteString(config)
+			sb.WriteByte('}')
+		}
+	}
+	return sb.String()
+}
+
+// keepsEmptyConfig reports whether the action before next must keep its
+// empty config: "%date{}{...}" would otherwise read the literal as the
+// config, and "%message{}x" as %messagex.
+func keepsEmptyConfig(next PatternAction) bool {
+	if !next.IsLiteral() || next.Literal == "" {
+		return false
+	}
+	c := next.Literal[0]
+	return c == '{' || c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// NewEncoder builds an encoder that writes entries with the pattern,
+// %message without escape= uses DefaultMessageEscape.
+func (p *Pattern) NewEncoder(cfg zapcore.EncoderConfig) zapcore.Encoder {
+	encoder := newZaplogbackEncoder(cfg)
+	encoder.usePattern(p)
+	return encoder.encoder()
+}
+
+// encoderConfigOf returns a copy of cfg with the overrides of the pattern
+// applied, e.g. the EncodeTime of %date{...}.
+func (p *Pattern) encoderConfigOf(cfg *zapcore.EncoderConfig) *zapcore.EncoderConfig {
+	derived := *cfg
+	if p.logback_config.EncodeTime != nil {
+		derived.EncodeTime = p.logback_config.EncodeTime
+	}
+	if p.logback_config.EncodeLevel != nil {
+		derived.EncodeLevel = p.logback_config.EncodeLevel
+	}
+	if p.logback_config.EncodeCaller != nil {
+		derived.EncodeCaller = p.logback_config.EncodeCaller
+	}
+	return &derived
+}
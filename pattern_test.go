@@ -0,0 +1,98 @@
+package zaplogback
+
+import (
+	"errors"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestPatternString(t *testing.T) {
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{`%date{%Y-%m-%d} %level{upper} %message %fields`, `%date{%Y-%m-%d} %level{upper} %message %fields`},
+		{`[%date{}] %message`, `[%date] %message`},
+		{`%date{}{x} %message`, `%date{}{x} %message`},
+		{`%unknown{a} %message`, `%unknown{a} %message`},
+		{`%message{}x %level{}_1 %logger{}-`, `%message{}x %level{}_1 %logger-`},
+		{`%x{tid,as=trace}ms`, `%x{tid,as=trace}ms`},
+	}
+	for _, tt := range tests {
+		p, err := Compile(tt.format)
+		if err != nil {
+			t.Fatalf("Compile(%q): %v", tt.format, err)
+		}
+		if got := p.String(); got != tt.want {
+			t.Errorf("Compile(%q).String() = %q, want %q", tt.format, got, tt.want)
+		}
+		again, err := Compile(p.String())
+		if err != nil {
+			t.Fatalf("Compile(%q): %v", p.String(), err)
+		}
+		if again.String() != p.String() {
+			t.Errorf("String() of %q is not stable: %q", tt.format, again.String())
+		}
+		if !slices.Equal(actionNames(again), actionNames(p)) {
+			t.Errorf("String() of %q compiles to the actions %v, want %v", tt.format, actionNames(again), actionNames(p))
+		}
+	}
+}
+
+func actionNames(p *Pattern) []string {
+	var names []string
+	for _, action := range p.Actions() {
+		if !action.IsLiteral() {
+			names = append(names, action.Name)
+		}
+	}
+	return names
+}
+
+func TestPatternIntrospection(t *testing.T) {
+	p, err := Compile(`%level %x{tid:[$0]} %x{uid} %message`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := p.UsedFields(), []string{"tid", "uid"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("UsedFields() = %v, want %v", got, want)
+	}
+
+	names := []string{}
+	for _, a := range p.Actions() {
+		if a.IsLiteral() {
+			names = append(names, "'"+a.Literal+"'")
+		} else {
+			names = append(names, a.Name)
+		}
+	}
+	want := []string{"level", "' '", "x", "' '", "x", "' '", "message"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("Actions() = %v, want %v", names, want)
+	}
+}
+
+func TestCompileOffsets(t *testing.T) {
+	pattern, err := Compile(`[%date] %level{upper} %foo %message`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var offsets []int
+	for _, element := range pattern.Actions() {
+		offsets = append(offsets, element.Offset)
+	}
+	// "[", %date, "] ", %level, " %foo ", %message
+	if want := []int{0, 1, 6, 8, 21, 27}; !slices.Equal(offsets, want) {
+		t.Errorf("offsets %v, want %v", offsets, want)
+	}
+
+	_, err = Compile(`%message %date{%Y} %level{nope}`)
+	var pattern_err *PatternError
+	if !errors.As(err, &pattern_err) || pattern_err.Offset != 19 || pattern_err.Action != "%level" {
+		t.Errorf("got %#v", err)
+	}
+	if err.Error() != `%level: unknown level config "nope"` {
+		t.Errorf("got %q", err.Error())
+	}
+}
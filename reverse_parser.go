@@ -0,0 +1,615 @@
+package zaplogback
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// A Record is a log entry read back from text written with a pattern.
+type Record struct {
+	// LineNumber is the line of the record in the input, starting at 1.
+	LineNumber int
+	// Raw is the text of the record, including continuation lines.
+	Raw string
+
+	Time       time.Time
+	Level      zapcore.Level
+	LoggerName string
+	// Caller is the text of %caller, File, Line and Function are filled from
+	// %caller, %file, %line and %method.
+	Caller   string
+	File     string
+	Line     int
+	Function string
+	Message  string
+	// Fields are the fields of %x and %fields in output order. Values are
+	// unescaped but otherwise kept as written.
+	Fields []RecordField
+	// Stack is the text of %stacktrace plus the continuation lines.
+	Stack string
+	// Values is the text written by every action, by action name. Custom
+	// actions are only available here.
+	Values map[string]string
+}
+
+// RecordField is a key and the text of its value.
+type RecordField struct {
+	Key   string
+	Value string
+}
+
+// Field returns the value of the first field named key.
+func (r *Record) Field(key string) (string, bool) {
+	for _, f := range r.Fields {
+		if f.Key == key {
+			return f.Value, true
+		}
+	}
+	return "", false
+}
+
+// UnmatchedLine is a line that is neither a record nor a continuation line.
+type UnmatchedLine struct {
+	LineNumber int
+	Text       string
+}
+
+// A Parser reads back the lines an encoder wrote with the same pattern and
+// EncoderConfig. Since the output is meant for humans, parsing is best
+// effort: %message is matched lazily, %date without config is assumed to be
+// a single token (ISO8601, RFC3339 or epoch), and string field values are
+// written without quotes, so a value containing `,"key":` is ambiguous.
+type Parser struct {
+	pattern  *Pattern
+	re       *regexp.Regexp
+	captures []parserCapture
+	// lines of a record header, more than 1 when the pattern contains %n
+	header_lines int
+	// Location of times written without a zone, time.Local by default.
+	Location *time.Location
+}
+
+type parserCapture struct {
+	name  string
+	group int
+	// %date 的 Go layout, 为空时尝试常见格式
+	time_layout string
+	level_names map[string]zapcore.Level
+	// %x 和 %error 的字段名
+	field string
+	// %message 的转义方式, json 和 quote 可以还原
+	message_escape string
+}
+
+// NewParser compiles log_format and builds a Parser for it. cfg is the
+// EncoderConfig of the encoder that wrote the lines; it is used for the
+// actions without config, e.g. %level uses cfg.EncodeLevel.
+func NewParser(log_format string, cfg zapcore.EncoderConfig) (*Parser, error) {
+	pattern, err := Compile(log_format)
+	if err != nil {
+		return nil, err
+	}
+	return newParser(pattern, cfg)
+}
+
+func newParser(pattern *Pattern, cfg zapcore.EncoderConfig) (*Parser, error) {
+	p := &Parser{pattern: pattern, header_lines: 1, Location: time.Local}
+	cfg = *pattern.encoderConfigOf(&cfg)
+	if cfg.LineEnding == "" {
+		cfg.LineEnding = "\n"
+	}
+
+	var expr strings.Builder
+	expr.WriteString("^")
+	group := 0
+	capture := func(name string, sub string) *parserCapture {
+		group++
+		expr.WriteString("(" + sub + ")")
+		p.captures = append(p.captures, parserCapture{name: name, group: group})
+		return &p.captures[len(p.captures)-1]
+	}
+
+	elements := pattern.elements
+	for i, element := range elements {
+		last := i == len(elements)-1
+		lazy := ".*?"
+		if last && pattern.logback_config.chain == nil {
+			lazy = ".*"
+		}
+
+		if element.IsLiteral() {
+			p.header_lines += strings.Count(element.Literal, "\n")
+			expr.WriteString(regexp.QuoteMeta(element.Literal))
+			continue
+		}
+
+		switch element.Name {
+		case "date":
+			if element.Config == "" {
+				capture("date", `\S+`)
+				continue
+			}
+			layout, err := StrftimeLayoutOf(element.Config)
+			if err != nil {
+				return nil, err
+			}
+			c := capture("date", strftimeRegex(layout))
+			c.time_layout = StrftimeFormatLayout(layout)
+		case "level":
+			encode_level := cfg.EncodeLevel
+			if element.Config != "" {
+				encode_level = LevelEncoderOf(element.Config)
+			}
+			names := levelNamesOf(encode_level, element.Config)
+			alternatives := make([]string, 0, len(names)+1)
+			for name := range names {
+				alternatives = append(alternatives, regexp.QuoteMeta(name))
+			}
+			// 长的优先, 避免前缀匹配
+			sort.Slice(alternatives, func(i, j int) bool { return len(alternatives[i]) > len(alternatives[j]) })
+			alternatives = append(alternatives, `\S+`)
+			c := capture("level", strings.Join(alternatives, "|"))
+			c.level_names = names
+		case "caller", "file", "method":
+			capture(element.Name, `\S*`)
+		case "line", "relative", "delta", "seq":
+			capture(element.Name, `\d*`)
+		case "logger":
+			capture("logger", `\S*`)
+		case "message":
+			escape, _, _ := parseMessageConfig(element.Config)
+			if escape == MessageEscapeQuote {
+				capture("message", `"(?:[^"\\]|\\.)*"`).message_escape = escape
+			} else {
+				capture("message", lazy).message_escape = escape
+			}
+		case "x":
+			m := _x_config_regex_pattern.FindStringSubmatch(element.Config)
+			expr.WriteString("(?:" + regexp.QuoteMeta(m[3]))
+			c := capture("x", lazy)
+			expr.WriteString(regexp.QuoteMeta(m[5]) + ")?")
+			c.field = m[1]
+		case "fields":
+			capture("fields", `\{.*\}`)
+		case "error":
+			c := capture("error", lazy)
+			c.field = "error"
+			if a, ok := element.Action.(errorAction); ok && a.key != "" {
+				c.field = a.key
+			}
+		case "n":
+			if !last {
+				p.header_lines += strings.Count(cfg.LineEnding, "\n")
+				expr.WriteString(regexp.QuoteMeta(cfg.LineEnding))
+			}
+		case "stacktrace":
+			capture("stacktrace", lazy)
+		case "fingerprint":
+			capture("fingerprint", `[a-z2-7]*`)
+		case "chain":
+			// MAC 由 ChainWriter 追加在行尾, 见下
+		default:
+			capture(element.Name, lazy)
+		}
+	}
+	if pattern.logback_config.chain != nil {
+		expr.WriteString("(?: chain=[0-9a-f]{32})?")
+	}
+	expr.WriteString("$")
+
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, fmt.Errorf("build matcher: %w", err)
+	}
+	p.re = re
+	return p, nil
+}
+
+// levelNamesOf runs a level encoder over the zap levels, and the custom
+// levels of a map= config, to learn the text of each level.
+func levelNamesOf(encode_level zapcore.LevelEncoder, config string) map[string]zapcore.Level {
+	levels := []zapcore.Level{
+		zapcore.DebugLevel, zapcore.InfoLevel, zapcore.WarnLevel, zapcore.ErrorLevel,
+		zapcore.DPanicLevel, zapcore.PanicLevel, zapcore.FatalLevel,
+	}
+	if mapping, ok := strings.CutPrefix(config, "map="); ok {
+		if names, err := parseLevelNames(mapping); err == nil {
+			for level := range names {
+				levels = append(levels, level)
+			}
+		}
+	}
+	if encode_level == nil {
+		encode_level = zapcore.LowercaseLevelEncoder
+	}
+
+	names := make(map[string]zapcore.Level)
+	enc := newZaplogbackEncoder(zapcore.EncoderConfig{})
+	for _, level := range levels {
+		enc.buf.Reset()
+		encode_level(level, rawStringEncoder{enc})
+		// 多个级别同名时(如 letter 中的 P)保留较低的级别
+		if _, dup := names[enc.buf.String()]; !dup && enc.buf.Len() > 0 {
+			names[enc.buf.String()] = level
+		}
+	}
+	enc.buf.Free()
+	return names
+}
+
+// strftimeRegex builds a regular expression matching the output of a
+// strftime layout.
+func strftimeRegex(layout string) string {
+	var expr strings.Builder
+	for i := 0; i < len(layout); i++ {
+		if layout[i] != '%' || i+1 >= len(layout) {
+			expr.WriteString(regexp.QuoteMeta(layout[i : i+1]))
+			continue
+		}
+		// %3f
+		j := i + 1
+		for j < len(layout) && layout[j] >= '0' && layout[j] <= '9' {
+			j++
+		}
+		if j < len(layout) && layout[j] == 'f' {
+			digits := _default_milesecond_zero_count
+			if n, err := strconv.Atoi(layout[i+1 : j]); err == nil {
+				digits = n
+			}
+			expr.WriteString(`\d{` + strconv.Itoa(digits) + `}`)
+			i = j
+			continue
+		}
+
+		// %-d 等不补零的数字
+		if layout[i+1] == '-' && i+2 < len(layout) && strings.IndexByte("dmIMS", layout[i+2]) >= 0 {
+			expr.WriteString(`\d{1,2}`)
+			i += 2
+			continue
+		}
+
+		switch layout[i+1] {
+		case 'Y':
+			expr.WriteString(`\d{4}`)
+		case 'y', 'm', 'd', 'H', 'I', 'M', 'S':
+			expr.WriteString(`\d{2}`)
+		case 'w':
+			expr.WriteString(`[ \d]\d`)
+		case 'j':
+			expr.WriteString(`[ \d]{2}\d`)
+		case 'a', 'b':
+			expr.WriteString(`[A-Za-z]{3}`)
+		case 'A', 'B':
+			expr.WriteString(`[A-Za-z]+`)
+		case 'p':
+			expr.WriteString(`[AP]M`)
+		case 'z':
+			expr.WriteString(`[+-]\d{4}`)
+		case 'Z':
+			expr.WriteString(`(?:[A-Za-z]+|[+-]\d{2,4})`)
+		default:
+			expr.WriteString(regexp.QuoteMeta(layout[i : i+2]))
+		}
+		i++
+	}
+	return expr.String()
+}
+
+// _fallback_time_layouts are tried for %date without config.
+var _fallback_time_layouts = []string{
+	"2006-01-02T15:04:05.000Z0700",
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05Z0700",
+}
+
+func (p *Parser) parseTime(c *parserCapture, text string) (time.Time, bool) {
+	if c.time_layout != "" {
+		t, err := time.ParseInLocation(c.time_layout, text, p.Location)
+		return t, err == nil
+	}
+	for _, layout := range _fallback_time_layouts {
+		if t, err := time.ParseInLocation(layout, text, p.Location); err == nil {
+			return t, true
+		}
+	}
+	// zap 的 EpochTimeEncoder 等
+	if f, err := strconv.ParseFloat(text, 64); err == nil {
+		sec := int64(f)
+		return time.Unix(sec, int64((f-float64(sec))*1e9)).In(p.Location), true
+	}
+	return time.Time{}, false
+}
+
+func parseLevelText(c *parserCapture, text string) zapcore.Level {
+	if level, ok := c.level_names[text]; ok {
+		return level
+	}
+	if level, err := zapcore.ParseLevel(strings.ToLower(text)); err == nil {
+		return level
+	}
+	return zapcore.InvalidLevel
+}
+
+// ParseRecord parses the header of a record, which spans HeaderLines lines
+// joined by '\n'. It reports whether text matches the pattern.
+func (p *Parser) ParseRecord(text string) (Record, bool) {
+	m := p.re.FindStringSubmatch(text)
+	if m == nil {
+		return Record{}, false
+	}
+
+	r := Record{Raw: text, Level: zapcore.InvalidLevel, Values: make(map[string]string)}
+	for i := range p.captures {
+		c := &p.captures[i]
+		value := m[c.group]
+		if _, dup := r.Values[c.name]; !dup {
+			r.Values[c.name] = value
+		}
+
+		switch c.name {
+		case "date":
+			if t, ok := p.parseTime(c, value); ok {
+				r.Time = t
+			}
+		case "level":
+			r.Level = parseLevelText(c, value)
+		case "logger":
+			r.LoggerName = value
+		case "caller":
+			r.Caller = value
+			if colon := strings.LastIndexByte(value, ':'); colon >= 0 {
+				r.File = value[:colon]
+				r.Line, _ = strconv.Atoi(value[colon+1:])
+			}
+		case "file":
+			r.File = value
+		case "line":
+			r.Line, _ = strconv.Atoi(value)
+		case "method":
+			r.Function = value
+		case "message":
+			r.Message = unescapeMessage(c.message_escape, value)
+		case "x":
+			if value != "" {
+				r.Fields = append(r.Fields, RecordField{Key: c.field, Value: value})
+			}
+		case "fields":
+			r.Fields = append(r.Fields, parseFieldsText(value)...)
+		case "error":
+			if value != "" {
+				r.Fields = append(r.Fields, RecordField{Key: c.field, Value: value})
+			}
+		case "stacktrace":
+			r.Stack = value
+		}
+	}
+	return r, true
+}
+
+// unescapeMessage reverts the json and quote escapes of %message, the
+// others can not be reverted.
+func unescapeMessage(escape string, value string) string {
+	quoted := value
+	switch escape {
+	case MessageEscapeJSON:
+		quoted = `"` + value + `"`
+	case MessageEscapeQuote:
+	default:
+		return value
+	}
+	var msg string
+	if err := json.Unmarshal([]byte(quoted), &msg); err != nil {
+		return value
+	}
+	return msg
+}
+
+// HeaderLines is the number of lines of a record header, more than 1 when
+// the pattern contains %n.
+func (p *Parser) HeaderLines() int {
+	return p.header_lines
+}
+
+// parseFieldsText parses the output of %fields, e.g. {"a":1,"b":text}.
+// Values end at the next `,"key":` or at the closing brace, skipping
+// nested objects and arrays.
+func parseFieldsText(text string) []RecordField {
+	text = strings.TrimSuffix(strings.TrimPrefix(text, "{"), "}")
+	var fields []RecordField
+	for len(text) > 0 {
+		key, rest, ok := cutQuoted(text)
+		if !ok || !strings.HasPrefix(rest, ":") {
+			break
+		}
+		rest = rest[1:]
+
+		end := fieldValueEnd(rest)
+		fields = append(fields, RecordField{Key: key, Value: unescapeFieldValue(rest[:end])})
+		text = strings.TrimPrefix(rest[end:], ",")
+	}
+	return fields
+}
+
+// cutQuoted reads the JSON string at the start of s.
+func cutQuoted(s string) (string, string, bool) {
+	if !strings.HasPrefix(s, `"`) {
+		return "", s, false
+	}
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			value, err := strconv.Unquote(s[:i+1])
+			if err != nil {
+				value = s[1:i]
+			}
+			return value, s[i+1:], true
+		}
+	}
+	return "", s, false
+}
+
+func fieldValueEnd(s string) int {
+	depth := 0
+	in_string := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case in_string:
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				in_string = false
+			}
+		case c == '"' && depth > 0:
+			in_string = true
+		case c == '{' || c == '[':
+			depth++
+		case c == '}' || c == ']':
+			if depth > 0 {
+				depth--
+			}
+		case c == ',' && depth == 0:
+			// 下一个 "key":
+			if _, rest, ok := cutQuoted(s[i+1:]); ok && strings.HasPrefix(rest, ":") {
+				return i
+			}
+		}
+	}
+	return len(s)
+}
+
+func unescapeFieldValue(value string) string {
+	if !strings.Contains(value, `\`) || strings.HasPrefix(value, "{") || strings.HasPrefix(value, "[") {
+		return value
+	}
+	if unquoted, err := strconv.Unquote(`"` + value + `"`); err == nil {
+		return unquoted
+	}
+	return value
+}
+
+// A RecordScanner reads records from text written with a pattern. Lines
+// following a record that do not start a new one are continuation lines,
+// e.g. a stacktrace, and are appended to Record.Stack. Other lines, such as
+// the lines before the first record, are reported by Unmatched.
+type RecordScanner struct {
+	parser    *Parser
+	lines     *bufio.Scanner
+	line_no   int
+	pending   []string
+	current   *Record
+	next      *Record
+	unmatched []UnmatchedLine
+	err       error
+}
+
+// NewScanner returns a RecordScanner reading from r.
+func (p *Parser) NewScanner(r io.Reader) *RecordScanner {
+	lines := bufio.NewScanner(r)
+	lines.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	return &RecordScanner{parser: p, lines: lines}
+}
+
+// readLine returns the next line, from the lookahead first.
+func (s *RecordScanner) readLine() (string, bool) {
+	if len(s.pending) > 0 {
+		line := s.pending[0]
+		s.pending = s.pending[1:]
+		return line, true
+	}
+	if !s.lines.Scan() {
+		s.err = s.lines.Err()
+		return "", false
+	}
+	return strings.TrimSuffix(s.lines.Text(), "\r"), true
+}
+
+// Scan advances to the next record.
+func (s *RecordScanner) Scan() bool {
+	s.current = s.next
+	s.next = nil
+	for {
+		line, ok := s.readLine()
+		if !ok {
+			return s.current != nil
+		}
+		s.line_no++
+		line_no := s.line_no
+
+		header := []string{line}
+		for len(header) < s.parser.header_lines {
+			next, ok := s.readLine()
+			if !ok {
+				break
+			}
+			header = append(header, next)
+		}
+
+		if record, ok := s.parser.ParseRecord(strings.Join(header, "\n")); ok {
+			s.line_no += len(header) - 1
+			record.LineNumber = line_no
+			if s.current == nil {
+				s.current = &record
+				continue
+			}
+			s.next = &record
+			return true
+		}
+		// 只消费第一行, 其余行放回
+		s.pending = append(header[1:], s.pending...)
+
+		if s.current != nil && IsContinuationLine(line) {
+			if s.current.Stack != "" {
+				s.current.Stack += "\n"
+			}
+			s.current.Stack += line
+			s.current.Raw += "\n" + line
+			continue
+		}
+		s.unmatched = append(s.unmatched, UnmatchedLine{LineNumber: line_no, Text: line})
+	}
+}
+
+// IsContinuationLine reports whether a line looks like part of a zap
+// stacktrace or an indented continuation, the rule of RecordScanner for the
+// lines that follow a record.
+func IsContinuationLine(line string) bool {
+	if line == "" {
+		return false
+	}
+	if line[0] == ' ' || line[0] == '\t' || strings.HasPrefix(line, "goroutine ") {
+		return true
+	}
+	// 函数名, 如 main.main 或 github.com/x/y.(*T).Method
+	return !strings.ContainsAny(line, " \t") && strings.Contains(line, ".")
+}
+
+// Record returns the record read by the last Scan.
+func (s *RecordScanner) Record() Record {
+	if s.current == nil {
+		return Record{}
+	}
+	return *s.current
+}
+
+// Unmatched returns the lines that matched neither a record nor a
+// continuation so far.
+func (s *RecordScanner) Unmatched() []UnmatchedLine {
+	return s.unmatched
+}
+
+// Err returns the first read error.
+func (s *RecordScanner) Err() error {
+	return s.err
+}
@@ -0,0 +1,145 @@
+package zaplogback
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestParserRoundTrip(t *testing.T) {
+	const log_format = `%date{%Y-%m-%d %H:%M:%S.%3f} %level{upper} %caller %x{tid:[tid=$0]} %message %fields`
+	cfg := zap.NewProductionEncoderConfig()
+	enc, err := BuildZaplogbackEncoder(cfg, log_format)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := time.Date(2024, 7, 6, 20, 32, 18, 335000000, time.Local)
+	entries := []struct {
+		ent    zapcore.Entry
+		fields []zapcore.Field
+	}{
+		{zapcore.Entry{Time: ts, Level: zapcore.InfoLevel, Message: "user logged in",
+			Caller: zapcore.NewEntryCaller(0, "/src/app/main.go", 12, true)},
+			[]zapcore.Field{zap.String("tid", "abc"), zap.Int("uid", 7), zap.String("note", "a, \"b\"")}},
+		{zapcore.Entry{Time: ts.Add(time.Second), Level: zapcore.ErrorLevel, Message: "failed",
+			Caller: zapcore.NewEntryCaller(0, "/src/app/db.go", 40, true),
+			Stack:  "main.main\n\t/src/app/main.go:12"},
+			[]zapcore.Field{zap.Ints("rows", []int{1, 2})}},
+	}
+
+	var text strings.Builder
+	text.WriteString("garbage before the first record\n")
+	for _, e := range entries {
+		buf, err := enc.EncodeEntry(e.ent, e.fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		text.Write(buf.Bytes())
+		buf.Free()
+	}
+
+	p, err := NewParser(log_format, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := p.NewScanner(strings.NewReader(text.String()))
+	var records []Record
+	for s.Scan() {
+		records = append(records, s.Record())
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d records: %+v", len(records), records)
+	}
+	if u := s.Unmatched(); len(u) != 1 || u[0].LineNumber != 1 {
+		t.Errorf("Unmatched() = %+v", u)
+	}
+
+	r := records[0]
+	if !r.Time.Equal(ts) || r.Level != zapcore.InfoLevel || r.Message != "user logged in" ||
+		r.File != "app/main.go" || r.Line != 12 || r.LineNumber != 2 {
+		t.Errorf("record 0 = %+v", r)
+	}
+	for key, want := range map[string]string{"tid": "abc", "uid": "7", "note": `a, "b"`} {
+		if got, _ := r.Field(key); got != want {
+			t.Errorf("field %s = %q, want %q", key, got, want)
+		}
+	}
+
+	r = records[1]
+	if r.Level != zapcore.ErrorLevel || r.Stack != entries[1].ent.Stack || r.LineNumber != 3 {
+		t.Errorf("record 1 = %+v", r)
+	}
+	if got, _ := r.Field("rows"); got != `[1 2]` {
+		t.Errorf("field rows = %q", got)
+	}
+	if _, ok := r.Field("tid"); ok {
+		t.Errorf("a missing %%x field should not be reported")
+	}
+}
+
+func TestParserLevelNames(t *testing.T) {
+	for _, config := range []string{"letter", "zh", "syslog", "map=info:INF,-2:TRACE", "capitalcolor"} {
+		log_format := `%level{` + config + `} %message`
+		p, err := NewParser(log_format, zap.NewProductionEncoderConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, level := range []zapcore.Level{zapcore.InfoLevel, zapcore.WarnLevel} {
+			line := strings.TrimSuffix(encodeForTest(t, log_format, zapcore.Entry{Level: level, Message: "m"}), "\n")
+			r, ok := p.ParseRecord(line)
+			if !ok || r.Level != level || r.Message != "m" {
+				t.Errorf("%s: ParseRecord(%q) = %v %v", config, line, r.Level, ok)
+			}
+		}
+	}
+}
+
+func TestParserMultiLineHeader(t *testing.T) {
+	p, err := NewParser(`%level%n  %message`, zap.NewProductionEncoderConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := p.NewScanner(strings.NewReader("info\n  first\nwarn\n  second\n"))
+	var messages []string
+	for s.Scan() {
+		messages = append(messages, s.Record().Message)
+	}
+	if strings.Join(messages, ",") != "first,second" || p.HeaderLines() != 2 {
+		t.Errorf("messages = %v", messages)
+	}
+}
+
+func TestIsContinuationLine(t *testing.T) {
+	for line, want := range map[string]bool{
+		"\tmain.go:12":                        true,
+		"  at handler":                        true,
+		"goroutine 1 [running]:":              true,
+		"github.com/x/y.(*T).Method":          true,
+		"main.main()":                         true,
+		"":                                    false,
+		"2024-05-01 10:00:00 info next entry": false,
+		"plain":                               false,
+	} {
+		if got := IsContinuationLine(line); got != want {
+			t.Errorf("IsContinuationLine(%q) = %v, want %v", line, got, want)
+		}
+	}
+}
+
+func TestParserUnpaddedDate(t *testing.T) {
+	p, err := NewParser(`%date{d/M/yyyy h:m:s a} %message`, zap.NewProductionEncoderConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, ok := p.ParseRecord("6/7/2024 8:32:18 PM hi")
+	if want := time.Date(2024, 7, 6, 20, 32, 18, 0, time.Local); !ok || !r.Time.Equal(want) || r.Message != "hi" {
+		t.Errorf("ParseRecord = %v %q, %v", r.Time, r.Message, ok)
+	}
+}
@@ -0,0 +1,201 @@
+package zaplogback
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/SheldonXLD/zaplogback/internal/bufferpool"
+)
+
+// _max_cached_templates bounds the template cache, messages built with
+// fmt.Sprintf would otherwise grow it without end; the templates beyond it
+// are parsed on each entry.
+const _max_cached_templates = 4096
+
+var (
+	_template_name_regex = regexp.MustCompile(`^[@$]?([\w.\-]+)$`)
+
+	_template_cache      sync.Map
+	_template_cache_size atomic.Int64
+)
+
+// templatePart is a literal text, or the placeholder of field when field is
+// not empty.
+type templatePart struct {
+	text  string
+	field string
+}
+
+// messageTemplate is a parsed message such as "user {uid} paid {amount}".
+type messageTemplate struct {
+	parts []templatePart
+}
+
+func (t *messageTemplate) hasPlaceholder() bool {
+	return slices.ContainsFunc(t.parts, func(part templatePart) bool { return part.field != "" })
+}
+
+// messageTemplateOf returns the parsed template of msg, nil when msg has no
+// braces. Templates with a placeholder are parsed once and cached, messages
+// repeat on every call of the same log statement; other messages with
+// braces, such as JSON texts, would only fill the cache.
+func messageTemplateOf(msg string) *messageTemplate {
+	if !strings.ContainsAny(msg, "{}") {
+		return nil
+	}
+	if cached, ok := _template_cache.Load(msg); ok {
+		return cached.(*messageTemplate)
+	}
+	t := parseMessageTemplate(msg)
+	if t.hasPlaceholder() && _template_cache_size.Load() < _max_cached_templates {
+		if _, loaded := _template_cache.LoadOrStore(msg, t); !loaded {
+			_template_cache_size.Add(1)
+		}
+	}
+	return t
+}
+
+// parseMessageTemplate splits msg into texts and {name} placeholders, like
+// Serilog "{{" and "}}" are literal braces and the @ and $ of {@name} and
+// {$name} are ignored. Braces that do not enclose a name stay as they are.
+func parseMessageTemplate(msg string) *messageTemplate {
+	t := &messageTemplate{}
+	var text strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if (c == '{' || c == '}') && i+1 < len(msg) && msg[i+1] == c {
+			text.WriteByte(c)
+			i++
+			continue
+		}
+		if c == '{' {
+			if end := strings.IndexByte(msg[i:], '}'); end > 0 {
+				if m := _template_name_regex.FindStringSubmatch(msg[i+1 : i+end]); m != nil {
+					if text.Len() > 0 {
+						t.parts = append(t.parts, templatePart{text: text.String()})
+						text.Reset()
+					}
+					t.parts = append(t.parts, templatePart{field: m[1], text: msg[i : i+end+1]})
+					i += end
+					continue
+				}
+			}
+		}
+		text.WriteByte(c)
+	}
+	if text.Len() > 0 {
+		t.parts = append(t.parts, templatePart{text: text.String()})
+	}
+	return t
+}
+
+// renderTemplate replaces the placeholders of the message of ent with the
+// values of fields of the same key when the pattern has %message{template},
+// and keeps the template for %template. The fields used are left out of
+// %fields unless template=keep. Placeholders without a field are written
+// as they are.
+func (enc *logbackEncoder) renderTemplate(ent *zapcore.Entry, fields []zapcore.Field) {
+	if !enc.render_template {
+		return
+	}
+	t := messageTemplateOf(ent.Message)
+	if t == nil {
+		return
+	}
+	buf := bufferpool.Get()
+	defer buf.Free()
+	var used []int
+	for _, part := range t.parts {
+		if part.field == "" {
+			buf.AppendString(part.text)
+			continue
+		}
+		i := templateFieldOf(fields, part.field, used)
+		if i < 0 {
+			buf.AppendString(part.text)
+			continue
+		}
+		appendTemplateValue(buf, fields[i])
+		if !slices.Contains(used, i) {
+			used = append(used, i)
+		}
+	}
+	if !enc.keep_template_fields {
+		enc.template_fields = used
+	}
+	enc.template = ent.Message
+	ent.Message = buf.String()
+}
+
+// templateFieldOf returns the index of the first field named key that is
+// not in used, so "{uid} {uid}" writes two fields uid, or else of the first
+// field named key; -1 when there is none.
+func templateFieldOf(fields []zapcore.Field, key string, used []int) int {
+	first := -1
+	for i, f := range fields {
+		if f.Key != key {
+			continue
+		}
+		if !slices.Contains(used, i) {
+			return i
+		}
+		if first < 0 {
+			first = i
+		}
+	}
+	return first
+}
+
+// messageTemplate returns the message as logged, before renderTemplate.
+func (enc *logbackEncoder) messageTemplate(ent *zapcore.Entry) string {
+	if enc.template != "" {
+		return enc.template
+	}
+	return ent.Message
+}
+
+// appendTemplateValue appends the value of f as text: strings without
+// quotes, objects and arrays as JSON.
+func appendTemplateValue(buf *buffer.Buffer, f zapcore.Field) {
+	m := zapcore.NewMapObjectEncoder()
+	f.AddTo(m)
+	switch v := m.Fields[f.Key].(type) {
+	case string:
+		buf.AppendString(v)
+	case time.Time:
+		buf.AppendString(v.Format(time.RFC3339Nano))
+	case map[string]interface{}, []interface{}:
+		data, err := json.Marshal(v)
+		if err != nil {
+			fmt.Fprint(buf, v)
+			return
+		}
+		buf.AppendBytes(data)
+	default:
+		fmt.Fprint(buf, v)
+	}
+}
+
+// templateAction is %template, the message as logged, e.g. "user {uid}
+// paid {amount}" while %message{template} writes "user 7 paid 9.5".
+type templateAction struct{}
+
+func newTemplateAction(config string) (Action, error) {
+	if config != "" {
+		return nil, fmt.Errorf("%%template has no config, got %q", config)
+	}
+	return templateAction{}, nil
+}
+
+func (templateAction) AppendEntry(w ActionWriter, ent *zapcore.Entry, fields []zapcore.Field) {
+	appendEscapedMessage(w.enc.buf, w.enc.defaultMessageEscape(), w.enc.messageTemplate(ent))
+}
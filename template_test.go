@@ -0,0 +1,104 @@
+package zaplogback
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestMessageTemplate(t *testing.T) {
+	ent := zapcore.Entry{Message: "user {uid} paid {amount}"}
+	paid := []zapcore.Field{zap.Int("uid", 7), zap.Float64("amount", 9.5), zap.String("currency", "CNY")}
+
+	tests := []struct {
+		format string
+		msg    string
+		fields []zapcore.Field
+		want   string
+	}{
+		{`%message{template} %fields`, ent.Message, paid, `user 7 paid 9.5 {"currency":CNY}`},
+		{`%message{template=keep} %fields`, ent.Message, paid, `user 7 paid 9.5 {"uid":7,"amount":9.5,"currency":CNY}`},
+		{`%message %fields`, ent.Message, paid, `user {uid} paid {amount} {"uid":7,"amount":9.5,"currency":CNY}`},
+		{`%template | %message{template} %x{currency}`, ent.Message, paid, `user {uid} paid {amount} | user 7 paid 9.5 CNY`},
+		{`%message{template,escape=quote}`, "hi {name}", []zapcore.Field{zap.String("name", "a\nb")}, `"hi a\nb"`},
+		{`%message{template} %fields`, "{{literal}} {missing} {@user} {a b}", []zapcore.Field{zap.String("user", "li")}, `{literal} {missing} li {a b} {}`},
+		{`%message{template}`, "took {d} at {t}: {error}",
+			[]zapcore.Field{zap.Duration("d", 1500*time.Millisecond), zap.Time("t", time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)), zap.Error(errors.New("boom"))},
+			`took 1.5s at 2024-05-01T10:00:00Z: boom`},
+		{`%message{template}`, "tags {tags} of {user}", []zapcore.Field{zap.Strings("tags", []string{"a", "b"}), zap.Any("user", map[string]any{"id": 1})},
+			`tags ["a","b"] of {"id":1}`},
+		{`%message{template} %template`, "no placeholders", nil, `no placeholders no placeholders`},
+		// 只去掉用到的那个字段
+		{`%message{template} %fields`, "user {uid}", []zapcore.Field{zap.Int("uid", 1), zap.Int("uid", 2)}, `user 1 {"uid":2}`},
+		{`%message{template} %fields`, "from {uid} to {uid}", []zapcore.Field{zap.Int("uid", 1), zap.Int("uid", 2)}, `from 1 to 2 {}`},
+		{`%message{template} %fields`, "{uid} again {uid}", []zapcore.Field{zap.Int("uid", 1)}, `1 again 1 {}`},
+	}
+	for _, tt := range tests {
+		ent.Message = tt.msg
+		if got := encodeForTest(t, tt.format, ent, tt.fields...); got != tt.want {
+			t.Errorf("%s %q:\ngot  %s\nwant %s", tt.format, tt.msg, got, tt.want)
+		}
+	}
+
+	if messageTemplateOf("user {uid} paid {amount}") != messageTemplateOf("user {uid} paid {amount}") {
+		t.Errorf("template not cached")
+	}
+	if messageTemplateOf("plain") != nil {
+		t.Errorf("plain message parsed")
+	}
+	if messageTemplateOf(`{"not": "a template"}`) == nil {
+		t.Errorf("message with braces not parsed")
+	}
+	if _, cached := _template_cache.Load(`{"not": "a template"}`); cached {
+		t.Errorf("message without placeholders cached")
+	}
+
+	for _, format := range []string{`%message{template=drop}`, `%template{x}`} {
+		if _, err := Compile(format); err == nil {
+			t.Errorf("%s: expected an error", format)
+		}
+	}
+}
+
+func TestMessageTemplateJSONLayout(t *testing.T) {
+	cfg := zap.NewProductionEncoderConfig()
+	cfg.TimeKey = ""
+	cfg.SkipLineEnding = true
+	enc, err := NewJSONLayoutEncoder(cfg, `%message{template} %template %fields`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := enc.EncodeEntry(zapcore.Entry{Message: "user {uid} paid"}, []zapcore.Field{zap.Int("uid", 7), zap.Int("n", 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), `{"msg":"user 7 paid","template":"user {uid} paid","n":1}`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	got := encodeForTest(t, `%message{template=keep} %json{template,fields}`, zapcore.Entry{Message: "user {uid}"}, zap.Int("uid", 7))
+	if want := `user 7 {"template":"user {uid}","uid":7}`; got != want {
+		t.Errorf("%%json: got %s, want %s", got, want)
+	}
+
+	// 指纹按模板计算
+	a := encodeForTest(t, `%message{template} %fingerprint`, zapcore.Entry{Message: "user {uid}"}, zap.Int("uid", 7))
+	b := encodeForTest(t, `%message{template} %fingerprint`, zapcore.Entry{Message: "user {uid}"}, zap.String("uid", "x"))
+	if a[len(a)-10:] != b[len(b)-10:] {
+		t.Errorf("fingerprints differ: %s, %s", a, b)
+	}
+}
+
+func TestParserTemplate(t *testing.T) {
+	p, err := NewParser(`%level %message{template,escape=quote} [%template]`, zap.NewProductionEncoderConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, ok := p.ParseRecord(`info "user 7 paid" [user {uid} paid]`)
+	if !ok || r.Message != "user 7 paid" || r.Values["template"] != "user {uid} paid" {
+		t.Errorf("ParseRecord = %+v, %v", r, ok)
+	}
+}
@@ -0,0 +1,630 @@
+package zaplogback
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// A Diagnostic reports a part of a foreign format that could not be
+// translated exactly.
+type Diagnostic struct {
+	// Line is the line in the source file, 0 if unknown.
+	Line int
+	// Offset is the byte offset in the source format, -1 if unknown.
+	Offset  int
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	switch {
+	case d.Line > 0 && d.Offset >= 0:
+		return fmt.Sprintf("line %d, offset %d: %s", d.Line, d.Offset, d.Message)
+	case d.Line > 0:
+		return fmt.Sprintf("line %d: %s", d.Line, d.Message)
+	case d.Offset >= 0:
+		return fmt.Sprintf("offset %d: %s", d.Offset, d.Message)
+	default:
+		return d.Message
+	}
+}
+
+// logback 的默认日期格式
+const _logback_default_date_format = "yyyy-MM-dd HH:mm:ss,SSS"
+
+// TranslateLogbackPattern translates a logback PatternLayout into a
+// zaplogback log format, e.g.
+//
+//	%d{yyyy-MM-dd HH:mm:ss.SSS} %-5level %logger{36} - %msg%n
+//
+// becomes
+//
+//	%date{%Y-%m-%d %H:%M:%S.%3f} %level{map=debug:DEBUG,info:INFO ,warn:WARN ,error:ERROR,dpanic:DPANIC,panic:PANIC,fatal:FATAL} %logger{36} - %message
+//
+// The padding of the level is translated into padded %level{map=...}
+// names; other format modifiers, conversion words and options without an
+// equivalent are dropped and reported. A trailing %n is dropped because the encoder ends every line
+// with EncoderConfig.LineEnding.
+func TranslateLogbackPattern(logback_pattern string) (string, []Diagnostic) {
+	t := logbackTranslator{src: logback_pattern}
+	t.translate()
+	return strings.TrimSuffix(t.out.String(), "\n"), t.diagnostics
+}
+
+// TranslateLog4j2Pattern translates a log4j2 PatternLayout into a
+// zaplogback log format, e.g.
+//
+//	%d{ISO8601} [%t] %-5p %c - %m%n
+//
+// becomes
+//
+//	%date{%Y-%m-%dT%H:%M:%S,%3f} [] %level{map=debug:DEBUG,info:INFO ,warn:WARN ,error:ERROR,dpanic:DPANIC,panic:PANIC,fatal:FATAL} %logger - %message
+//
+// with a diagnostic for %t, which has no equivalent. %c{N} keeps N
+// segments in log4j2 and is dropped with a diagnostic.
+func TranslateLog4j2Pattern(log4j2_pattern string) (string, []Diagnostic) {
+	t := logbackTranslator{src: log4j2_pattern, log4j2: true}
+	t.translate()
+	return strings.TrimSuffix(t.out.String(), "\n"), t.diagnostics
+}
+
+// logbackTranslator translates logback and log4j2 PatternLayouts, which
+// share most of their syntax.
+type logbackTranslator struct {
+	src         string
+	pos         int
+	out         strings.Builder
+	diagnostics []Diagnostic
+	// depth of %(...) and %word(...) groups
+	depth int
+	// log4j2 PatternLayout instead of logback
+	log4j2 bool
+	// offset of src in the pattern being translated, for nested patterns
+	base int
+}
+
+func (t *logbackTranslator) report(offset int, format string, args ...interface{}) {
+	t.diagnostics = append(t.diagnostics, Diagnostic{Offset: t.base + offset, Message: fmt.Sprintf(format, args...)})
+}
+
+// nested translates a pattern embedded in an option, like the one of
+// log4j2's %highlight{...}.
+func (t *logbackTranslator) nested(offset int, pattern string) {
+	inner := logbackTranslator{src: pattern, log4j2: t.log4j2, base: t.base + offset}
+	inner.translate()
+	t.out.WriteString(inner.out.String())
+	t.diagnostics = append(t.diagnostics, inner.diagnostics...)
+}
+
+func (t *logbackTranslator) translate() {
+	for t.pos < len(t.src) {
+		c := t.src[t.pos]
+		switch {
+		case c == '\\' && t.pos+1 < len(t.src):
+			// 转义字符
+			t.out.WriteByte(t.src[t.pos+1])
+			t.pos += 2
+		case c == ')' && t.depth > 0:
+			t.depth--
+			t.pos++
+		case c == '%':
+			t.conversion()
+		default:
+			t.out.WriteByte(c)
+			t.pos++
+		}
+	}
+}
+
+// conversion translates %[modifier]word{option}... at t.pos.
+func (t *logbackTranslator) conversion() {
+	start := t.pos
+	t.pos++
+
+	modifier := t.scan(func(c byte) bool { return c == '-' || c == '.' || (c >= '0' && c <= '9') })
+
+	if t.pos < len(t.src) && t.src[t.pos] == '(' {
+		// %(...) 分组, 只保留内容
+		t.pos++
+		t.depth++
+		if modifier != "" {
+			t.report(start, "format modifier %q of a group is not supported, ignored", modifier)
+		}
+		return
+	}
+
+	word := t.scan(func(c byte) bool { return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' })
+	if word == "" {
+		if t.pos < len(t.src) && t.src[t.pos] == '%' {
+			t.report(start, "literal %%%% is not supported, written as %%")
+			t.pos++
+		}
+		t.out.WriteByte('%')
+		t.out.WriteString(modifier)
+		return
+	}
+
+	if t.pos < len(t.src) && t.src[t.pos] == '(' {
+		// %highlight(...) 等组合关键字, 只保留内容
+		t.pos++
+		t.depth++
+		t.report(start, "composite %%%s(...) is not supported, its content is kept", word)
+		t.options()
+		return
+	}
+
+	options_start := t.pos
+	options := t.options()
+	if level, ok := paddedLevelPattern(word, modifier); ok && len(options) == 0 {
+		t.out.WriteString(level)
+		return
+	}
+	if modifier != "" {
+		t.report(start, "format modifier %q of %%%s is not supported, ignored", modifier, word)
+	}
+	if t.log4j2 && t.log4j2Word(start, options_start, word, options) {
+		return
+	}
+	t.word(start, word, options)
+}
+
+func (t *logbackTranslator) scan(accept func(c byte) bool) string {
+	start := t.pos
+	for t.pos < len(t.src) && accept(t.src[t.pos]) {
+		t.pos++
+	}
+	return t.src[start:t.pos]
+}
+
+// options reads {a}{b}... after a conversion word. Braces may nest, as in
+// log4j2's %highlight{%d{HH:mm}}.
+func (t *logbackTranslator) options() []string {
+	var options []string
+	for t.pos < len(t.src) && t.src[t.pos] == '{' {
+		end := matchingBrace(t.src[t.pos:])
+		if end < 0 {
+			break
+		}
+		options = append(options, t.src[t.pos+1:t.pos+end])
+		t.pos += end + 1
+	}
+	return options
+}
+
+// matchingBrace returns the index of the '}' closing the '{' at s[0], or -1.
+func matchingBrace(s string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func (t *logbackTranslator) word(start int, word string, options []string) {
+	option := func(i int) string {
+		if i < len(options) {
+			return options[i]
+		}
+		return ""
+	}
+	ignoreOptions := func(from int) {
+		if len(options) > from {
+			t.report(start, "options %q of %%%s are not supported, ignored", options[from:], word)
+		}
+	}
+
+	switch word {
+	case "d", "date":
+		date_format := option(0)
+		if named, ok := t.namedDateFormat(date_format); ok {
+			date_format = named
+		}
+		strftime, diagnostics := TranslateSimpleDateFormat(date_format)
+		for _, d := range diagnostics {
+			t.report(start, "%%%s: %s", word, d.Message)
+		}
+		ignoreOptions(1)
+		t.out.WriteString("%date{" + strftime + "}")
+	case "p", "le", "level":
+		t.out.WriteString("%level{upper}")
+	case "lo", "logger", "c":
+		// logback 的 %logger{36} 是缩写后的长度, log4j2 的 %c{1} 是保留的段数
+		if length, err := strconv.Atoi(option(0)); err == nil && length >= 0 && !t.log4j2 {
+			ignoreOptions(1)
+			t.out.WriteString("%logger{" + option(0) + "}")
+			return
+		}
+		ignoreOptions(0)
+		t.out.WriteString("%logger")
+	case "m", "msg", "message":
+		t.out.WriteString("%message")
+	case "n":
+		t.out.WriteByte('\n')
+	case "X", "mdc":
+		key, _, has_default := strings.Cut(option(0), ":-")
+		if has_default {
+			t.report(start, "default value of %%%s{%s} is not supported, ignored", word, option(0))
+		}
+		if key == "" {
+			t.out.WriteString("%fields")
+		} else {
+			t.out.WriteString("%x{" + key + "}")
+		}
+	case "r", "relative":
+		t.out.WriteString("%relative")
+	case "lsn":
+		t.out.WriteString("%seq")
+	case "caller":
+		ignoreOptions(0)
+		t.out.WriteString("%caller")
+	case "F", "file":
+		t.out.WriteString("%file")
+	case "L", "line":
+		t.out.WriteString("%line")
+	case "M", "method":
+		t.out.WriteString("%method")
+	case "ex", "exception", "throwable", "xEx", "xException", "xThrowable", "rEx", "rootException", "nopex", "nopexception":
+		// zap 的 stacktrace 总是追加在日志之后
+	default:
+		t.report(start, "conversion word %%%s is not supported, dropped", word)
+	}
+}
+
+// namedDateFormat resolves the named formats of %d{...}.
+func (t *logbackTranslator) namedDateFormat(name string) (string, bool) {
+	if !t.log4j2 {
+		switch name {
+		case "", "ISO8601":
+			return _logback_default_date_format, true
+		}
+		return "", false
+	}
+	switch name {
+	case "", "DEFAULT":
+		return "yyyy-MM-dd HH:mm:ss,SSS", true
+	case "ISO8601":
+		return "yyyy-MM-dd'T'HH:mm:ss,SSS", true
+	case "ISO8601_BASIC":
+		return "yyyyMMdd'T'HHmmss,SSS", true
+	case "ISO8601_OFFSET_DATE_TIME_HHMM":
+		return "yyyy-MM-dd'T'HH:mm:ss,SSSZ", true
+	case "ABSOLUTE":
+		return "HH:mm:ss,SSS", true
+	case "COMPACT":
+		return "yyyyMMddHHmmssSSS", true
+	case "DATE":
+		return "dd MMM yyyy HH:mm:ss,SSS", true
+	}
+	return "", false
+}
+
+// log4j2Word translates the conversion words whose meaning differs in
+// log4j2. It reports whether word was handled.
+func (t *logbackTranslator) log4j2Word(start int, options_start int, word string, options []string) bool {
+	switch word {
+	case "p", "level":
+		t.out.WriteString(log4j2LevelPattern(options, func(format string, args ...interface{}) {
+			t.report(start, format, args...)
+		}))
+	case "highlight", "style", "notEmpty", "varsNotEmpty", "variablesNotEmpty", "maxLen", "maxLength", "enc", "encode":
+		// 第一个选项是子格式, 其余选项是样式等, 只保留子格式
+		if len(options) > 0 {
+			t.nested(options_start+1, options[0])
+		}
+		t.report(start, "%%%s{...} is not supported, its pattern is kept", word)
+	case "x", "NDC":
+		t.report(start, "conversion word %%%s (NDC) is not supported, dropped", word)
+	case "K", "map", "MAP":
+		t.out.WriteString("%fields")
+		if len(options) > 0 {
+			t.report(start, "options %q of %%%s are not supported, ignored", options, word)
+		}
+	case "sn", "sequenceNumber":
+		t.out.WriteString("%seq")
+	case "l", "location":
+		t.out.WriteString("%method(%file:%line)")
+	default:
+		return false
+	}
+	return true
+}
+
+// paddedLevelPattern translates the padding of %-5level or %5p into the
+// names of %level{map=...}, padded with spaces like logback; other format
+// modifiers are not supported.
+func paddedLevelPattern(word string, modifier string) (string, bool) {
+	if word != "p" && word != "le" && word != "level" {
+		return "", false
+	}
+	width, err := strconv.Atoi(modifier)
+	if err != nil || width == 0 {
+		return "", false
+	}
+	var mapping []string
+	for level := zapcore.DebugLevel; level <= zapcore.FatalLevel; level++ {
+		mapping = append(mapping, level.String()+":"+fmt.Sprintf("%*s", width, level.CapitalString()))
+	}
+	return "%level{map=" + strings.Join(mapping, ",") + "}", true
+}
+
+// log4j2LevelPattern translates the options of log4j2's %level, e.g.
+// {WARN=W, DEBUG=D}, {length=1} or {lowerCase=true}.
+func log4j2LevelPattern(options []string, report func(format string, args ...interface{})) string {
+	if len(options) == 0 {
+		return "%level{upper}"
+	}
+	var mapping []string
+	lower := false
+	for _, option := range strings.Split(options[0], ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(option), "=")
+		switch key {
+		case "length":
+			if value != "1" {
+				report("%%level{length=%s} is not supported, ignored", value)
+				continue
+			}
+			return "%level{letter}"
+		case "lowerCase":
+			lower = value == "true"
+		default:
+			level, err := parseLevel(strings.ToLower(key))
+			if err != nil {
+				report("%%level option %q is not supported, ignored", option)
+				continue
+			}
+			mapping = append(mapping, level.String()+":"+value)
+		}
+	}
+	if len(mapping) > 0 {
+		return "%level{map=" + strings.Join(mapping, ",") + "}"
+	}
+	if lower {
+		return "%level{lower}"
+	}
+	return "%level{upper}"
+}
+
+// TranslateSimpleDateFormat translates a Java SimpleDateFormat, as used by
+// logback's and log4j2's %d{...}, into the strftime codes of %date, e.g.
+// "yyyy-MM-dd HH:mm:ss.SSS" becomes "%Y-%m-%d %H:%M:%S.%3f".
+func TranslateSimpleDateFormat(date_format string) (string, []Diagnostic) {
+	var out strings.Builder
+	var diagnostics []Diagnostic
+
+	for i := 0; i < len(date_format); {
+		c := date_format[i]
+
+		if c == '\'' {
+			// 'quoted' 普通字符串, '' 表示单引号
+			if i+1 < len(date_format) && date_format[i+1] == '\'' {
+				out.WriteByte('\'')
+				i += 2
+				continue
+			}
+			// 引号内的 '' 也表示单引号
+			i++
+			for i < len(date_format) {
+				if date_format[i] != '\'' {
+					out.WriteByte(date_format[i])
+					i++
+					continue
+				}
+				if i+1 < len(date_format) && date_format[i+1] == '\'' {
+					out.WriteByte('\'')
+					i += 2
+					continue
+				}
+				i++
+				break
+			}
+			continue
+		}
+
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			out.WriteByte(c)
+			i++
+			continue
+		}
+
+		n := 1
+		for i+n < len(date_format) && date_format[i+n] == c {
+			n++
+		}
+
+		if c == 'S' && !strings.HasSuffix(out.String(), ".") && !strings.HasSuffix(out.String(), ",") {
+			// Go 只在 . 或 , 之后输出秒的小数部分
+			diagnostics = append(diagnostics, Diagnostic{Offset: i,
+				Message: fmt.Sprintf("date pattern %q is only supported after '.' or ',', dropped", date_format[i:i+n])})
+			i += n
+			continue
+		}
+		if c == 'H' && n == 1 {
+			diagnostics = append(diagnostics, Diagnostic{Offset: i,
+				Message: "date pattern \"H\" is not supported, the hour is zero-padded as HH"})
+		}
+
+		code, ok := simpleDateFormatCode(c, n)
+		if !ok {
+			diagnostics = append(diagnostics, Diagnostic{Offset: i,
+				Message: fmt.Sprintf("date pattern %q is not supported, dropped", date_format[i:i+n])})
+		}
+		out.WriteString(code)
+		i += n
+	}
+	return out.String(), diagnostics
+}
+
+func simpleDateFormatCode(c byte, n int) (string, bool) {
+	switch c {
+	case 'y':
+		if n == 2 {
+			return "%y", true
+		}
+		return "%Y", true
+	case 'M', 'L':
+		switch {
+		case n >= 4:
+			return "%B", true
+		case n == 3:
+			return "%b", true
+		}
+		if n == 1 {
+			return "%-m", true
+		}
+		return "%m", true
+	case 'd':
+		if n == 1 {
+			return "%-d", true
+		}
+		return "%d", true
+	case 'D':
+		return "%j", true
+	case 'E':
+		if n >= 4 {
+			return "%A", true
+		}
+		return "%a", true
+	case 'a':
+		return "%p", true
+	case 'H':
+		return "%H", true
+	case 'h':
+		if n == 1 {
+			return "%-I", true
+		}
+		return "%I", true
+	case 'm':
+		if n == 1 {
+			return "%-M", true
+		}
+		return "%M", true
+	case 's':
+		if n == 1 {
+			return "%-S", true
+		}
+		return "%S", true
+	case 'S':
+		if n > 9 {
+			n = 9
+		}
+		return "%" + strconv.Itoa(n) + "f", true
+	case 'z':
+		return "%Z", true
+	case 'Z', 'X':
+		return "%z", true
+	}
+	return "", false
+}
+
+// _python_log_record_regex_pattern matches %(name)s with optional flags,
+// width and precision.
+var _python_log_record_regex_pattern = regexp.MustCompile(`%(\((\w+)\)([#0\- +]*\d*(?:\.\d+)?)[diouxXeEfFgGcrsa]|%)`)
+
+// TranslatePythonLogFormat translates a %-style format of Python's logging
+// module into a zaplogback log format, e.g.
+//
+//	%(asctime)s %(levelname)-8s %(name)s: %(message)s
+//
+// becomes
+//
+//	%date{%Y-%m-%d %H:%M:%S,%3f} %level{upper} %logger: %message
+//
+// datefmt is the datefmt of logging.Formatter; it is already in strftime
+// syntax. When it is empty, asctime uses Python's default format.
+func TranslatePythonLogFormat(format string, datefmt string) (string, []Diagnostic) {
+	var out strings.Builder
+	var diagnostics []Diagnostic
+	report := func(offset int, format string, args ...interface{}) {
+		diagnostics = append(diagnostics, Diagnostic{Offset: offset, Message: fmt.Sprintf(format, args...)})
+	}
+
+	date_format := "%Y-%m-%d %H:%M:%S,%3f"
+	if datefmt != "" {
+		date_format = datefmt
+		for _, d := range CheckStrftime(datefmt) {
+			report(-1, "datefmt: %s", d.Message)
+		}
+	}
+
+	last := 0
+	for _, m := range _python_log_record_regex_pattern.FindAllStringSubmatchIndex(format, -1) {
+		out.WriteString(format[last:m[0]])
+		last = m[1]
+
+		if m[4] < 0 {
+			// %% 表示 %
+			out.WriteByte('%')
+			if last < len(format) && isWordByte(format[last]) {
+				report(m[0], "a literal %% followed by a word is read as an action")
+			}
+			continue
+		}
+
+		attribute := format[m[4]:m[5]]
+		if modifier := format[m[6]:m[7]]; modifier != "" {
+			report(m[0], "format modifier %q of %%(%s) is not supported, ignored", modifier, attribute)
+		}
+
+		switch attribute {
+		case "asctime":
+			out.WriteString("%date{" + date_format + "}")
+		case "msecs":
+			out.WriteString("%date{%3f}")
+		case "levelname":
+			out.WriteString("%level{upper}")
+		case "levelno":
+			out.WriteString("%level{map=debug:10,info:20,warn:30,error:40,dpanic:50,panic:50,fatal:50}")
+		case "name":
+			out.WriteString("%logger")
+		case "message", "msg":
+			out.WriteString("%message")
+		case "relativeCreated":
+			out.WriteString("%relative")
+		case "pathname":
+			out.WriteString("%file{full}")
+		case "filename":
+			out.WriteString("%file")
+		case "lineno":
+			out.WriteString("%line")
+		case "funcName":
+			out.WriteString("%method")
+		default:
+			report(m[0], "attribute %%(%s) is not supported, dropped", attribute)
+		}
+	}
+	out.WriteString(format[last:])
+	return out.String(), diagnostics
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// _strftime_regex_pattern matches the codes of a strftime layout.
+var _strftime_regex_pattern = regexp.MustCompile(`%(\d*f|-[dmIMS]|.)`)
+
+// CheckStrftime reports the codes of a %date layout that StrftimeFormatLayout
+// does not support; they are written literally.
+func CheckStrftime(layout string) []Diagnostic {
+	var diagnostics []Diagnostic
+	for _, m := range _strftime_regex_pattern.FindAllStringSubmatchIndex(layout, -1) {
+		code := layout[m[2]:m[3]]
+		if strings.HasSuffix(code, "f") || strings.HasPrefix(code, "-") || strings.Contains("aAwdbBmyYHIpMSzZj", code) {
+			continue
+		}
+		diagnostics = append(diagnostics, Diagnostic{Offset: m[0],
+			Message: fmt.Sprintf("strftime code %%%s is not supported", code)})
+	}
+	return diagnostics
+}
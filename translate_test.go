@@ -0,0 +1,122 @@
+package zaplogback
+
+import "testing"
+
+func TestTranslateLogbackPattern(t *testing.T) {
+	tests := []struct {
+		pattern     string
+		want        string
+		diagnostics int
+	}{
+		{`%d{yyyy-MM-dd HH:mm:ss.SSS} %-5level %logger{36} - %msg%n`, `%date{%Y-%m-%d %H:%M:%S.%3f} %level{map=debug:DEBUG,info:INFO ,warn:WARN ,error:ERROR,dpanic:DPANIC,panic:PANIC,fatal:FATAL} %logger{36} - %message`, 0},
+		{`%5p %logger{abc} %.5level`, `%level{map=debug:DEBUG,info: INFO,warn: WARN,error:ERROR,dpanic:DPANIC,panic:PANIC,fatal:FATAL} %logger %level{upper}`, 2},
+		{`%date %p [%X{tid}] %m%n`, `%date{%Y-%m-%d %H:%M:%S,%3f} %level{upper} [%x{tid}] %message`, 0},
+		{`%d{HH:mm:ss} [%thread] %msg %X%n%ex`, `%date{%H:%M:%S} [] %message %fields`, 1},
+		{`%d{yyyy-MM-dd'T'HH:mm:ss} %highlight(%level) %m`, `%date{%Y-%m-%dT%H:%M:%S} %level{upper} %message`, 1},
+	}
+	for _, tt := range tests {
+		got, diagnostics := TranslateLogbackPattern(tt.pattern)
+		if got != tt.want {
+			t.Errorf("TranslateLogbackPattern(%q) = %q, want %q", tt.pattern, got, tt.want)
+		}
+		if len(diagnostics) != tt.diagnostics {
+			t.Errorf("TranslateLogbackPattern(%q) diagnostics = %v, want %d", tt.pattern, diagnostics, tt.diagnostics)
+		}
+		if _, err := Compile(got); err != nil {
+			t.Errorf("Compile(%q): %v", got, err)
+		}
+	}
+}
+
+func TestTranslateLog4j2Pattern(t *testing.T) {
+	tests := []struct {
+		pattern     string
+		want        string
+		diagnostics int
+	}{
+		{`%d{ISO8601} [%t] %-5p %c - %m%n`, `%date{%Y-%m-%dT%H:%M:%S,%3f} [] %level{map=debug:DEBUG,info:INFO ,warn:WARN ,error:ERROR,dpanic:DPANIC,panic:PANIC,fatal:FATAL} %logger - %message`, 1},
+		{`%d{ABSOLUTE} %highlight{%level{length=1}} %X{tid} %sn %m`, `%date{%H:%M:%S,%3f} %level{letter} %x{tid} %seq %message`, 1},
+		{`%level{WARN=W, DEBUG=D} %m`, `%level{map=warn:W,debug:D} %message`, 0},
+		{`%d{HH:mm:ss.SSS}{GMT+0} %level{lowerCase=true} %m`, `%date{%H:%M:%S.%3f} %level{lower} %message`, 1},
+	}
+	for _, tt := range tests {
+		got, diagnostics := TranslateLog4j2Pattern(tt.pattern)
+		if got != tt.want {
+			t.Errorf("TranslateLog4j2Pattern(%q) = %q, want %q", tt.pattern, got, tt.want)
+		}
+		if len(diagnostics) != tt.diagnostics {
+			t.Errorf("TranslateLog4j2Pattern(%q) diagnostics = %v, want %d", tt.pattern, diagnostics, tt.diagnostics)
+		}
+		if _, err := Compile(got); err != nil {
+			t.Errorf("Compile(%q): %v", got, err)
+		}
+	}
+}
+
+func TestTranslatePythonLogFormat(t *testing.T) {
+	tests := []struct {
+		format      string
+		datefmt     string
+		want        string
+		diagnostics int
+	}{
+		{`%(asctime)s %(levelname)-8s %(name)s: %(message)s`, "", `%date{%Y-%m-%d %H:%M:%S,%3f} %level{upper} %logger: %message`, 1},
+		{`%(asctime)s.%(msecs)03d %(process)d %(message)s 100%%`, "%H:%M:%S", `%date{%H:%M:%S}.%date{%3f}  %message 100%`, 2},
+		{`%(asctime)s %(message)s`, "%H:%M:%S %e", `%date{%H:%M:%S %e} %message`, 1},
+	}
+	for _, tt := range tests {
+		got, diagnostics := TranslatePythonLogFormat(tt.format, tt.datefmt)
+		if got != tt.want {
+			t.Errorf("TranslatePythonLogFormat(%q) = %q, want %q", tt.format, got, tt.want)
+		}
+		if len(diagnostics) != tt.diagnostics {
+			t.Errorf("TranslatePythonLogFormat(%q) diagnostics = %v, want %d", tt.format, diagnostics, tt.diagnostics)
+		}
+	}
+}
+
+func TestTranslateSimpleDateFormat(t *testing.T) {
+	tests := []struct {
+		date_format string
+		want        string
+		diagnostics int
+	}{
+		{"yyyy-MM-dd HH:mm:ss.SSS", "%Y-%m-%d %H:%M:%S.%3f", 0},
+		{"yyyy-MM-dd'T'HH:mm:ssXXX", "%Y-%m-%dT%H:%M:%S%z", 0},
+		{"EEE, d MMM yy hh:mm a z", "%a, %-d %b %y %I:%M %p %Z", 0},
+		{"d/M/yyyy h:m:s", "%-d/%-m/%Y %-I:%-M:%-S", 0},
+		{"H:mm", "%H:%M", 1},
+		{"yyyyMMddHHmmssSSS", "%Y%m%d%H%M%S", 1},
+		{"HH:mm:ss,SSS", "%H:%M:%S,%3f", 0},
+		{"EEEE MMMM D 'o''clock' ''", "%A %B %j o'clock '", 0},
+		{"yyyy ww G", "%Y  ", 2},
+	}
+	for _, tt := range tests {
+		got, diagnostics := TranslateSimpleDateFormat(tt.date_format)
+		if got != tt.want {
+			t.Errorf("TranslateSimpleDateFormat(%q) = %q, want %q", tt.date_format, got, tt.want)
+		}
+		if len(diagnostics) != tt.diagnostics {
+			t.Errorf("TranslateSimpleDateFormat(%q) diagnostics = %v, want %d", tt.date_format, diagnostics, tt.diagnostics)
+		}
+	}
+
+	// Go 不能输出的格式不会被 %date 静默地写错
+	for _, format := range []string{`%d{yyyyMMddHHmmssSSS}`, `%d{H:mm}`} {
+		if _, err := Compile(format); err == nil {
+			t.Errorf("%s: expected an error", format)
+		}
+	}
+}
+
+func TestTranslateCallerParts(t *testing.T) {
+	if got, _ := TranslateLogbackPattern(`%F:%L %M %m`); got != `%file:%line %method %message` {
+		t.Errorf("logback: %q", got)
+	}
+	if got, _ := TranslateLog4j2Pattern(`%l %m`); got != `%method(%file:%line) %message` {
+		t.Errorf("log4j2: %q", got)
+	}
+	if got, _ := TranslatePythonLogFormat(`%(pathname)s %(filename)s:%(lineno)d %(funcName)s`, ""); got != `%file{full} %file:%line %method` {
+		t.Errorf("python: %q", got)
+	}
+}
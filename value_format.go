@@ -0,0 +1,223 @@
+package zaplogback
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+var (
+	// 选项从 name= 开始, 到下一个选项为止, time 的格式中可以有逗号
+	_fields_option_regex = regexp.MustCompile(`(?:^|,)\s*(encrypt|time|duration|binary|float|bool)=`)
+	_float_format_regex  = regexp.MustCompile(`^%[-+ #0]*\d*(?:\.\d+)?[eEfFgG]$`)
+
+	_duration_units = map[string]time.Duration{
+		"ns": time.Nanosecond,
+		"us": time.Microsecond,
+		"ms": time.Millisecond,
+		"s":  time.Second,
+	}
+)
+
+// valueFormat holds the value encoders of %fields{time=%H:%M:%S,
+// duration=ms, binary=hex, float=%.3f, bool=yes/no}, used for the values
+// of %fields and %x in place of the EncoderConfig:
+//
+//	time      a strftime layout, like %date
+//	duration  ns, us, ms or s for a number in that unit, string for 1.5s
+//	binary    base64 (zap's default) or hex
+//	float     a printf verb for floats, e.g. %.3f or %g
+//	bool      the texts of true and false, e.g. yes/no or 1/0
+type valueFormat struct {
+	encode_time zapcore.TimeEncoder
+	duration    string
+	binary      string
+	float       string
+	true_text   string
+	false_text  string
+}
+
+// checkJSONLayout returns an error when the pattern has value encoders,
+// which the JSON layout does not apply.
+func (p *Pattern) checkJSONLayout() error {
+	if p.logback_config.value_format != nil {
+		return errors.New("the time, duration, binary, float and bool options of %fields are not supported by the JSON layout, which keeps the types of zap's JSON encoder")
+	}
+	return nil
+}
+
+// parseFieldsConfig splits the config of %fields into the keys of encrypt=
+// and the value encoders, nil when there are none.
+func parseFieldsConfig(config string) ([]string, *valueFormat, error) {
+	starts := _fields_option_regex.FindAllStringSubmatchIndex(config, -1)
+	if len(starts) == 0 || strings.TrimSpace(config[:starts[0][0]]) != "" {
+		return nil, nil, fmt.Errorf("unknown %%fields option %q, want encrypt, time, duration, binary, float or bool", config)
+	}
+	var keys []string
+	var format *valueFormat
+	for i, m := range starts {
+		name := config[m[2]:m[3]]
+		end := len(config)
+		if i+1 < len(starts) {
+			end = starts[i+1][0]
+		}
+		value := strings.TrimSpace(config[m[1]:end])
+		if name == "encrypt" {
+			encrypt, err := parseEncryptKeys(value)
+			if err != nil {
+				return nil, nil, err
+			}
+			keys = append(keys, encrypt...)
+			continue
+		}
+		if format == nil {
+			format = &valueFormat{}
+		}
+		if err := format.set(name, value); err != nil {
+			return nil, nil, err
+		}
+	}
+	return keys, format, nil
+}
+
+func parseEncryptKeys(value string) ([]string, error) {
+	var keys []string
+	for _, key := range strings.Split(value, ",") {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			return nil, fmt.Errorf("empty key in encrypt=%s", value)
+		}
+		if _, err := path.Match(strings.ToLower(key), ""); err != nil {
+			return nil, fmt.Errorf("invalid key glob %q", key)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (f *valueFormat) set(name string, value string) error {
+	switch name {
+	case "time":
+		layout, err := StrftimeLayoutOf(value)
+		if err != nil {
+			return err
+		}
+		f.encode_time = TimeEncoderOf(layout)
+	case "duration":
+		if _, ok := _duration_units[value]; !ok && value != "string" {
+			return fmt.Errorf("unknown duration format %q, want ns, us, ms, s or string", value)
+		}
+		f.duration = value
+	case "binary":
+		if value != "base64" && value != "hex" {
+			return fmt.Errorf("unknown binary format %q, want base64 or hex", value)
+		}
+		f.binary = value
+	case "float":
+		if !_float_format_regex.MatchString(value) {
+			return fmt.Errorf("invalid float format %q, want a verb such as %%.3f or %%g", value)
+		}
+		f.float = value
+	case "bool":
+		true_text, false_text, ok := strings.Cut(value, "/")
+		if !ok || true_text == "" || false_text == "" || true_text == false_text {
+			return fmt.Errorf("invalid bool format %q, want <true>/<false> such as yes/no", value)
+		}
+		f.true_text, f.false_text = true_text, false_text
+	}
+	return nil
+}
+
+// appendTime appends val with the time= layout, it reports false without
+// one.
+func (f *valueFormat) appendTime(enc *logbackEncoder, val time.Time) bool {
+	if f == nil || f.encode_time == nil {
+		return false
+	}
+	f.encode_time(val, enc)
+	return true
+}
+
+func (f *valueFormat) appendDuration(enc *logbackEncoder, val time.Duration) bool {
+	if f == nil || f.duration == "" {
+		return false
+	}
+	enc.addElementSeparator()
+	if f.duration == "string" {
+		enc.buf.AppendString(val.String())
+		return true
+	}
+	unit := _duration_units[f.duration]
+	if val%unit == 0 {
+		enc.buf.AppendInt(int64(val / unit))
+	} else {
+		enc.buf.AppendFloat(float64(val)/float64(unit), 64)
+	}
+	return true
+}
+
+// binaryText returns val in the binary= encoding.
+func (f *valueFormat) binaryText(val []byte) string {
+	if f != nil && f.binary == "hex" {
+		return hex.EncodeToString(val)
+	}
+	return base64.StdEncoding.EncodeToString(val)
+}
+
+func (f *valueFormat) appendFloat(enc *logbackEncoder, val float64) bool {
+	if f == nil || f.float == "" || math.IsNaN(val) || math.IsInf(val, 0) {
+		return false
+	}
+	enc.addElementSeparator()
+	enc.buf.AppendString(fmt.Sprintf(f.float, val))
+	return true
+}
+
+func (f *valueFormat) appendBool(enc *logbackEncoder, val bool) bool {
+	if f == nil || f.true_text == "" {
+		return false
+	}
+	enc.addElementSeparator()
+	if val {
+		enc.buf.AppendString(f.true_text)
+	} else {
+		enc.buf.AppendString(f.false_text)
+	}
+	return true
+}
+
+// appendFieldValue appends the value of f without its key, as %fields
+// writes it; strings as they are, like %x always did.
+func (enc *logbackEncoder) appendFieldValue(f zapcore.Field) {
+	switch f.Type {
+	case zapcore.StringType:
+		enc.buf.AppendString(f.String)
+		return
+	case zapcore.ErrorType:
+		if err, ok := f.Interface.(error); ok {
+			enc.buf.AppendString(errorMessage(err))
+		}
+		return
+	case zapcore.SkipType, zapcore.NamespaceType:
+		return
+	}
+	scratch := enc.clone()
+	defer func() {
+		scratch.buf.Free()
+		putlogbackEncoder(scratch)
+	}()
+	// 只保留 AddTo 写在 key 之后的值
+	scratch.addKey(f.Key)
+	key_len := scratch.buf.Len()
+	scratch.buf.Reset()
+	f.AddTo(scratch)
+	enc.buf.Write(scratch.buf.Bytes()[key_len:])
+}
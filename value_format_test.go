@@ -0,0 +1,94 @@
+package zaplogback
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestValueFormat(t *testing.T) {
+	at := time.Date(2024, 5, 1, 10, 30, 15, 250e6, time.UTC)
+	fields := []zapcore.Field{
+		zap.Time("at", at),
+		zap.Duration("took", 1500*time.Millisecond),
+		zap.Binary("raw", []byte{0xca, 0xfe}),
+		zap.Float64("ratio", 2.0/3),
+		zap.Bool("ok", true),
+		zap.Bools("flags", []bool{true, false}),
+	}
+	ent := zapcore.Entry{Time: at, Message: "m"}
+	SetDefaultKeyring(keyringForTest(t))
+	defer SetDefaultKeyring(nil)
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{`%fields`, `{"at":1714559415.2499998,"took":1.5,"raw":yv4=,"ratio":0.6666666666666666,"ok":true,"flags":[true false]}`},
+		{`%fields{time=%H:%M:%S, duration=ms, binary=hex, float=%.3f, bool=yes/no}`,
+			`{"at":10:30:15,"took":1500,"raw":cafe,"ratio":0.667,"ok":yes,"flags":[yes no]}`},
+		{`%fields{time=%H:%M:%S,%3f,duration=string}`, `{"at":10:30:15,250,"took":1.5s,"raw":yv4=,"ratio":0.6666666666666666,"ok":true,"flags":[true false]}`},
+		{`%fields{time=HH:mm,duration=s,float=%g}`, `{"at":10:30,"took":1.5,"raw":yv4=,"ratio":0.6666666666666666,"ok":true,"flags":[true false]}`},
+		// %date 不受 time= 影响
+		{`%date{%Y-%m-%d} %x{at} %x{took} %x{raw} %x{ratio} %x{ok}%fields{time=%H:%M,duration=ms,binary=hex,float=%.1f,bool=1/0,encrypt=none}`,
+			`2024-05-01 10:30 1500 cafe 0.7 1{"flags":[1 0]}`},
+		{`%x{at} %x{took}`, `1714559415.2499998 1.5`},
+	}
+	for _, tt := range tests {
+		if got := encodeForTest(t, tt.format, ent, fields...); got != tt.want {
+			t.Errorf("%s:\ngot  %s\nwant %s", tt.format, got, tt.want)
+		}
+	}
+
+	got := encodeForTest(t, `%fields{float=%.2f,duration=us}`, ent, zap.Float64("nan", math.NaN()), zap.Duration("d", 1500*time.Nanosecond))
+	if want := `{"nan":"NaN","d":1.5}`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	for _, format := range []string{`%fields{duration=h}`, `%fields{binary=base32}`, `%fields{float=%d}`, `%fields{bool=yes}`, `%fields{color=red}`, `%fields{encrypt=}`} {
+		if _, err := Compile(format); err == nil {
+			t.Errorf("%s: expected an error", format)
+		}
+	}
+}
+
+func TestValueFormatWith(t *testing.T) {
+	cfg := zap.NewProductionEncoderConfig()
+	cfg.SkipLineEnding = true
+	enc, err := BuildZaplogbackEncoder(cfg, `%message %fields{duration=ms,bool=on/off}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zap.Duration("timeout", 2*time.Second).AddTo(enc)
+	buf, err := enc.EncodeEntry(zapcore.Entry{Message: "m"}, []zapcore.Field{zap.Bool("retry", false)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), `m {"retry":off} "timeout":2000`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestValueFormatLimits(t *testing.T) {
+	got := encodeForTest(t, `%limit{field=2}%message %x{raw} %fields{binary=hex}`, zapcore.Entry{Message: "m"},
+		zap.Binary("raw", []byte{0xca, 0xfe, 0x01}))
+	if want := `m cafe…[truncated 1B] {}`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestValueFormatJSONLayout(t *testing.T) {
+	log_format := `%message %fields{binary=hex,bool=yes/no}`
+	if _, err := NewJSONLayoutEncoder(zap.NewProductionEncoderConfig(), log_format); err == nil {
+		t.Errorf("NewJSONLayoutEncoder(%q): expected an error", log_format)
+	}
+	cfg := NewConfig()
+	cfg.Encoding = _json_layout_encoding_name
+	cfg.Pattern = log_format
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Validate of the JSON layout of %q: expected an error", log_format)
+	}
+}